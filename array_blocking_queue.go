@@ -1,10 +1,13 @@
 package concurrent_queue
 
 import (
+	"concurrent_queue/errs"
 	"context"
 	"golang.org/x/sync/semaphore"
+	"math"
 	"sync"
 	"sync/atomic"
+	"time"
 	"unsafe"
 )
 
@@ -18,33 +21,83 @@ type ArrayBlockingQueue[T any] struct {
 	tail int
 	// 包含多少个元素
 	count int
+	// 容量，可以通过 SetCapacity 修改，data 的长度有可能比容量大
+	capacity int
+	// 缩容的时候没能立刻从 enqueueCap 拿走的令牌数量，出队的时候优先偿还
+	shrinkDebt int64
 	// zero 不能作为返回值返回，防止用户篡改
 	zero T
 
 	mutex *sync.RWMutex
 
+	// 两个信号量的大小都是 math.MaxInt64，通过预先拿走一部分令牌来表达容量，这样才能动态调整容量
 	enqueueCap *semaphore.Weighted
 	dequeueCap *semaphore.Weighted
+
+	// 队列是否已经关闭，受 mutex 保护
+	closed bool
+	// 关闭的时候会 close 掉，用于唤醒阻塞在信号量上的 goroutine
+	closeCh chan struct{}
+
+	// 未完成的任务，用于支持 TaskDone 和 Join
+	tasks *taskCounter
+	// 队列为空的时候会被关闭，有元素入队的时候会重新创建，受 mutex 保护
+	emptyCh chan struct{}
+
+	// 队列满了之后的处理策略
+	overflow OverflowPolicy
+	// 元素被丢弃时候的回调，可以为 nil
+	onDrop func(T)
+
+	stats *queueStats
+
+	// 开启了 WithSojournTracking 的时候记录每个元素的入队时间，和 data 一一对应，否则为 nil
+	enqueuedAt []time.Time
+	// 没有设置 TTL 并且元素也没有实现 Expirable 的时候为 nil
+	expiry *expiry[T]
 }
 
 // NewArrayBlockingQueue 创建一个有界阻塞队列
 // 容量会在最开始的时候就初始化好
 // capacity 必须为正数
-func NewArrayBlockingQueue[T any](capacity int) *ArrayBlockingQueue[T] {
+// 支持的 Option：WithOverflowPolicy, WithDropCallback, WithObserver, WithSojournTracking,
+// WithTTL, WithExpireCallback, WithExpirySweep
+func NewArrayBlockingQueue[T any](capacity int, opts ...Option[T]) *ArrayBlockingQueue[T] {
 	mutex := &sync.RWMutex{}
+	options := newQueueOptions(opts)
 
-	semaForEnqueue := semaphore.NewWeighted(int64(capacity))
-	semaForDequeue := semaphore.NewWeighted(int64(capacity))
+	semaForEnqueue := semaphore.NewWeighted(math.MaxInt64)
+	semaForDequeue := semaphore.NewWeighted(math.MaxInt64)
 
 	// error暂时不处理，因为目前没办法处理，只能考虑panic掉
+	// 只留下 capacity 个令牌
+	_ = semaForEnqueue.Acquire(context.TODO(), math.MaxInt64-int64(capacity))
 	// 相当于将信号量置空
-	_ = semaForDequeue.Acquire(context.TODO(), int64(capacity))
+	_ = semaForDequeue.Acquire(context.TODO(), math.MaxInt64)
+
+	// 最开始队列是空的
+	emptyCh := make(chan struct{})
+	close(emptyCh)
 
 	res := &ArrayBlockingQueue[T]{
 		data:       make([]T, capacity),
+		capacity:   capacity,
 		mutex:      mutex,
 		enqueueCap: semaForEnqueue,
 		dequeueCap: semaForDequeue,
+		closeCh:    make(chan struct{}),
+		tasks:      newTaskCounter(),
+		emptyCh:    emptyCh,
+		overflow:   options.overflow,
+		onDrop:     options.onDrop,
+		stats:      newQueueStats(capacity, options.observer),
+		expiry:     newExpiry(options),
+	}
+	if options.sojourn {
+		res.enqueuedAt = make([]time.Time, capacity)
+	}
+	if res.expiry != nil {
+		startSweep(options.sweep, res.closeCh, res.Purge)
 	}
 	return res
 }
@@ -64,8 +117,71 @@ func (q *ArrayBlockingQueue[T]) AsSlice() []T {
 }
 
 func (q *ArrayBlockingQueue[T]) Enqueue(ctx context.Context, t T) error {
+	if q.overflow != OverflowBlock {
+		return q.enqueueOrOverflow(ctx, t)
+	}
+	return q.enqueueBlocking(ctx, t)
+}
+
+// enqueueOrOverflow 队列满了之后不会阻塞，而是按照 overflow 策略处理
+func (q *ArrayBlockingQueue[T]) enqueueOrOverflow(ctx context.Context, t T) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	err := q.tryEnqueue(t)
+	if err != errs.ErrOutOfCapacity {
+		return err
+	}
+	switch q.overflow {
+	case OverflowDropNewest:
+		q.stats.drop(1)
+		q.drop(t)
+		return nil
+	case OverflowDropOldest:
+		q.mutex.Lock()
+		if q.closed {
+			q.mutex.Unlock()
+			return errs.ErrQueueClosed
+		}
+		// 抢走队首元素的令牌，相当于替出队者把它取出来
+		if !q.dequeueCap.TryAcquire(1) {
+			// 元素都已经被出队者预定了，有可能它们已经腾出了空位
+			if q.enqueueCap.TryAcquire(1) {
+				q.enqueue(t)
+				q.mutex.Unlock()
+				return nil
+			}
+			// 没有可以丢弃的旧元素，只能丢弃新元素，不能阻塞
+			q.stats.drop(1)
+			q.mutex.Unlock()
+			q.drop(t)
+			return nil
+		}
+		old := q.take()
+		q.stats.evict(1)
+		// 被丢弃的元素永远不会有人调用 TaskDone
+		_ = q.tasks.taskDone()
+		q.put(t)
+		q.dequeueCap.Release(1)
+		q.mutex.Unlock()
+		q.drop(old)
+		return nil
+	default:
+		return err
+	}
+}
+
+// drop 调用丢弃元素的回调，不能在锁范围内调用
+func (q *ArrayBlockingQueue[T]) drop(t T) {
+	if q.onDrop != nil {
+		q.onDrop(t)
+	}
+}
+
+func (q *ArrayBlockingQueue[T]) enqueueBlocking(ctx context.Context, t T) error {
 	// 能拿到，说明队列还有空位，可以入队，拿不到则阻塞
-	err := q.enqueueCap.Acquire(ctx, 1)
+	w := q.stats.producerWaiter()
+	err := q.acquire(ctx, q.enqueueCap, 1, &w)
 	if err != nil {
 		return err
 	}
@@ -76,11 +192,42 @@ func (q *ArrayBlockingQueue[T]) Enqueue(ctx context.Context, t T) error {
 	// 拿到锁，先判断是否超时，防止在抢锁时已经超时
 	if ctx.Err() != nil {
 		// 超时应该主动归还信号量，避免容量泄露
-		q.enqueueCap.Release(1)
+		q.releaseEnqueueCap(1)
 		return ctx.Err()
 	}
 
+	// 在等信号量的时候，队列被关闭了
+	if q.closed {
+		q.releaseEnqueueCap(1)
+		return errs.ErrQueueClosed
+	}
+
+	q.enqueue(t)
+
+	// 这解锁容易忽略  if ctx.Err() != nil 分支情况，导致该情况未释放锁
+	//q.mutex.Unlock()
+
+	return nil
+}
+
+// enqueue 将元素放入队尾，调用者必须持有 enqueueCap 的令牌以及锁
+func (q *ArrayBlockingQueue[T]) enqueue(t T) {
+	q.put(t)
+	// 往出队的sema放入一个元素，出队的goroutine可以拿到并出队
+	q.dequeueCap.Release(1)
+}
+
+// put 只负责修改 ring buffer，不处理信号量
+func (q *ArrayBlockingQueue[T]) put(t T) {
+	if q.count == 0 {
+		q.emptyCh = make(chan struct{})
+	}
+	q.tasks.add(1)
+	q.stats.enqueue(1)
 	q.data[q.tail] = t
+	if q.enqueuedAt != nil {
+		q.enqueuedAt[q.tail] = time.Now()
+	}
 	q.tail++
 	q.count++
 
@@ -88,49 +235,530 @@ func (q *ArrayBlockingQueue[T]) Enqueue(ctx context.Context, t T) error {
 	if q.tail == cap(q.data) {
 		q.tail = 0
 	}
-
-	// 往出队的sema放入一个元素，出队的goroutine可以拿到并出队
-	q.dequeueCap.Release(1)
-
-	// 这解锁容易忽略  if ctx.Err() != nil 分支情况，导致该情况未释放锁
-	//q.mutex.Unlock()
-
-	return nil
 }
 
 func (q *ArrayBlockingQueue[T]) Dequeue(ctx context.Context) (T, error) {
+	t, _, err := q.DequeueWithMeta(ctx)
+	return t, err
+}
+
+// DequeueWithMeta 和 Dequeue 一样，但是会额外返回元素的 Meta
+// 没有开启 WithSojournTracking 的时候 Meta 为零值
+func (q *ArrayBlockingQueue[T]) DequeueWithMeta(ctx context.Context) (T, Meta, error) {
 	// 能拿到，说明队列有元素可以取，可以出队，拿不到则阻塞
-	err := q.dequeueCap.Acquire(ctx, 1)
+	// 队列关闭之后，依旧可以取走剩余的元素
+	w := q.stats.consumerWaiter()
+	for {
+		err := q.acquire(ctx, q.dequeueCap, 1, &w)
 
-	var t T
-	if err != nil {
-		return t, err
+		var t T
+		if err != nil {
+			return t, Meta{}, err
+		}
+
+		q.mutex.Lock()
+
+		// 拿到锁，先判断是否超时，防止在抢锁时已经超时
+		if ctx.Err() != nil {
+			// 超时应该主动归还信号量，有元素消费不到
+			q.dequeueCap.Release(1)
+			q.mutex.Unlock()
+			return t, Meta{}, ctx.Err()
+		}
+
+		t, meta, ok := q.takeOrExpire(w.waited)
+		q.mutex.Unlock()
+		if ok {
+			return t, meta, nil
+		}
+		// 过期了，再取下一个
+		q.expiry.expire(t)
 	}
+}
 
+// takeOrExpire 取出队首元素，元素已经过期的时候 ok 为 false
+// 调用者必须持有一个 dequeueCap 的令牌以及锁，不管元素是否过期，令牌都会被消耗掉
+func (q *ArrayBlockingQueue[T]) takeOrExpire(waited time.Duration) (t T, meta Meta, ok bool) {
+	meta = q.headMeta()
+	if !q.expiry.expired(q.data[q.head], meta) {
+		return q.dequeue(waited), meta, true
+	}
+	t = q.take()
+	q.stats.expire(1)
+	// 过期的元素永远不会有人调用 TaskDone
+	_ = q.tasks.taskDone()
+	q.releaseEnqueueCap(1)
+	return t, meta, false
+}
+
+// Purge 清理队列中所有过期的元素，返回清理掉的个数
+// 没有设置 TTL 并且元素也没有实现 Expirable 的时候什么也不会做
+func (q *ArrayBlockingQueue[T]) Purge() int {
+	if q.expiry == nil {
+		return 0
+	}
 	q.mutex.Lock()
-	defer q.mutex.Unlock()
+	expired := make([]bool, q.count)
+	cnt := 0
+	for i := 0; i < q.count; i++ {
+		index := (q.head + i) % len(q.data)
+		var meta Meta
+		if q.enqueuedAt != nil {
+			meta = newMeta(q.enqueuedAt[index])
+		}
+		if q.expiry.expired(q.data[index], meta) {
+			expired[i] = true
+			cnt++
+		}
+	}
+	// 有些元素已经被出队者预定了，只能清理掉拿得到令牌的那部分，剩下的交给出队者处理
+	cnt = q.tryAcquireUpTo(q.dequeueCap, cnt)
+	if cnt == 0 {
+		q.mutex.Unlock()
+		return 0
+	}
+	res := make([]T, 0, cnt)
+	data := make([]T, len(q.data))
+	var enqueuedAt []time.Time
+	if q.enqueuedAt != nil {
+		enqueuedAt = make([]time.Time, len(q.data))
+	}
+	n := 0
+	for i := 0; i < q.count; i++ {
+		index := (q.head + i) % len(q.data)
+		if expired[i] && len(res) < cnt {
+			res = append(res, q.data[index])
+			continue
+		}
+		data[n] = q.data[index]
+		if enqueuedAt != nil {
+			enqueuedAt[n] = q.enqueuedAt[index]
+		}
+		n++
+	}
+	q.data, q.enqueuedAt = data, enqueuedAt
+	q.head, q.count = 0, n
+	q.tail = n % len(data)
+	if n == 0 {
+		close(q.emptyCh)
+	}
+	q.stats.expire(cnt)
+	for i := 0; i < cnt; i++ {
+		_ = q.tasks.taskDone()
+	}
+	q.releaseEnqueueCap(int64(cnt))
+	q.mutex.Unlock()
+	q.expiry.expire(res...)
+	return cnt
+}
 
-	// 拿到锁，先判断是否超时，防止在抢锁时已经超时
-	if ctx.Err() != nil {
-		// 超时应该主动归还信号量，有元素消费不到
-		q.dequeueCap.Release(1)
-		return t, ctx.Err()
+// headMeta 返回队首元素的 Meta，必须在锁范围内调用，并且队列不能为空
+func (q *ArrayBlockingQueue[T]) headMeta() Meta {
+	if q.enqueuedAt == nil {
+		return Meta{}
 	}
+	return newMeta(q.enqueuedAt[q.head])
+}
+
+// OldestAge 返回队首元素在队列中已经停留的时间
+// 队列为空或者没有开启 WithSojournTracking 的时候返回 0
+func (q *ArrayBlockingQueue[T]) OldestAge() time.Duration {
+	q.mutex.RLock()
+	defer q.mutex.RUnlock()
+	if q.isEmpty() {
+		return 0
+	}
+	return q.headMeta().Sojourn
+}
 
-	t = q.data[q.head]
+// dequeue 取出队首元素，调用者必须持有 dequeueCap 的令牌以及锁
+// waited 是出队者为此阻塞的时间
+func (q *ArrayBlockingQueue[T]) dequeue(waited time.Duration) T {
+	t := q.take()
+	q.stats.dequeue(1, waited)
+	// 往入队的sema放入一个元素，入队的goroutine可以拿到并入队
+	q.releaseEnqueueCap(1)
+	return t
+}
+
+// take 只负责修改 ring buffer，不处理信号量
+func (q *ArrayBlockingQueue[T]) take() T {
+	t := q.data[q.head]
 	// 为了释放内存，GC
 	q.data[q.head] = q.zero
+	if q.enqueuedAt != nil {
+		q.enqueuedAt[q.head] = time.Time{}
+	}
 	q.head++
 	q.count--
 	if q.head == cap(q.data) {
 		q.head = 0
 	}
+	if q.count == 0 {
+		close(q.emptyCh)
+	}
+	return t
+}
 
-	// 往入队的sema放入一个元素，入队的goroutine可以拿到并入队
-	q.enqueueCap.Release(1)
-	//q.mutex.Unlock()
+// TaskDone 标记一个已经出队的元素处理完毕
+// 每一个入队的元素都对应一个未完成的任务，调用次数超过入队的元素个数时返回 errs.ErrTooManyTaskDone
+func (q *ArrayBlockingQueue[T]) TaskDone() error {
+	return q.tasks.taskDone()
+}
 
-	return t, nil
+// Join 阻塞直到所有入队的元素都被调用了 TaskDone
+// 也就是说，不仅仅是被取走了，还要被处理完毕
+func (q *ArrayBlockingQueue[T]) Join(ctx context.Context) error {
+	return q.tasks.join(ctx)
+}
+
+// WaitEmpty 阻塞直到队列为空
+// 和 Join 不同，WaitEmpty 只关心元素是否已经被取走，不关心是否已经被处理完毕
+func (q *ArrayBlockingQueue[T]) WaitEmpty(ctx context.Context) error {
+	q.mutex.RLock()
+	emptyCh := q.emptyCh
+	q.mutex.RUnlock()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-emptyCh:
+		return nil
+	}
+}
+
+// Peek 返回队首元素，但是不会将其取出
+// 过期的元素会被跳过，它们留给 Dequeue 或者 Purge 清理，
+// 队列为空或者只剩下过期元素的时候返回 errs.ErrEmptyQueue
+func (q *ArrayBlockingQueue[T]) Peek() (T, error) {
+	q.mutex.RLock()
+	defer q.mutex.RUnlock()
+	for i := 0; i < q.count; i++ {
+		index := (q.head + i) % len(q.data)
+		var meta Meta
+		if q.enqueuedAt != nil {
+			meta = newMeta(q.enqueuedAt[index])
+		}
+		if !q.expiry.expired(q.data[index], meta) {
+			return q.data[index], nil
+		}
+	}
+	var t T
+	return t, errs.ErrEmptyQueue
+}
+
+// PeekWait 阻塞直到队列中有元素，返回队首元素，但是不会将其取出
+// 超时的时候返回 ctx 的错误，队列关闭并且为空的时候返回 errs.ErrQueueClosed
+// 和 Dequeue 一样，过期的队首元素会被移除，而后继续等待下一个元素
+func (q *ArrayBlockingQueue[T]) PeekWait(ctx context.Context) (T, error) {
+	// 拿到令牌说明队列中有元素，看完之后再把令牌还回去
+	w := q.stats.consumerWaiter()
+	for {
+		err := q.acquire(ctx, q.dequeueCap, 1, &w)
+		var t T
+		if err != nil {
+			return t, err
+		}
+		q.mutex.Lock()
+		if ctx.Err() != nil {
+			q.dequeueCap.Release(1)
+			q.mutex.Unlock()
+			return t, ctx.Err()
+		}
+		if !q.expiry.expired(q.data[q.head], q.headMeta()) {
+			t = q.data[q.head]
+			q.dequeueCap.Release(1)
+			q.mutex.Unlock()
+			return t, nil
+		}
+		// 过期的元素直接移除，令牌也随之消耗掉
+		t, _, _ = q.takeOrExpire(0)
+		q.mutex.Unlock()
+		q.expiry.expire(t)
+	}
+}
+
+// EnqueueAll 批量入队，返回成功入队的元素个数
+// 每一批最多 capacity 个元素，阻塞直到拿到一个令牌，而后把当下能够拿到的令牌一并拿走，
+// 所以一批元素只需要抢一次锁。
+// 在超时或者队列关闭的情况下，返回已经入队的个数以及对应的 error
+// 如果设置了非阻塞的 overflow 策略，那么会逐个入队，被丢弃的元素也计算在返回值里面
+func (q *ArrayBlockingQueue[T]) EnqueueAll(ctx context.Context, ts []T) (int, error) {
+	cnt := 0
+	// 非阻塞的策略要逐个处理
+	if q.overflow != OverflowBlock {
+		for _, t := range ts {
+			if err := q.enqueueOrOverflow(ctx, t); err != nil {
+				return cnt, err
+			}
+			cnt++
+		}
+		return cnt, nil
+	}
+	for cnt < len(ts) {
+		w := q.stats.producerWaiter()
+		// 只阻塞等待一个令牌，剩下的能拿多少拿多少。
+		// 一次性申请多个令牌的话，并发缩容之后这些令牌有可能永远也凑不齐
+		if err := q.acquire(ctx, q.enqueueCap, 1, &w); err != nil {
+			return cnt, err
+		}
+		q.mutex.Lock()
+		// 已经拿到了一个，剩下的最多只有 capacity + shrinkDebt - count - 1 个空闲令牌
+		n := len(ts) - cnt
+		if free := q.capacity + int(q.shrinkDebt) - q.count; n > free {
+			n = free
+		}
+		n = 1 + q.tryAcquireUpTo(q.enqueueCap, n-1)
+		if ctx.Err() != nil {
+			q.releaseEnqueueCap(int64(n))
+			q.mutex.Unlock()
+			return cnt, ctx.Err()
+		}
+		if q.closed {
+			q.releaseEnqueueCap(int64(n))
+			q.mutex.Unlock()
+			return cnt, errs.ErrQueueClosed
+		}
+		for _, t := range ts[cnt : cnt+n] {
+			q.put(t)
+		}
+		q.dequeueCap.Release(int64(n))
+		q.mutex.Unlock()
+		cnt += n
+	}
+	return cnt, nil
+}
+
+// DequeueUpTo 批量出队，最多返回 max 个元素
+// 会阻塞直到至少有一个元素，而后把当下能够拿到的元素一并取走
+func (q *ArrayBlockingQueue[T]) DequeueUpTo(ctx context.Context, max int) ([]T, error) {
+	if max <= 0 {
+		return nil, nil
+	}
+	w := q.stats.consumerWaiter()
+	for {
+		if err := q.acquire(ctx, q.dequeueCap, 1, &w); err != nil {
+			return nil, err
+		}
+		q.mutex.Lock()
+		if ctx.Err() != nil {
+			q.dequeueCap.Release(1)
+			q.mutex.Unlock()
+			return nil, ctx.Err()
+		}
+		// 已经拿到了一个，剩下的能拿多少拿多少
+		n := max
+		if n > q.count {
+			n = q.count
+		}
+		n = 1 + q.tryAcquireUpTo(q.dequeueCap, n-1)
+		res, expired := q.takeN(n, w.waited)
+		q.mutex.Unlock()
+		q.expiry.expire(expired...)
+		// 全都过期了，继续等
+		if len(res) > 0 {
+			return res, nil
+		}
+	}
+}
+
+// takeN 取出 n 个元素，过期的元素会被挑出来放在 expired 里面
+// 调用者必须持有 n 个 dequeueCap 的令牌以及锁
+func (q *ArrayBlockingQueue[T]) takeN(n int, waited time.Duration) (res []T, expired []T) {
+	res = make([]T, 0, n)
+	for i := 0; i < n; i++ {
+		t, _, ok := q.takeOrExpire(waited)
+		if ok {
+			res = append(res, t)
+		} else {
+			expired = append(expired, t)
+		}
+	}
+	return res, expired
+}
+
+// DrainTo 取出队列中的元素放入 dst，最多取 len(dst) 个，返回取出的个数
+// 不会阻塞
+func (q *ArrayBlockingQueue[T]) DrainTo(dst []T) int {
+	q.mutex.Lock()
+	n := len(dst)
+	if n > q.count {
+		n = q.count
+	}
+	n = q.tryAcquireUpTo(q.dequeueCap, n)
+	cnt := 0
+	var expired []T
+	for i := 0; i < n; i++ {
+		t, _, ok := q.takeOrExpire(0)
+		if ok {
+			dst[cnt] = t
+			cnt++
+		} else {
+			expired = append(expired, t)
+		}
+	}
+	q.mutex.Unlock()
+	q.expiry.expire(expired...)
+	return cnt
+}
+
+// tryAcquireUpTo 不阻塞地获取最多 n 个令牌，返回实际获取的个数
+// n 是调用者在锁范围内算出来的空闲令牌的个数，通常一次 TryAcquire 就能全部拿到。
+// 有些令牌可能已经被别的 goroutine 拿走了，但是对方还没抢到锁，这时候才逐步缩小，找出能拿到的最大值
+func (q *ArrayBlockingQueue[T]) tryAcquireUpTo(sema *semaphore.Weighted, n int) int {
+	got := 0
+	for step := n; step > 0; {
+		if got+step <= n && sema.TryAcquire(int64(step)) {
+			got += step
+			continue
+		}
+		step /= 2
+	}
+	return got
+}
+
+// TryEnqueue 尝试入队，不会阻塞
+// 队列已满或者已经关闭的时候返回 false
+func (q *ArrayBlockingQueue[T]) TryEnqueue(t T) bool {
+	return q.tryEnqueue(t) == nil
+}
+
+func (q *ArrayBlockingQueue[T]) tryEnqueue(t T) error {
+	// TryAcquire 不会创建 context，也不会阻塞
+	if !q.enqueueCap.TryAcquire(1) {
+		if q.isClosed() {
+			return errs.ErrQueueClosed
+		}
+		q.stats.full()
+		return errs.ErrOutOfCapacity
+	}
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	if q.closed {
+		q.releaseEnqueueCap(1)
+		return errs.ErrQueueClosed
+	}
+	q.enqueue(t)
+	return nil
+}
+
+// TryDequeue 尝试出队，不会阻塞
+// 队列为空的时候返回 false
+func (q *ArrayBlockingQueue[T]) TryDequeue() (T, bool) {
+	t, err := q.tryDequeue()
+	return t, err == nil
+}
+
+func (q *ArrayBlockingQueue[T]) tryDequeue() (T, error) {
+	for {
+		if !q.dequeueCap.TryAcquire(1) {
+			var t T
+			if q.isClosed() {
+				return t, errs.ErrQueueClosed
+			}
+			q.stats.empty()
+			return t, errs.ErrEmptyQueue
+		}
+		q.mutex.Lock()
+		t, _, ok := q.takeOrExpire(0)
+		q.mutex.Unlock()
+		if ok {
+			return t, nil
+		}
+		q.expiry.expire(t)
+	}
+}
+
+// Offer 在 timeout 内将元素放入队列
+// timeout <= 0 的时候不会阻塞，队列已满则返回 errs.ErrOutOfCapacity；
+// 否则会一直等待，超时返回 context.DeadlineExceeded
+func (q *ArrayBlockingQueue[T]) Offer(t T, timeout time.Duration) error {
+	if timeout <= 0 {
+		return q.tryEnqueue(t)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return q.Enqueue(ctx, t)
+}
+
+// Poll 在 timeout 内从队首获得一个元素
+// timeout <= 0 的时候不会阻塞，队列为空则返回 errs.ErrEmptyQueue；
+// 否则会一直等待，超时返回 context.DeadlineExceeded
+func (q *ArrayBlockingQueue[T]) Poll(timeout time.Duration) (T, error) {
+	if timeout <= 0 {
+		return q.tryDequeue()
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return q.Dequeue(ctx)
+}
+
+// acquire 从信号量中获取 n 个令牌，拿不到则阻塞
+// 队列关闭之后，阻塞在这里的 goroutine 会被唤醒。如果这时候依旧拿不到令牌，就返回 errs.ErrQueueClosed
+// 返回之后可以通过 w.waited 拿到阻塞的时间
+func (q *ArrayBlockingQueue[T]) acquire(ctx context.Context, sema *semaphore.Weighted, n int64, w *waiter) error {
+	return acquireUntilClosed(ctx, sema, n, q.closeCh, w)
+}
+
+// acquireUntilClosed 从信号量中获取 n 个令牌，拿不到则阻塞，直到 ctx 超时或者 closeCh 被关闭
+// w 用于记录阻塞的统计信息
+func acquireUntilClosed(ctx context.Context, sema *semaphore.Weighted, n int64,
+	closeCh <-chan struct{}, w *waiter) (err error) {
+	// 快路径，不需要额外创建 context
+	if sema.TryAcquire(n) {
+		return nil
+	}
+	select {
+	case <-closeCh:
+		return errs.ErrQueueClosed
+	default:
+	}
+	w.wait()
+	defer w.done(&err)
+
+	acquireCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		select {
+		case <-closeCh:
+			cancel()
+		case <-acquireCtx.Done():
+		}
+	}()
+	err = sema.Acquire(acquireCtx, n)
+	if err == nil {
+		return nil
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	// 被 Close 唤醒，但是有可能在关闭前恰好有元素入队了
+	if sema.TryAcquire(n) {
+		return nil
+	}
+	return errs.ErrQueueClosed
+}
+
+// isClosed 不需要加锁
+func (q *ArrayBlockingQueue[T]) isClosed() bool {
+	select {
+	case <-q.closeCh:
+		return true
+	default:
+		return false
+	}
+}
+
+// Close 关闭队列
+// 关闭之后入队会返回 errs.ErrQueueClosed，出队在取完剩余的元素之后返回 errs.ErrQueueClosed
+func (q *ArrayBlockingQueue[T]) Close() error {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	if q.closed {
+		return nil
+	}
+	q.closed = true
+	close(q.closeCh)
+	return nil
 }
 
 func (q *ArrayBlockingQueue[T]) IsFull() bool {
@@ -140,7 +768,85 @@ func (q *ArrayBlockingQueue[T]) IsFull() bool {
 }
 
 func (q *ArrayBlockingQueue[T]) isFull() bool {
-	return q.count == cap(q.data)
+	return q.count >= q.capacity
+}
+
+// Cap 队列的容量
+func (q *ArrayBlockingQueue[T]) Cap() int {
+	q.mutex.RLock()
+	defer q.mutex.RUnlock()
+	return q.capacity
+}
+
+// SetCapacity 修改队列的容量，capacity 必须为正数
+// 扩容会唤醒阻塞的入队者；
+// 缩容到比当前元素个数还小的时候，已有的元素会被保留，只是在元素个数降到新的容量以下之前，入队都会阻塞
+func (q *ArrayBlockingQueue[T]) SetCapacity(capacity int) error {
+	if capacity <= 0 {
+		return errs.ErrInvalidCapacity
+	}
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	delta := int64(capacity - q.capacity)
+	q.capacity = capacity
+	q.stats.setCap(capacity)
+	if delta > 0 {
+		// 优先抵消还没偿还的缩容
+		if q.shrinkDebt >= delta {
+			q.shrinkDebt -= delta
+		} else {
+			delta -= q.shrinkDebt
+			q.shrinkDebt = 0
+			q.enqueueCap.Release(delta)
+		}
+	} else if delta < 0 {
+		// 能拿走多少令牌就拿走多少，拿不走的部分等出队的时候再偿还
+		// 缩容之前空闲的令牌最多有 旧的容量 + shrinkDebt - count 个
+		n := -delta
+		if free := int64(q.capacity) - delta + q.shrinkDebt - int64(q.count); n > free {
+			n = free
+		}
+		got := int64(q.tryAcquireUpTo(q.enqueueCap, int(n)))
+		q.shrinkDebt += -delta - got
+	}
+	// 元素加上已经拿到令牌的入队者，最多也只有 capacity + shrinkDebt 个
+	q.resize(q.capacity + int(q.shrinkDebt))
+	return nil
+}
+
+// resize 重新分配 ring buffer，元素会被挪到从 0 开始的位置
+func (q *ArrayBlockingQueue[T]) resize(size int) {
+	if size == len(q.data) {
+		return
+	}
+	data := make([]T, size)
+	for i := 0; i < q.count; i++ {
+		data[i] = q.data[(q.head+i)%len(q.data)]
+	}
+	if q.enqueuedAt != nil {
+		enqueuedAt := make([]time.Time, size)
+		for i := 0; i < q.count; i++ {
+			enqueuedAt[i] = q.enqueuedAt[(q.head+i)%len(q.data)]
+		}
+		q.enqueuedAt = enqueuedAt
+	}
+	q.data = data
+	q.head = 0
+	q.tail = q.count % size
+}
+
+// releaseEnqueueCap 归还入队的令牌，如果之前缩容还有没偿还的令牌，那么优先偿还
+// 必须在锁范围内调用
+func (q *ArrayBlockingQueue[T]) releaseEnqueueCap(n int64) {
+	if q.shrinkDebt > 0 {
+		if q.shrinkDebt >= n {
+			q.shrinkDebt -= n
+			return
+		}
+		n -= q.shrinkDebt
+		q.shrinkDebt = 0
+	}
+	q.enqueueCap.Release(n)
 }
 
 func (q *ArrayBlockingQueue[T]) IsEmpty() bool {
@@ -153,6 +859,11 @@ func (q *ArrayBlockingQueue[T]) isEmpty() bool {
 	return q.count == 0
 }
 
+// Stats 返回统计信息的快照，不需要加锁
+func (q *ArrayBlockingQueue[T]) Stats() Stats {
+	return q.stats.snapshot()
+}
+
 func (q *ArrayBlockingQueue[T]) Len() int {
 	q.mutex.RLock()
 	defer q.mutex.RUnlock()
@@ -288,6 +999,10 @@ type ArrayBlockingQueueV2[T any] struct {
 	tail  int
 
 	zero T
+
+	closed bool
+
+	stats *queueStats
 }
 
 func NewArrayBlockingQueueV2[T any](capacity int) *ArrayBlockingQueueV2[T] {
@@ -300,6 +1015,7 @@ func NewArrayBlockingQueueV2[T any](capacity int) *ArrayBlockingQueueV2[T] {
 		maxSize:      capacity,
 		notEmptyCond: NewCond(m),
 		notFullCond:  NewCond(m),
+		stats:        newQueueStats(capacity, NopObserver{}),
 	}
 	return res
 }
@@ -309,23 +1025,63 @@ func (c *ArrayBlockingQueueV2[T]) Enqueue(ctx context.Context, data T) error {
 		return ctx.Err()
 	}
 	c.mutex.Lock()
-	for c.isFull() {
-		err := c.notFullCond.WaitWithTimeout(ctx)
-		if err != nil {
+	if err := c.waitNotFull(ctx); err != nil {
+		c.mutex.Unlock()
+		return err
+	}
+	if c.closed {
+		c.mutex.Unlock()
+		return errs.ErrQueueClosed
+	}
+
+	c.enqueue(data)
+	c.mutex.Unlock()
+	return nil
+}
+
+// waitNotFull 阻塞直到队列不满或者队列已经关闭
+// 必须在锁范围内调用，返回的时候依旧持有锁
+func (c *ArrayBlockingQueueV2[T]) waitNotFull(ctx context.Context) (err error) {
+	w := c.stats.producerWaiter()
+	defer w.done(&err)
+	for !c.closed && c.isFull() {
+		w.wait()
+		if err = c.notFullCond.WaitWithTimeout(ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// waitNotEmpty 阻塞直到队列不为空
+// 队列关闭并且为空的时候返回 errs.ErrQueueClosed
+// 必须在锁范围内调用，返回的时候依旧持有锁，等待的时间累加到 w 上
+func (c *ArrayBlockingQueueV2[T]) waitNotEmpty(ctx context.Context, w *waiter) (err error) {
+	defer w.done(&err)
+	for c.isEmpty() {
+		// 队列关闭了，并且元素已经被取完
+		if c.closed {
+			return errs.ErrQueueClosed
+		}
+		w.wait()
+		if err = c.notEmptyCond.WaitWithTimeout(ctx); err != nil {
 			return err
 		}
 	}
+	return nil
+}
 
+// enqueue 将元素放入队尾，调用者必须持有锁并且确保队列未满
+func (c *ArrayBlockingQueueV2[T]) enqueue(data T) {
 	c.data[c.tail] = data
 	c.tail++
 	c.count++
-	if c.tail == c.maxSize {
+	if c.tail == len(c.data) {
 		c.tail = 0
 	}
+	c.stats.enqueue(1)
 
 	c.notEmptyCond.Broadcast()
-	c.mutex.Unlock()
-	return nil
 }
 
 func (c *ArrayBlockingQueueV2[T]) Dequeue(ctx context.Context) (T, error) {
@@ -334,24 +1090,139 @@ func (c *ArrayBlockingQueueV2[T]) Dequeue(ctx context.Context) (T, error) {
 		return t, ctx.Err()
 	}
 	c.mutex.Lock()
-	for c.isEmpty() {
-		if err := c.notEmptyCond.WaitWithTimeout(ctx); err != nil {
-			var t T
-			return t, err
-		}
+	w := c.stats.consumerWaiter()
+	if err := c.waitNotEmpty(ctx, &w); err != nil {
+		c.mutex.Unlock()
+		var t T
+		return t, err
 	}
 
+	t := c.dequeue(w.waited)
+	c.mutex.Unlock()
+	// 没有人等 notFull 的信号，这一句就会阻塞住
+	return t, nil
+}
+
+// dequeue 取出队首元素，调用者必须持有锁并且确保队列不为空
+// waited 是出队者阻塞等待的时间
+func (c *ArrayBlockingQueueV2[T]) dequeue(waited time.Duration) T {
 	t := c.data[c.head]
 	c.data[c.head] = c.zero
 	c.head++
 	c.count--
-	if c.head == c.maxSize {
+	if c.head == len(c.data) {
 		c.head = 0
 	}
+	c.stats.dequeue(1, waited)
 	c.notFullCond.Broadcast()
-	c.mutex.Unlock()
-	// 没有人等 notFull 的信号，这一句就会阻塞住
-	return t, nil
+	return t
+}
+
+// Peek 返回队首元素，但是不会将其取出
+// 队列为空的时候返回 errs.ErrEmptyQueue
+func (c *ArrayBlockingQueueV2[T]) Peek() (T, error) {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	if c.isEmpty() {
+		var t T
+		return t, errs.ErrEmptyQueue
+	}
+	return c.data[c.head], nil
+}
+
+// PeekWait 阻塞直到队列中有元素，返回队首元素，但是不会将其取出
+// 超时的时候返回 ctx 的错误，队列关闭并且为空的时候返回 errs.ErrQueueClosed
+func (c *ArrayBlockingQueueV2[T]) PeekWait(ctx context.Context) (T, error) {
+	if ctx.Err() != nil {
+		var t T
+		return t, ctx.Err()
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	w := c.stats.consumerWaiter()
+	if err := c.waitNotEmpty(ctx, &w); err != nil {
+		var t T
+		return t, err
+	}
+	return c.data[c.head], nil
+}
+
+// TryEnqueue 尝试入队，不会阻塞
+// 队列已满或者已经关闭的时候返回 false
+func (c *ArrayBlockingQueueV2[T]) TryEnqueue(data T) bool {
+	return c.tryEnqueue(data) == nil
+}
+
+func (c *ArrayBlockingQueueV2[T]) tryEnqueue(data T) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.closed {
+		return errs.ErrQueueClosed
+	}
+	if c.isFull() {
+		return errs.ErrOutOfCapacity
+	}
+	c.enqueue(data)
+	return nil
+}
+
+// TryDequeue 尝试出队，不会阻塞
+// 队列为空的时候返回 false
+func (c *ArrayBlockingQueueV2[T]) TryDequeue() (T, bool) {
+	t, err := c.tryDequeue()
+	return t, err == nil
+}
+
+func (c *ArrayBlockingQueueV2[T]) tryDequeue() (T, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.isEmpty() {
+		var t T
+		if c.closed {
+			return t, errs.ErrQueueClosed
+		}
+		return t, errs.ErrEmptyQueue
+	}
+	return c.dequeue(0), nil
+}
+
+// Offer 在 timeout 内将元素放入队列
+// timeout <= 0 的时候不会阻塞，队列已满则返回 errs.ErrOutOfCapacity；
+// 否则会一直等待，超时返回 context.DeadlineExceeded
+func (c *ArrayBlockingQueueV2[T]) Offer(data T, timeout time.Duration) error {
+	if timeout <= 0 {
+		return c.tryEnqueue(data)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return c.Enqueue(ctx, data)
+}
+
+// Poll 在 timeout 内从队首获得一个元素
+// timeout <= 0 的时候不会阻塞，队列为空则返回 errs.ErrEmptyQueue；
+// 否则会一直等待，超时返回 context.DeadlineExceeded
+func (c *ArrayBlockingQueueV2[T]) Poll(timeout time.Duration) (T, error) {
+	if timeout <= 0 {
+		return c.tryDequeue()
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return c.Dequeue(ctx)
+}
+
+// Close 关闭队列
+// 关闭之后入队会返回 errs.ErrQueueClosed，出队在取完剩余的元素之后返回 errs.ErrQueueClosed
+func (c *ArrayBlockingQueueV2[T]) Close() error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.closed {
+		return nil
+	}
+	c.closed = true
+	// 唤醒所有阻塞的入队者和出队者
+	c.notFullCond.Broadcast()
+	c.notEmptyCond.Broadcast()
+	return nil
 }
 
 func (c *ArrayBlockingQueueV2[T]) IsFull() bool {
@@ -361,7 +1232,45 @@ func (c *ArrayBlockingQueueV2[T]) IsFull() bool {
 }
 
 func (c *ArrayBlockingQueueV2[T]) isFull() bool {
-	return c.count == c.maxSize
+	return c.count >= c.maxSize
+}
+
+// Cap 队列的容量
+func (c *ArrayBlockingQueueV2[T]) Cap() int {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	return c.maxSize
+}
+
+// SetCapacity 修改队列的容量，capacity 必须为正数
+// 扩容会唤醒阻塞的入队者；
+// 缩容到比当前元素个数还小的时候，已有的元素会被保留，只是在元素个数降到新的容量以下之前，入队都会阻塞
+func (c *ArrayBlockingQueueV2[T]) SetCapacity(capacity int) error {
+	if capacity <= 0 {
+		return errs.ErrInvalidCapacity
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	grow := capacity > c.maxSize
+	c.maxSize = capacity
+	c.stats.setCap(capacity)
+	size := capacity
+	if size < c.count {
+		size = c.count
+	}
+	if size != len(c.data) {
+		data := make([]T, size)
+		for i := 0; i < c.count; i++ {
+			data[i] = c.data[(c.head+i)%len(c.data)]
+		}
+		c.data = data
+		c.head = 0
+		c.tail = c.count % size
+	}
+	if grow {
+		c.notFullCond.Broadcast()
+	}
+	return nil
 }
 
 func (c *ArrayBlockingQueueV2[T]) IsEmpty() bool {
@@ -374,6 +1283,11 @@ func (c *ArrayBlockingQueueV2[T]) isEmpty() bool {
 	return c.count == 0
 }
 
+// Stats 返回统计信息的快照，不需要加锁
+func (c *ArrayBlockingQueueV2[T]) Stats() Stats {
+	return c.stats.snapshot()
+}
+
 func (c *ArrayBlockingQueueV2[T]) Len() uint64 {
 	c.mutex.Lock()
 	defer c.mutex.Unlock()
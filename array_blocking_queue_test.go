@@ -1,12 +1,14 @@
 package concurrent_queue
 
 import (
+	"concurrent_queue/errs"
 	"context"
 	"fmt"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"math/rand"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -505,6 +507,814 @@ func TestArrayBlockingQueue(t *testing.T) {
 	wg.Wait()
 }
 
+func TestArrayBlockingQueue_Close(t *testing.T) {
+	// 关闭之后，入队失败，剩余的元素依旧可以取出来
+	t.Run("drain after close", func(t *testing.T) {
+		q := NewArrayBlockingQueue[int](3)
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		require.NoError(t, q.Enqueue(ctx, 123))
+		require.NoError(t, q.Enqueue(ctx, 234))
+		require.NoError(t, q.Close())
+		// 重复关闭
+		require.NoError(t, q.Close())
+
+		err := q.Enqueue(ctx, 345)
+		assert.Equal(t, errs.ErrQueueClosed, err)
+
+		val, err := q.Dequeue(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, 123, val)
+		val, err = q.Dequeue(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, 234, val)
+		_, err = q.Dequeue(ctx)
+		assert.Equal(t, errs.ErrQueueClosed, err)
+	})
+
+	// 阻塞的入队者被唤醒
+	t.Run("wake up blocked producer", func(t *testing.T) {
+		q := NewArrayBlockingQueue[int](1)
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+		defer cancel()
+		require.NoError(t, q.Enqueue(ctx, 123))
+		go func() {
+			time.Sleep(time.Millisecond * 100)
+			_ = q.Close()
+		}()
+		err := q.Enqueue(ctx, 234)
+		assert.Equal(t, errs.ErrQueueClosed, err)
+		assert.Nil(t, ctx.Err())
+		assert.Equal(t, []int{123}, q.AsSlice())
+	})
+
+	// 阻塞的出队者被唤醒
+	t.Run("wake up blocked consumer", func(t *testing.T) {
+		q := NewArrayBlockingQueue[int](1)
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+		defer cancel()
+		go func() {
+			time.Sleep(time.Millisecond * 100)
+			_ = q.Close()
+		}()
+		_, err := q.Dequeue(ctx)
+		assert.Equal(t, errs.ErrQueueClosed, err)
+		assert.Nil(t, ctx.Err())
+	})
+}
+
+func TestArrayBlockingQueueV2_Close(t *testing.T) {
+	t.Run("drain after close", func(t *testing.T) {
+		q := NewArrayBlockingQueueV2[int](3)
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		require.NoError(t, q.Enqueue(ctx, 123))
+		require.NoError(t, q.Close())
+
+		err := q.Enqueue(ctx, 234)
+		assert.Equal(t, errs.ErrQueueClosed, err)
+
+		val, err := q.Dequeue(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, 123, val)
+		_, err = q.Dequeue(ctx)
+		assert.Equal(t, errs.ErrQueueClosed, err)
+	})
+
+	t.Run("wake up blocked producer", func(t *testing.T) {
+		q := NewArrayBlockingQueueV2[int](1)
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+		defer cancel()
+		require.NoError(t, q.Enqueue(ctx, 123))
+		go func() {
+			time.Sleep(time.Millisecond * 100)
+			_ = q.Close()
+		}()
+		err := q.Enqueue(ctx, 234)
+		assert.Equal(t, errs.ErrQueueClosed, err)
+		assert.Nil(t, ctx.Err())
+	})
+
+	t.Run("wake up blocked consumer", func(t *testing.T) {
+		q := NewArrayBlockingQueueV2[int](1)
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+		defer cancel()
+		go func() {
+			time.Sleep(time.Millisecond * 100)
+			_ = q.Close()
+		}()
+		_, err := q.Dequeue(ctx)
+		assert.Equal(t, errs.ErrQueueClosed, err)
+		assert.Nil(t, ctx.Err())
+	})
+}
+
+func TestArrayBlockingQueue_TryEnqueueAndTryDequeue(t *testing.T) {
+	q := NewArrayBlockingQueue[int](2)
+	assert.True(t, q.TryEnqueue(123))
+	assert.True(t, q.TryEnqueue(234))
+	// 满了
+	assert.False(t, q.TryEnqueue(345))
+	assert.Equal(t, errs.ErrOutOfCapacity, q.Offer(345, 0))
+
+	val, ok := q.TryDequeue()
+	assert.True(t, ok)
+	assert.Equal(t, 123, val)
+	val, err := q.Poll(0)
+	require.NoError(t, err)
+	assert.Equal(t, 234, val)
+	// 空了
+	_, ok = q.TryDequeue()
+	assert.False(t, ok)
+	_, err = q.Poll(0)
+	assert.Equal(t, errs.ErrEmptyQueue, err)
+
+	require.NoError(t, q.Close())
+	assert.False(t, q.TryEnqueue(456))
+	assert.Equal(t, errs.ErrQueueClosed, q.Offer(456, 0))
+	_, err = q.Poll(0)
+	assert.Equal(t, errs.ErrQueueClosed, err)
+}
+
+func TestArrayBlockingQueue_OfferAndPoll(t *testing.T) {
+	q := NewArrayBlockingQueue[int](1)
+	require.NoError(t, q.Offer(123, time.Second))
+	err := q.Offer(234, time.Millisecond*100)
+	assert.Equal(t, context.DeadlineExceeded, err)
+
+	val, err := q.Poll(time.Second)
+	require.NoError(t, err)
+	assert.Equal(t, 123, val)
+	_, err = q.Poll(time.Millisecond * 100)
+	assert.Equal(t, context.DeadlineExceeded, err)
+
+	// 阻塞等待，然后有元素入队
+	go func() {
+		time.Sleep(time.Millisecond * 100)
+		_ = q.Offer(345, 0)
+	}()
+	val, err = q.Poll(time.Second)
+	require.NoError(t, err)
+	assert.Equal(t, 345, val)
+}
+
+func TestArrayBlockingQueue_Batch(t *testing.T) {
+	t.Run("enqueue all and dequeue up to", func(t *testing.T) {
+		q := NewArrayBlockingQueue[int](3)
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		n, err := q.EnqueueAll(ctx, []int{1, 2})
+		require.NoError(t, err)
+		assert.Equal(t, 2, n)
+
+		vals, err := q.DequeueUpTo(ctx, 5)
+		require.NoError(t, err)
+		assert.Equal(t, []int{1, 2}, vals)
+
+		n, err = q.EnqueueAll(ctx, []int{3, 4, 5})
+		require.NoError(t, err)
+		assert.Equal(t, 3, n)
+		vals, err = q.DequeueUpTo(ctx, 2)
+		require.NoError(t, err)
+		assert.Equal(t, []int{3, 4}, vals)
+		assert.Equal(t, []int{5}, q.AsSlice())
+	})
+
+	// 超过容量的部分，要等消费者取走之后才能入队
+	t.Run("enqueue all more than capacity", func(t *testing.T) {
+		q := NewArrayBlockingQueue[int](2)
+		ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*200)
+		defer cancel()
+		n, err := q.EnqueueAll(ctx, []int{1, 2, 3})
+		assert.Equal(t, context.DeadlineExceeded, err)
+		assert.Equal(t, 2, n)
+
+		ctx, cancel = context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		go func() {
+			time.Sleep(time.Millisecond * 100)
+			_, _ = q.DequeueUpTo(ctx, 2)
+		}()
+		n, err = q.EnqueueAll(ctx, []int{3, 4})
+		require.NoError(t, err)
+		assert.Equal(t, 2, n)
+		assert.Equal(t, []int{3, 4}, q.AsSlice())
+	})
+
+	// 阻塞中的 EnqueueAll 遇到缩容，不能一直等着凑不齐的令牌
+	t.Run("enqueue all while shrinking", func(t *testing.T) {
+		q := NewArrayBlockingQueue[int](4)
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		_, err := q.EnqueueAll(ctx, []int{1, 2, 3, 4})
+		require.NoError(t, err)
+		go func() {
+			_, _ = q.EnqueueAll(ctx, []int{5, 6, 7, 8})
+		}()
+		time.Sleep(time.Millisecond * 50)
+		require.NoError(t, q.SetCapacity(2))
+		vals := make([]int, 0, 8)
+		for len(vals) < 8 {
+			val, err := q.Dequeue(ctx)
+			require.NoError(t, err)
+			vals = append(vals, val)
+		}
+		assert.Equal(t, []int{1, 2, 3, 4, 5, 6, 7, 8}, vals)
+	})
+
+	t.Run("dequeue up to blocking", func(t *testing.T) {
+		q := NewArrayBlockingQueue[int](3)
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		go func() {
+			time.Sleep(time.Millisecond * 100)
+			_ = q.Enqueue(ctx, 1)
+		}()
+		vals, err := q.DequeueUpTo(ctx, 3)
+		require.NoError(t, err)
+		assert.Equal(t, []int{1}, vals)
+
+		ctx, cancel = context.WithTimeout(context.Background(), time.Millisecond*100)
+		defer cancel()
+		_, err = q.DequeueUpTo(ctx, 3)
+		assert.Equal(t, context.DeadlineExceeded, err)
+	})
+
+	t.Run("drain to", func(t *testing.T) {
+		q := NewArrayBlockingQueue[int](3)
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		_, err := q.EnqueueAll(ctx, []int{1, 2, 3})
+		require.NoError(t, err)
+
+		dst := make([]int, 2)
+		assert.Equal(t, 2, q.DrainTo(dst))
+		assert.Equal(t, []int{1, 2}, dst)
+		dst = make([]int, 5)
+		assert.Equal(t, 1, q.DrainTo(dst))
+		assert.Equal(t, 3, dst[0])
+		assert.Equal(t, 0, q.DrainTo(dst))
+		// 出队之后容量会被释放
+		assert.True(t, q.TryEnqueue(4))
+	})
+
+	// 一次能取走所有可用的元素
+	t.Run("dequeue up to all available", func(t *testing.T) {
+		q := NewArrayBlockingQueue[int](8)
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		_, err := q.EnqueueAll(ctx, []int{1, 2, 3, 4, 5, 6, 7})
+		require.NoError(t, err)
+		vals, err := q.DequeueUpTo(ctx, 6)
+		require.NoError(t, err)
+		assert.Equal(t, []int{1, 2, 3, 4, 5, 6}, vals)
+		require.NoError(t, q.Enqueue(ctx, 8))
+		dst := make([]int, 8)
+		assert.Equal(t, 2, q.DrainTo(dst[:3]))
+		assert.Equal(t, []int{7, 8}, dst[:2])
+	})
+}
+
+func TestArrayBlockingQueue_tryAcquireUpTo(t *testing.T) {
+	q := NewArrayBlockingQueue[int](8)
+	// 空闲的令牌足够，一次就能全部拿到
+	assert.Equal(t, 3, q.tryAcquireUpTo(q.enqueueCap, 3))
+	// 上限算多了，只能拿到剩下的 5 个
+	assert.Equal(t, 5, q.tryAcquireUpTo(q.enqueueCap, 7))
+	assert.Equal(t, 0, q.tryAcquireUpTo(q.enqueueCap, 1))
+	q.enqueueCap.Release(8)
+}
+
+func TestArrayBlockingQueue_Peek(t *testing.T) {
+	q := NewArrayBlockingQueue[int](3)
+	_, err := q.Peek()
+	assert.Equal(t, errs.ErrEmptyQueue, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*100)
+	defer cancel()
+	_, err = q.PeekWait(ctx)
+	assert.Equal(t, context.DeadlineExceeded, err)
+
+	ctx, cancel = context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	go func() {
+		time.Sleep(time.Millisecond * 100)
+		_ = q.Enqueue(ctx, 123)
+		_ = q.Enqueue(ctx, 234)
+	}()
+	val, err := q.PeekWait(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 123, val)
+	// 不会取走元素
+	val, err = q.Dequeue(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 123, val)
+	val, err = q.Peek()
+	require.NoError(t, err)
+	assert.Equal(t, 234, val)
+	val, err = q.PeekWait(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 234, val)
+	assert.Equal(t, 1, q.Len())
+
+	_, err = q.Dequeue(ctx)
+	require.NoError(t, err)
+	require.NoError(t, q.Close())
+	_, err = q.PeekWait(ctx)
+	assert.Equal(t, errs.ErrQueueClosed, err)
+}
+
+func TestArrayBlockingQueueV2_Peek(t *testing.T) {
+	q := NewArrayBlockingQueueV2[int](3)
+	_, err := q.Peek()
+	assert.Equal(t, errs.ErrEmptyQueue, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*100)
+	defer cancel()
+	_, err = q.PeekWait(ctx)
+	assert.Equal(t, context.DeadlineExceeded, err)
+
+	ctx, cancel = context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	go func() {
+		time.Sleep(time.Millisecond * 100)
+		_ = q.Enqueue(ctx, 123)
+	}()
+	val, err := q.PeekWait(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 123, val)
+	val, err = q.Peek()
+	require.NoError(t, err)
+	assert.Equal(t, 123, val)
+	assert.Equal(t, uint64(1), q.Len())
+}
+
+func TestArrayBlockingQueue_Join(t *testing.T) {
+	q := NewArrayBlockingQueue[int](10)
+	// 没有任务的时候直接返回
+	require.NoError(t, q.Join(context.Background()))
+	require.NoError(t, q.WaitEmpty(context.Background()))
+	assert.Equal(t, errs.ErrTooManyTaskDone, q.TaskDone())
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	_, err := q.EnqueueAll(ctx, []int{1, 2, 3})
+	require.NoError(t, err)
+
+	var processed int64
+	go func() {
+		for i := 0; i < 3; i++ {
+			_, err := q.Dequeue(ctx)
+			if err != nil {
+				return
+			}
+			time.Sleep(time.Millisecond * 10)
+			atomic.AddInt64(&processed, 1)
+			_ = q.TaskDone()
+		}
+	}()
+	require.NoError(t, q.WaitEmpty(ctx))
+	require.NoError(t, q.Join(ctx))
+	assert.Equal(t, int64(3), atomic.LoadInt64(&processed))
+	assert.Equal(t, errs.ErrTooManyTaskDone, q.TaskDone())
+
+	// 出队了但是没有调用 TaskDone
+	require.NoError(t, q.Enqueue(ctx, 4))
+	_, err = q.Dequeue(ctx)
+	require.NoError(t, err)
+	require.NoError(t, q.WaitEmpty(ctx))
+	timeoutCtx, timeoutCancel := context.WithTimeout(context.Background(), time.Millisecond*100)
+	defer timeoutCancel()
+	assert.Equal(t, context.DeadlineExceeded, q.Join(timeoutCtx))
+}
+
+func TestArrayBlockingQueue_Stats(t *testing.T) {
+	q := NewArrayBlockingQueue[int](2)
+	assert.Equal(t, Stats{Cap: 2}, q.Stats())
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	_, err := q.EnqueueAll(ctx, []int{1, 2})
+	require.NoError(t, err)
+	_, err = q.Dequeue(ctx)
+	require.NoError(t, err)
+
+	stats := q.Stats()
+	assert.Equal(t, uint64(2), stats.Enqueued)
+	assert.Equal(t, uint64(1), stats.Dequeued)
+	assert.Equal(t, 1, stats.Len)
+	assert.Equal(t, 2, stats.HighWaterMark)
+	assert.Equal(t, uint64(0), stats.Timeouts)
+
+	// 阻塞的入队者
+	require.NoError(t, q.Enqueue(ctx, 3))
+	go func() {
+		_ = q.Enqueue(ctx, 4)
+	}()
+	require.Eventually(t, func() bool {
+		return q.Stats().BlockedProducers == 1
+	}, time.Second, time.Millisecond*10)
+	_, err = q.Dequeue(ctx)
+	require.NoError(t, err)
+	require.Eventually(t, func() bool {
+		return q.Stats().BlockedProducers == 0
+	}, time.Second, time.Millisecond*10)
+
+	// 超时的出队者
+	_, err = q.DequeueUpTo(ctx, 2)
+	require.NoError(t, err)
+	timeoutCtx, timeoutCancel := context.WithTimeout(context.Background(), time.Millisecond*50)
+	defer timeoutCancel()
+	_, err = q.Dequeue(timeoutCtx)
+	assert.Equal(t, context.DeadlineExceeded, err)
+
+	stats = q.Stats()
+	assert.Equal(t, uint64(4), stats.Enqueued)
+	assert.Equal(t, uint64(4), stats.Dequeued)
+	assert.Equal(t, 0, stats.Len)
+	assert.Equal(t, 0, stats.BlockedConsumers)
+	assert.Equal(t, uint64(1), stats.Timeouts)
+	assert.True(t, stats.WaitTime >= time.Millisecond*50)
+
+	require.NoError(t, q.SetCapacity(5))
+	assert.Equal(t, 5, q.Stats().Cap)
+}
+
+func TestArrayBlockingQueue_DequeueWithMeta(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	// 没有开启的时候 Meta 为零值
+	q := NewArrayBlockingQueue[int](2)
+	require.NoError(t, q.Enqueue(ctx, 1))
+	assert.Equal(t, time.Duration(0), q.OldestAge())
+	_, meta, err := q.DequeueWithMeta(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, Meta{}, meta)
+
+	q = NewArrayBlockingQueue[int](2, WithSojournTracking[int]())
+	assert.Equal(t, time.Duration(0), q.OldestAge())
+	start := time.Now()
+	require.NoError(t, q.Enqueue(ctx, 1))
+	time.Sleep(time.Millisecond * 20)
+	require.NoError(t, q.Enqueue(ctx, 2))
+	assert.True(t, q.OldestAge() >= time.Millisecond*20)
+	// 扩容之后入队时间依旧对得上
+	require.NoError(t, q.SetCapacity(3))
+
+	val, meta, err := q.DequeueWithMeta(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, val)
+	assert.False(t, meta.EnqueuedAt.Before(start))
+	assert.True(t, meta.Sojourn >= time.Millisecond*20)
+	val, meta2, err := q.DequeueWithMeta(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 2, val)
+	assert.True(t, meta2.EnqueuedAt.After(meta.EnqueuedAt))
+	assert.True(t, meta2.Sojourn < meta.Sojourn)
+	assert.Equal(t, time.Duration(0), q.OldestAge())
+}
+
+func TestArrayBlockingQueue_TTL(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	var expired []int
+	var mutex sync.Mutex
+	q := NewArrayBlockingQueue[int](2, WithTTL[int](time.Millisecond*50),
+		WithExpireCallback[int](func(t int) {
+			mutex.Lock()
+			expired = append(expired, t)
+			mutex.Unlock()
+		}))
+	_, err := q.EnqueueAll(ctx, []int{1, 2})
+	require.NoError(t, err)
+
+	// 过期之后，阻塞的入队者会被唤醒
+	enqueued := make(chan error, 1)
+	go func() {
+		enqueued <- q.Enqueue(ctx, 3)
+	}()
+	time.Sleep(time.Millisecond * 60)
+	assert.Equal(t, 2, q.Purge())
+	require.NoError(t, <-enqueued)
+	val, err := q.Dequeue(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 3, val)
+
+	// 出队的时候跳过过期的元素
+	require.NoError(t, q.Enqueue(ctx, 4))
+	time.Sleep(time.Millisecond * 60)
+	require.NoError(t, q.Enqueue(ctx, 5))
+	val, err = q.Dequeue(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 5, val)
+
+	require.NoError(t, q.Enqueue(ctx, 6))
+	time.Sleep(time.Millisecond * 60)
+	_, ok := q.TryDequeue()
+	assert.False(t, ok)
+
+	// PeekWait 同样跳过过期的元素
+	require.NoError(t, q.Enqueue(ctx, 7))
+	time.Sleep(time.Millisecond * 60)
+	go func() {
+		time.Sleep(time.Millisecond * 20)
+		_ = q.Enqueue(ctx, 8)
+	}()
+	val, err = q.PeekWait(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 8, val)
+	val, err = q.Dequeue(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 8, val)
+
+	// Peek 跳过过期的元素，但是不会移除它们
+	require.NoError(t, q.Enqueue(ctx, 9))
+	time.Sleep(time.Millisecond * 60)
+	require.NoError(t, q.Enqueue(ctx, 10))
+	val, err = q.Peek()
+	require.NoError(t, err)
+	assert.Equal(t, 10, val)
+	assert.Equal(t, 2, q.Len())
+	val, err = q.Dequeue(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 10, val)
+	require.NoError(t, q.Enqueue(ctx, 11))
+	time.Sleep(time.Millisecond * 60)
+	_, err = q.Peek()
+	assert.Equal(t, errs.ErrEmptyQueue, err)
+	_, ok = q.TryDequeue()
+	assert.False(t, ok)
+
+	mutex.Lock()
+	assert.Equal(t, []int{1, 2, 4, 6, 7, 9, 11}, expired)
+	mutex.Unlock()
+	stats := q.Stats()
+	assert.Equal(t, uint64(7), stats.Expired)
+	assert.Equal(t, 0, stats.Len)
+	// 过期的元素不需要 TaskDone
+	require.NoError(t, q.TaskDone())
+	require.NoError(t, q.TaskDone())
+	require.NoError(t, q.TaskDone())
+	require.NoError(t, q.TaskDone())
+	require.NoError(t, q.Join(ctx))
+}
+
+func TestArrayBlockingQueue_Expirable(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	q := NewArrayBlockingQueue[expirableElem](4, WithExpirySweep[expirableElem](time.Millisecond*10))
+	defer func() {
+		_ = q.Close()
+	}()
+	now := time.Now()
+	_, err := q.EnqueueAll(ctx, []expirableElem{
+		{val: 1, deadline: now.Add(time.Second)},
+		{val: 2, deadline: now},
+		{val: 3, deadline: now.Add(time.Second)},
+		{val: 4, deadline: now},
+	})
+	require.NoError(t, err)
+	dst := make([]expirableElem, 4)
+	n := q.DrainTo(dst)
+	assert.Equal(t, 2, n)
+	assert.Equal(t, 1, dst[0].val)
+	assert.Equal(t, 3, dst[1].val)
+
+	// 后台清理
+	require.NoError(t, q.Enqueue(ctx, expirableElem{val: 5, deadline: time.Now().Add(time.Millisecond * 20)}))
+	require.Eventually(t, func() bool {
+		return q.Len() == 0
+	}, time.Second, time.Millisecond*10)
+	assert.Equal(t, uint64(3), q.Stats().Expired)
+}
+
+func TestArrayBlockingQueue_OverflowPolicy(t *testing.T) {
+	testCases := []struct {
+		name        string
+		policy      OverflowPolicy
+		wantErr     error
+		wantSlice   []int
+		wantDropped []int
+	}{
+		{
+			name:      "reject",
+			policy:    OverflowReject,
+			wantErr:   errs.ErrOutOfCapacity,
+			wantSlice: []int{1, 2, 3},
+		},
+		{
+			name:        "drop oldest",
+			policy:      OverflowDropOldest,
+			wantSlice:   []int{2, 3, 4},
+			wantDropped: []int{1},
+		},
+		{
+			name:        "drop newest",
+			policy:      OverflowDropNewest,
+			wantSlice:   []int{1, 2, 3},
+			wantDropped: []int{4},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var dropped []int
+			q := NewArrayBlockingQueue[int](3, WithOverflowPolicy[int](tc.policy),
+				WithDropCallback(func(t int) {
+					dropped = append(dropped, t)
+				}))
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+			n, err := q.EnqueueAll(ctx, []int{1, 2, 3})
+			require.NoError(t, err)
+			require.Equal(t, 3, n)
+
+			// 不会阻塞
+			err = q.Enqueue(ctx, 4)
+			assert.Equal(t, tc.wantErr, err)
+			assert.Nil(t, ctx.Err())
+			assert.Equal(t, tc.wantSlice, q.AsSlice())
+			assert.Equal(t, tc.wantDropped, dropped)
+		})
+	}
+
+	// 被丢弃的元素不需要调用 TaskDone
+	t.Run("drop oldest and join", func(t *testing.T) {
+		q := NewArrayBlockingQueue[int](1, WithOverflowPolicy[int](OverflowDropOldest))
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		n, err := q.EnqueueAll(ctx, []int{1, 2, 3})
+		require.NoError(t, err)
+		require.Equal(t, 3, n)
+		val, err := q.Dequeue(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, 3, val)
+		require.NoError(t, q.TaskDone())
+		require.NoError(t, q.Join(ctx))
+	})
+
+	// 元素都已经被出队者预定了，丢弃新元素而不是阻塞
+	t.Run("drop oldest all reserved", func(t *testing.T) {
+		var dropped []int
+		q := NewArrayBlockingQueue[int](1, WithOverflowPolicy[int](OverflowDropOldest),
+			WithDropCallback[int](func(t int) {
+				dropped = append(dropped, t)
+			}))
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		require.NoError(t, q.Enqueue(ctx, 1))
+		// 模拟一个已经拿到了令牌，但是还没有取出元素的出队者
+		require.True(t, q.dequeueCap.TryAcquire(1))
+		require.NoError(t, q.Enqueue(ctx, 2))
+		assert.Equal(t, []int{2}, dropped)
+		assert.Equal(t, uint64(1), q.Stats().Dropped)
+		q.dequeueCap.Release(1)
+		val, err := q.Dequeue(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, 1, val)
+	})
+}
+
+func TestArrayBlockingQueue_SetCapacity(t *testing.T) {
+	assert.Equal(t, errs.ErrInvalidCapacity, NewArrayBlockingQueue[int](3).SetCapacity(0))
+
+	// 扩容会唤醒阻塞的入队者
+	t.Run("grow", func(t *testing.T) {
+		q := NewArrayBlockingQueue[int](3)
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		// 让 head 不在 0 的位置，验证重新分配之后的顺序
+		_, err := q.EnqueueAll(ctx, []int{0, 1, 2})
+		require.NoError(t, err)
+		_, err = q.Dequeue(ctx)
+		require.NoError(t, err)
+		require.NoError(t, q.Enqueue(ctx, 3))
+
+		go func() {
+			time.Sleep(time.Millisecond * 100)
+			require.NoError(t, q.SetCapacity(5))
+		}()
+		require.NoError(t, q.Enqueue(ctx, 4))
+		require.NoError(t, q.Enqueue(ctx, 5))
+		assert.Equal(t, 5, q.Cap())
+		assert.True(t, q.IsFull())
+		assert.Equal(t, []int{1, 2, 3, 4, 5}, q.AsSlice())
+		assert.False(t, q.TryEnqueue(6))
+		vals, err := q.DequeueUpTo(ctx, 5)
+		require.NoError(t, err)
+		assert.Equal(t, []int{1, 2, 3, 4, 5}, vals)
+	})
+
+	// 缩容之后，元素保留，直到元素个数降到新的容量以下才能入队
+	t.Run("shrink", func(t *testing.T) {
+		q := NewArrayBlockingQueue[int](4)
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		_, err := q.EnqueueAll(ctx, []int{1, 2, 3})
+		require.NoError(t, err)
+		require.NoError(t, q.SetCapacity(2))
+		assert.Equal(t, []int{1, 2, 3}, q.AsSlice())
+		assert.True(t, q.IsFull())
+
+		assert.False(t, q.TryEnqueue(4))
+		_, err = q.Dequeue(ctx)
+		require.NoError(t, err)
+		// 元素个数是 2，依旧是满的
+		assert.False(t, q.TryEnqueue(4))
+		_, err = q.Dequeue(ctx)
+		require.NoError(t, err)
+		assert.True(t, q.TryEnqueue(4))
+		assert.False(t, q.TryEnqueue(5))
+		assert.Equal(t, []int{3, 4}, q.AsSlice())
+
+		// 再扩容回去
+		require.NoError(t, q.SetCapacity(4))
+		assert.True(t, q.TryEnqueue(5))
+		assert.True(t, q.TryEnqueue(6))
+		assert.False(t, q.TryEnqueue(7))
+		assert.Equal(t, []int{3, 4, 5, 6}, q.AsSlice())
+	})
+
+	// 缩容到元素个数以下，空闲的令牌要全部拿走，只记录真正欠下的部分
+	t.Run("shrink below len with free slots", func(t *testing.T) {
+		q := NewArrayBlockingQueue[int](8)
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		_, err := q.EnqueueAll(ctx, []int{1, 2})
+		require.NoError(t, err)
+		require.NoError(t, q.SetCapacity(1))
+		assert.Equal(t, int64(1), q.shrinkDebt)
+		assert.False(t, q.TryEnqueue(3))
+
+		_, err = q.Dequeue(ctx)
+		require.NoError(t, err)
+		assert.False(t, q.TryEnqueue(3))
+		_, err = q.Dequeue(ctx)
+		require.NoError(t, err)
+		assert.True(t, q.TryEnqueue(3))
+		assert.False(t, q.TryEnqueue(4))
+		assert.Equal(t, []int{3}, q.AsSlice())
+	})
+}
+
+func TestArrayBlockingQueueV2_SetCapacity(t *testing.T) {
+	q := NewArrayBlockingQueueV2[int](2)
+	assert.Equal(t, errs.ErrInvalidCapacity, q.SetCapacity(-1))
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	require.NoError(t, q.Enqueue(ctx, 1))
+	require.NoError(t, q.Enqueue(ctx, 2))
+	go func() {
+		time.Sleep(time.Millisecond * 100)
+		require.NoError(t, q.SetCapacity(3))
+	}()
+	require.NoError(t, q.Enqueue(ctx, 3))
+	assert.Equal(t, 3, q.Cap())
+
+	require.NoError(t, q.SetCapacity(1))
+	assert.False(t, q.TryEnqueue(4))
+	for _, want := range []int{1, 2} {
+		val, err := q.Dequeue(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, want, val)
+		assert.False(t, q.TryEnqueue(4))
+	}
+	val, err := q.Dequeue(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 3, val)
+	assert.True(t, q.TryEnqueue(4))
+}
+
+func TestArrayBlockingQueueV2_TryEnqueueAndTryDequeue(t *testing.T) {
+	q := NewArrayBlockingQueueV2[int](2)
+	assert.True(t, q.TryEnqueue(123))
+	assert.True(t, q.TryEnqueue(234))
+	assert.False(t, q.TryEnqueue(345))
+	assert.Equal(t, errs.ErrOutOfCapacity, q.Offer(345, 0))
+	assert.Equal(t, context.DeadlineExceeded, q.Offer(345, time.Millisecond*100))
+
+	val, ok := q.TryDequeue()
+	assert.True(t, ok)
+	assert.Equal(t, 123, val)
+	val, err := q.Poll(time.Second)
+	require.NoError(t, err)
+	assert.Equal(t, 234, val)
+	_, ok = q.TryDequeue()
+	assert.False(t, ok)
+	_, err = q.Poll(0)
+	assert.Equal(t, errs.ErrEmptyQueue, err)
+	_, err = q.Poll(time.Millisecond * 100)
+	assert.Equal(t, context.DeadlineExceeded, err)
+
+	require.NoError(t, q.Close())
+	assert.False(t, q.TryEnqueue(456))
+	_, err = q.Poll(0)
+	assert.Equal(t, errs.ErrQueueClosed, err)
+}
+
 // ExampleNewArrayBlockingQueue
 func ExampleNewArrayBlockingQueue() {
 	q := NewArrayBlockingQueue[int](10)
@@ -0,0 +1,106 @@
+package concurrent_queue
+
+import (
+	"math"
+	"math/rand"
+	"time"
+)
+
+// Backoff 重试的退避策略
+type Backoff interface {
+	// Next 返回第 attempt 次重试之前需要等待的时间，attempt 从 1 开始
+	Next(attempt int) time.Duration
+}
+
+// ConstantBackoff 每一次重试都等待 delay
+func ConstantBackoff(delay time.Duration) Backoff {
+	return constantBackoff{delay: delay}
+}
+
+type constantBackoff struct {
+	delay time.Duration
+}
+
+func (b constantBackoff) Next(int) time.Duration {
+	return b.delay
+}
+
+// LinearBackoff 第 attempt 次重试等待 initial + step * (attempt - 1)
+func LinearBackoff(initial, step time.Duration) Backoff {
+	return linearBackoff{initial: initial, step: step}
+}
+
+type linearBackoff struct {
+	initial time.Duration
+	step    time.Duration
+}
+
+func (b linearBackoff) Next(attempt int) time.Duration {
+	if attempt < 1 {
+		attempt = 1
+	}
+	return saturate(float64(b.initial) + float64(b.step)*float64(attempt-1))
+}
+
+// ExponentialBackoff 第 attempt 次重试等待 initial * multiplier ^ (attempt - 1)
+// multiplier < 1 的时候按照 2 来处理
+func ExponentialBackoff(initial time.Duration, multiplier float64) Backoff {
+	if multiplier < 1 {
+		multiplier = 2
+	}
+	return exponentialBackoff{initial: initial, multiplier: multiplier}
+}
+
+type exponentialBackoff struct {
+	initial    time.Duration
+	multiplier float64
+}
+
+func (b exponentialBackoff) Next(attempt int) time.Duration {
+	if attempt < 1 {
+		attempt = 1
+	}
+	return saturate(float64(b.initial) * math.Pow(b.multiplier, float64(attempt-1)))
+}
+
+// DecorrelatedJitterBackoff 去相关抖动
+// 每一次等待的时间在 [base, min(max, 上一次等待的时间 * 3)) 之间随机，第一次重试等待 base。
+// 参考 https://aws.amazon.com/blogs/architecture/exponential-backoff-and-jitter/
+func DecorrelatedJitterBackoff(base, max time.Duration) Backoff {
+	if max < base {
+		max = base
+	}
+	return decorrelatedJitterBackoff{base: base, max: max}
+}
+
+type decorrelatedJitterBackoff struct {
+	base time.Duration
+	max  time.Duration
+}
+
+// Next 调用者不一定记得上一次等待了多长时间，所以这里从第一次开始重新推演一遍，
+// 这样得到的结果和记住上一次的等待时间的分布是一样的
+func (b decorrelatedJitterBackoff) Next(attempt int) time.Duration {
+	res := b.base
+	for i := 1; i < attempt; i++ {
+		upper := saturate(float64(res) * 3)
+		if upper > b.max {
+			upper = b.max
+		}
+		if upper > b.base {
+			res = b.base + time.Duration(rand.Int63n(int64(upper-b.base)))
+		}
+	}
+	return res
+}
+
+// saturate 把浮点数转换为 time.Duration，溢出的时候返回最大值
+func saturate(d float64) time.Duration {
+	if d >= math.MaxInt64 {
+		return math.MaxInt64
+	}
+	if d < 0 {
+		return 0
+	}
+	return time.Duration(d)
+}
@@ -0,0 +1,91 @@
+package concurrent_queue
+
+import (
+	"github.com/stretchr/testify/assert"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestBackoff(t *testing.T) {
+	t.Parallel()
+	testCases := []struct {
+		name    string
+		backoff Backoff
+		attempt int
+		want    time.Duration
+	}{
+		{
+			name:    "constant",
+			backoff: ConstantBackoff(time.Second),
+			attempt: 3,
+			want:    time.Second,
+		},
+		{
+			name:    "linear first",
+			backoff: LinearBackoff(time.Second, time.Millisecond*500),
+			attempt: 1,
+			want:    time.Second,
+		},
+		{
+			name:    "linear",
+			backoff: LinearBackoff(time.Second, time.Millisecond*500),
+			attempt: 3,
+			want:    time.Second * 2,
+		},
+		{
+			name:    "exponential first",
+			backoff: ExponentialBackoff(time.Millisecond*100, 2),
+			attempt: 1,
+			want:    time.Millisecond * 100,
+		},
+		{
+			name:    "exponential",
+			backoff: ExponentialBackoff(time.Millisecond*100, 3),
+			attempt: 3,
+			want:    time.Millisecond * 900,
+		},
+		{
+			// 非法的 multiplier 按照 2 来处理
+			name:    "exponential invalid multiplier",
+			backoff: ExponentialBackoff(time.Millisecond*100, 0.5),
+			attempt: 4,
+			want:    time.Millisecond * 800,
+		},
+		{
+			name:    "exponential overflow",
+			backoff: ExponentialBackoff(time.Second, 2),
+			attempt: 100,
+			want:    math.MaxInt64,
+		},
+		{
+			name:    "decorrelated jitter first",
+			backoff: DecorrelatedJitterBackoff(time.Millisecond*100, time.Second),
+			attempt: 1,
+			want:    time.Millisecond * 100,
+		},
+	}
+	for _, tt := range testCases {
+		tc := tt
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.want, tc.backoff.Next(tc.attempt))
+		})
+	}
+}
+
+func TestDecorrelatedJitterBackoff(t *testing.T) {
+	t.Parallel()
+	base, max := time.Millisecond*100, time.Second
+	b := DecorrelatedJitterBackoff(base, max)
+	for attempt := 2; attempt < 20; attempt++ {
+		for i := 0; i < 100; i++ {
+			res := b.Next(attempt)
+			assert.True(t, res >= base)
+			assert.True(t, res < max)
+		}
+	}
+	// 第二次重试最多等待 base * 3
+	for i := 0; i < 100; i++ {
+		assert.True(t, b.Next(2) < base*3)
+	}
+}
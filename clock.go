@@ -0,0 +1,178 @@
+package concurrent_queue
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock 时钟，用于在测试中替换掉真实的时间
+// 通过 WithClock 传入
+type Clock interface {
+	Now() time.Time
+	NewTimer(d time.Duration) Timer
+}
+
+// Timer 对应 time.Timer
+type Timer interface {
+	C() <-chan time.Time
+	Stop() bool
+	Reset(d time.Duration) bool
+}
+
+// SystemClock 真实的时钟，这是默认的时钟
+func SystemClock() Clock {
+	return systemClock{}
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+func (systemClock) NewTimer(d time.Duration) Timer {
+	return systemTimer{Timer: time.NewTimer(d)}
+}
+
+type systemTimer struct {
+	*time.Timer
+}
+
+func (t systemTimer) C() <-chan time.Time {
+	return t.Timer.C
+}
+
+// ManualClock 手动推进的时钟，只有调用 Advance 或者 Set 的时候时间才会变化
+// 用于在测试中确定性地控制时间，而不需要真的 sleep
+//
+//	clock := NewManualClock(time.Now())
+//	q := NewDelayQueue[elem](10, WithClock[elem](clock))
+//	// 等待出队者开始等待堆顶元素到期
+//	clock.BlockUntil(1)
+//	clock.Advance(time.Second)
+type ManualClock struct {
+	mutex *sync.Mutex
+	// 定时器发生变化的时候通知 BlockUntil
+	changed *sync.Cond
+	now     time.Time
+	timers  map[*manualTimer]struct{}
+}
+
+// NewManualClock 创建手动推进的时钟，now 是初始时间
+func NewManualClock(now time.Time) *ManualClock {
+	m := &sync.Mutex{}
+	return &ManualClock{
+		mutex:   m,
+		changed: sync.NewCond(m),
+		now:     now,
+		timers:  make(map[*manualTimer]struct{}, 4),
+	}
+}
+
+func (c *ManualClock) Now() time.Time {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.now
+}
+
+func (c *ManualClock) NewTimer(d time.Duration) Timer {
+	t := &manualTimer{
+		clock: c,
+		c:     make(chan time.Time, 1),
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.schedule(t, d)
+	return t
+}
+
+// Advance 把时间往后推进 d，到期的定时器会被触发
+func (c *ManualClock) Advance(d time.Duration) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.now = c.now.Add(d)
+	c.fire()
+}
+
+// Set 把时间设置为 now，到期的定时器会被触发
+func (c *ManualClock) Set(now time.Time) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.now = now
+	c.fire()
+}
+
+// Timers 还没有到期也没有被停止的定时器个数
+func (c *ManualClock) Timers() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return len(c.timers)
+}
+
+// BlockUntil 阻塞直到至少有 n 个还没有到期也没有被停止的定时器
+// 用于等待别的 goroutine 开始等待，然后再推进时间
+func (c *ManualClock) BlockUntil(n int) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for len(c.timers) < n {
+		c.changed.Wait()
+	}
+}
+
+// schedule 必须持有锁
+func (c *ManualClock) schedule(t *manualTimer, d time.Duration) {
+	t.deadline = c.now.Add(d)
+	if d <= 0 {
+		t.send(c.now)
+		return
+	}
+	c.timers[t] = struct{}{}
+	c.changed.Broadcast()
+}
+
+// fire 触发所有到期的定时器，必须持有锁
+func (c *ManualClock) fire() {
+	for t := range c.timers {
+		if !t.deadline.After(c.now) {
+			delete(c.timers, t)
+			t.send(c.now)
+		}
+	}
+	c.changed.Broadcast()
+}
+
+type manualTimer struct {
+	clock    *ManualClock
+	c        chan time.Time
+	deadline time.Time
+}
+
+func (t *manualTimer) C() <-chan time.Time {
+	return t.c
+}
+
+func (t *manualTimer) Stop() bool {
+	t.clock.mutex.Lock()
+	defer t.clock.mutex.Unlock()
+	_, active := t.clock.timers[t]
+	delete(t.clock.timers, t)
+	t.clock.changed.Broadcast()
+	return active
+}
+
+func (t *manualTimer) Reset(d time.Duration) bool {
+	t.clock.mutex.Lock()
+	defer t.clock.mutex.Unlock()
+	_, active := t.clock.timers[t]
+	delete(t.clock.timers, t)
+	t.clock.schedule(t, d)
+	return active
+}
+
+// send 和 time.Timer 一样，channel 里面已经有值的时候就丢弃
+func (t *manualTimer) send(now time.Time) {
+	select {
+	case t.c <- now:
+	default:
+	}
+}
@@ -0,0 +1,78 @@
+package concurrent_queue
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestManualClock(t *testing.T) {
+	t.Parallel()
+	start := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock := NewManualClock(start)
+	assert.Equal(t, start, clock.Now())
+
+	t1 := clock.NewTimer(time.Second)
+	t2 := clock.NewTimer(time.Second * 2)
+	assert.Equal(t, 2, clock.Timers())
+	clock.Advance(time.Millisecond * 500)
+	assertNotFired(t, t1)
+
+	clock.Advance(time.Millisecond * 500)
+	assert.Equal(t, start.Add(time.Second), <-t1.C())
+	assertNotFired(t, t2)
+	assert.Equal(t, 1, clock.Timers())
+
+	// 停止之后就不会再触发了
+	assert.True(t, t2.Stop())
+	assert.False(t, t2.Stop())
+	clock.Set(start.Add(time.Hour))
+	assertNotFired(t, t2)
+
+	// 已经触发过的定时器可以重新设置
+	assert.False(t, t1.Reset(time.Second))
+	assert.True(t, t1.Reset(time.Minute))
+	clock.Advance(time.Second)
+	assertNotFired(t, t1)
+	clock.Advance(time.Minute)
+	assert.Equal(t, start.Add(time.Hour+time.Minute+time.Second), <-t1.C())
+
+	// 非正数的定时器立刻触发
+	t3 := clock.NewTimer(0)
+	assert.Equal(t, clock.Now(), <-t3.C())
+	assert.Equal(t, 0, clock.Timers())
+}
+
+func TestManualClock_BlockUntil(t *testing.T) {
+	t.Parallel()
+	clock := NewManualClock(time.Now())
+	fired := make(chan struct{})
+	go func() {
+		<-clock.NewTimer(time.Second).C()
+		close(fired)
+	}()
+	clock.BlockUntil(1)
+	clock.Advance(time.Second)
+	select {
+	case <-fired:
+	case <-time.After(time.Second):
+		t.Fatal("定时器没有被触发")
+	}
+}
+
+func TestSystemClock(t *testing.T) {
+	t.Parallel()
+	clock := SystemClock()
+	assert.WithinDuration(t, time.Now(), clock.Now(), time.Second)
+	timer := clock.NewTimer(time.Millisecond)
+	<-timer.C()
+	assert.False(t, timer.Stop())
+}
+
+func assertNotFired(t *testing.T, timer Timer) {
+	select {
+	case <-timer.C():
+		t.Fatal("定时器不应该被触发")
+	default:
+	}
+}
@@ -0,0 +1,370 @@
+package concurrent_queue
+
+import (
+	"concurrent_queue/errs"
+	"context"
+	"errors"
+	"log"
+	"runtime/debug"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// AutoscalePolicy ConsumerGroup 的扩缩容策略
+// 每隔 Interval 评估一次，满足下面任意一个条件的时候扩容：
+//   - 积压的元素个数超过了 消费者个数 * TargetDepth，会直接扩容到 积压的元素个数 / TargetDepth；
+//   - 队首元素停留的时间超过了 TargetSojourn，需要队列支持 OldestAge，并且开启了 WithSojournTracking；
+//   - 有积压的情况下，handler 的平均耗时超过了 TargetLatency。
+//
+// 不需要扩容，并且消费者的利用率低于一半，缩容之后积压的元素个数也不会超过 TargetDepth 的时候，每次缩容一个消费者
+type AutoscalePolicy struct {
+	// Min 最少的消费者个数，< 1 的时候按照 1 来处理
+	Min int
+	// Max 最多的消费者个数，< Min 的时候等于 Min
+	Max int
+	// TargetDepth 每个消费者可以接受的积压的元素个数，<= 0 的时候按照 10 来处理
+	TargetDepth int
+	// TargetSojourn 队首元素停留的时间的上限，<= 0 的时候不考虑
+	TargetSojourn time.Duration
+	// TargetLatency handler 平均耗时的上限，<= 0 的时候不考虑
+	TargetLatency time.Duration
+	// Interval 评估的间隔，<= 0 的时候按照 1 秒来处理
+	Interval time.Duration
+	// ScaleUpCooldown 上一次扩缩容之后，至少要等这么长时间才能再次扩容，<= 0 的时候不限制
+	ScaleUpCooldown time.Duration
+	// ScaleDownCooldown 上一次扩缩容之后，至少要等这么长时间才能再次缩容，<= 0 的时候按照 30 秒来处理
+	ScaleDownCooldown time.Duration
+}
+
+func (p AutoscalePolicy) normalize() AutoscalePolicy {
+	if p.Min < 1 {
+		p.Min = 1
+	}
+	if p.Max < p.Min {
+		p.Max = p.Min
+	}
+	if p.TargetDepth <= 0 {
+		p.TargetDepth = 10
+	}
+	if p.Interval <= 0 {
+		p.Interval = time.Second
+	}
+	if p.ScaleDownCooldown <= 0 {
+		p.ScaleDownCooldown = time.Second * 30
+	}
+	return p
+}
+
+// ConsumerGroupOption ConsumerGroup 的可选配置
+type ConsumerGroupOption[T any] func(g *ConsumerGroup[T])
+
+// WithPanicReporter 设置 handler panic 时候的回调，t 是导致 panic 的元素，nil 会被忽略
+// 默认通过标准库的 log 输出 panic 的值以及调用栈。
+// 不管有没有设置，panic 都会被恢复，消费者会继续处理下一个元素
+func WithPanicReporter[T any](fn func(t T, r any)) ConsumerGroupOption[T] {
+	return func(g *ConsumerGroup[T]) {
+		if fn != nil {
+			g.onPanic = fn
+		}
+	}
+}
+
+// logPanic 默认的 panic 回调，在 recover 所在的 defer 中调用，所以调用栈里面有 panic 的位置
+func logPanic[T any](t T, r any) {
+	log.Printf("ekit: 消费者组的 handler 处理元素 %v 的时候 panic 了: %v\n%s", t, r, debug.Stack())
+}
+
+// WithScaleCallback 设置扩缩容之后的回调，可以用来记录日志或者对接监控
+func WithScaleCallback[T any](fn func(from, to int)) ConsumerGroupOption[T] {
+	return func(g *ConsumerGroup[T]) {
+		g.onScale = fn
+	}
+}
+
+// WithGroupClock 设置计算冷却时间和 handler 耗时用的时钟，默认是系统时钟，主要用于测试
+func WithGroupClock[T any](clock Clock) ConsumerGroupOption[T] {
+	return func(g *ConsumerGroup[T]) {
+		if clock != nil {
+			g.clock = clock
+		}
+	}
+}
+
+// ConsumerGroup 自动扩缩容的消费者组
+// 每个消费者是一个 goroutine，循环从队列中取出元素交给 handler 处理，
+// 消费者的个数按照 AutoscalePolicy 在 Min 和 Max 之间调整。
+// 队列被关闭之后，消费者在取完剩余的元素之后退出
+type ConsumerGroup[T any] struct {
+	queue   SizedBlockingQueue[T]
+	handler func(ctx context.Context, t T)
+	policy  AutoscalePolicy
+	onPanic func(t T, r any)
+	onScale func(from, to int)
+	clock   Clock
+
+	// ctx 传给 handler 的 ctx，Stop 超时的时候会被取消
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+	quit   chan struct{}
+
+	// handled 和 handledNanos 上一次评估之后处理完毕的元素个数和累计耗时
+	handled      int64
+	handledNanos int64
+	busy         int64
+
+	mutex     sync.Mutex
+	consumers []*consumer
+	lastScale time.Time
+	lastEval  time.Time
+	// closed 队列已经被关闭了，不会再扩容
+	closed  bool
+	stopped bool
+}
+
+type consumer struct {
+	ctx  context.Context
+	stop context.CancelFunc
+}
+
+// NewConsumerGroup 创建消费者组，并且立刻启动 policy.Min 个消费者
+// 队列实现了 OldestAge 的时候才会考虑 TargetSojourn，例如开启了 WithSojournTracking 的 ArrayBlockingQueue 和 LinkedBlockingQueue。
+// 注意，DelayQueue 的 Len 包括还没有到期的元素
+func NewConsumerGroup[T any](queue SizedBlockingQueue[T], handler func(ctx context.Context, t T),
+	policy AutoscalePolicy, opts ...ConsumerGroupOption[T]) *ConsumerGroup[T] {
+	g := &ConsumerGroup[T]{
+		queue:   queue,
+		handler: handler,
+		policy:  policy.normalize(),
+		onPanic: logPanic[T],
+		clock:   systemClock{},
+		quit:    make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(g)
+	}
+	g.ctx, g.cancel = context.WithCancel(context.Background())
+	g.lastEval = g.clock.Now()
+	g.lastScale = g.lastEval
+	g.mutex.Lock()
+	g.spawn(g.policy.Min)
+	g.mutex.Unlock()
+	go g.autoscale()
+	return g
+}
+
+// spawn 启动 n 个消费者，必须持有锁
+func (g *ConsumerGroup[T]) spawn(n int) {
+	for i := 0; i < n; i++ {
+		c := &consumer{}
+		c.ctx, c.stop = context.WithCancel(context.Background())
+		g.consumers = append(g.consumers, c)
+		g.wg.Add(1)
+		go g.consume(c)
+	}
+}
+
+// retire 停止最后启动的 n 个消费者，它们会在处理完当前的元素之后退出，必须持有锁
+func (g *ConsumerGroup[T]) retire(n int) {
+	for i := 0; i < n; i++ {
+		last := len(g.consumers) - 1
+		g.consumers[last].stop()
+		g.consumers[last] = nil
+		g.consumers = g.consumers[:last]
+	}
+}
+
+func (g *ConsumerGroup[T]) consume(c *consumer) {
+	defer g.wg.Done()
+	defer c.stop()
+	attempt := 0
+	for {
+		t, err := g.queue.Dequeue(c.ctx)
+		if err == nil {
+			attempt = 0
+			g.handle(t)
+			continue
+		}
+		if c.ctx.Err() != nil {
+			return
+		}
+		if errors.Is(err, errs.ErrQueueClosed) {
+			g.leave(c)
+			return
+		}
+		// 别的错误有可能只是暂时的，退避之后重试
+		attempt++
+		if !g.sleep(c.ctx, consumerBackoff(attempt)) {
+			return
+		}
+	}
+}
+
+// consumerBackoff 出队失败之后，第 attempt 次重试之前等待的时间
+func consumerBackoff(attempt int) time.Duration {
+	d := ExponentialBackoff(time.Millisecond*10, 2).Next(attempt)
+	if d > time.Second {
+		d = time.Second
+	}
+	return d
+}
+
+// sleep 等待 d，ctx 被取消的时候返回 false
+func (g *ConsumerGroup[T]) sleep(ctx context.Context, d time.Duration) bool {
+	timer := g.clock.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C():
+		return true
+	}
+}
+
+// leave 消费者因为队列关闭而退出，之后不会再扩容
+// 只有 Dequeue 返回 errs.ErrQueueClosed 的时候才会调用
+func (g *ConsumerGroup[T]) leave(c *consumer) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	g.closed = true
+	for i, cc := range g.consumers {
+		if cc == c {
+			g.consumers = append(g.consumers[:i], g.consumers[i+1:]...)
+			return
+		}
+	}
+}
+
+func (g *ConsumerGroup[T]) handle(t T) {
+	start := g.clock.Now()
+	atomic.AddInt64(&g.busy, 1)
+	defer func() {
+		atomic.AddInt64(&g.busy, -1)
+		atomic.AddInt64(&g.handled, 1)
+		atomic.AddInt64(&g.handledNanos, int64(g.clock.Now().Sub(start)))
+		if r := recover(); r != nil {
+			g.onPanic(t, r)
+		}
+	}()
+	g.handler(g.ctx, t)
+}
+
+func (g *ConsumerGroup[T]) autoscale() {
+	timer := g.clock.NewTimer(g.policy.Interval)
+	defer timer.Stop()
+	for {
+		select {
+		case <-g.quit:
+			return
+		case <-timer.C():
+			g.evaluate()
+			timer.Reset(g.policy.Interval)
+		}
+	}
+}
+
+// evaluate 评估一次，按照需要扩容或者缩容
+func (g *ConsumerGroup[T]) evaluate() {
+	now := g.clock.Now()
+	g.mutex.Lock()
+	elapsed := now.Sub(g.lastEval)
+	g.lastEval = now
+	handled := atomic.SwapInt64(&g.handled, 0)
+	nanos := atomic.SwapInt64(&g.handledNanos, 0)
+	if g.closed || g.stopped {
+		g.mutex.Unlock()
+		return
+	}
+	from := len(g.consumers)
+	to := g.desired(from, handled, time.Duration(nanos), elapsed)
+	switch {
+	case to > from && now.Sub(g.lastScale) >= g.policy.ScaleUpCooldown:
+		g.spawn(to - from)
+	case to < from && now.Sub(g.lastScale) >= g.policy.ScaleDownCooldown:
+		g.retire(from - to)
+	default:
+		to = from
+	}
+	if to != from {
+		g.lastScale = now
+	}
+	g.mutex.Unlock()
+	if to != from && g.onScale != nil {
+		g.onScale(from, to)
+	}
+}
+
+// desired 计算期望的消费者个数
+// handled 和 busyTime 是最近 elapsed 时间内处理完毕的元素个数和累计耗时
+func (g *ConsumerGroup[T]) desired(n int, handled int64, busyTime, elapsed time.Duration) int {
+	p := g.policy
+	depth := g.queue.Len()
+	var latency time.Duration
+	if handled > 0 {
+		latency = busyTime / time.Duration(handled)
+	}
+	if depth > n*p.TargetDepth ||
+		(p.TargetSojourn > 0 && g.oldestAge() > p.TargetSojourn) ||
+		(p.TargetLatency > 0 && latency > p.TargetLatency && depth > 0) {
+		res := n + 1
+		if d := (depth + p.TargetDepth - 1) / p.TargetDepth; d > res {
+			res = d
+		}
+		if res > p.Max {
+			res = p.Max
+		}
+		return res
+	}
+	if n <= p.Min || elapsed <= 0 || depth > (n-1)*p.TargetDepth {
+		return n
+	}
+	// 还在处理的元素也算在利用率里面
+	busy := atomic.LoadInt64(&g.busy)
+	utilization := (float64(busyTime) + float64(busy)*float64(elapsed)) / (float64(elapsed) * float64(n))
+	if utilization < 0.5 {
+		return n - 1
+	}
+	return n
+}
+
+func (g *ConsumerGroup[T]) oldestAge() time.Duration {
+	if q, ok := g.queue.(interface{ OldestAge() time.Duration }); ok {
+		return q.OldestAge()
+	}
+	return 0
+}
+
+// Size 当前消费者的个数
+func (g *ConsumerGroup[T]) Size() int {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	return len(g.consumers)
+}
+
+// Stop 停止所有的消费者，不会关闭队列，队列中剩余的元素也不会被处理
+// 正在处理的元素会继续处理，Stop 会等待它们处理完毕。
+// ctx 超时的时候，传给 handler 的 ctx 会被取消，Stop 不再等待并返回 ctx 的错误
+func (g *ConsumerGroup[T]) Stop(ctx context.Context) error {
+	g.mutex.Lock()
+	if !g.stopped {
+		g.stopped = true
+		close(g.quit)
+		g.retire(len(g.consumers))
+	}
+	g.mutex.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		g.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		g.cancel()
+		return nil
+	case <-ctx.Done():
+		g.cancel()
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,306 @@
+package concurrent_queue
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"log"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestConsumerGroup_ScaleByDepth(t *testing.T) {
+	t.Parallel()
+	clock := NewManualClock(time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC))
+	q := NewLinkedBlockingQueue[int](0)
+	release := make(chan struct{})
+	var scales [][2]int
+	var mutex sync.Mutex
+	g := NewConsumerGroup[int](q, func(ctx context.Context, t int) {
+		<-release
+	}, AutoscalePolicy{
+		Min:               1,
+		Max:               4,
+		TargetDepth:       2,
+		Interval:          time.Hour * 24,
+		ScaleUpCooldown:   time.Second * 10,
+		ScaleDownCooldown: time.Second * 30,
+	}, WithGroupClock[int](clock), WithScaleCallback[int](func(from, to int) {
+		mutex.Lock()
+		scales = append(scales, [2]int{from, to})
+		mutex.Unlock()
+	}))
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+	assert.Equal(t, 1, g.Size())
+
+	for i := 0; i < 7; i++ {
+		require.NoError(t, q.Enqueue(ctx, i))
+	}
+	require.Eventually(t, func() bool {
+		return q.Len() == 6
+	}, time.Second*5, time.Millisecond*10)
+	clock.Advance(time.Second * 10)
+	// 直接扩容到 6 / 2 = 3
+	g.evaluate()
+	assert.Equal(t, 3, g.Size())
+
+	require.Eventually(t, func() bool {
+		return q.Len() == 4
+	}, time.Second*5, time.Millisecond*10)
+	for i := 0; i < 4; i++ {
+		require.NoError(t, q.Enqueue(ctx, i))
+	}
+	// 还在冷却
+	g.evaluate()
+	assert.Equal(t, 3, g.Size())
+	clock.Advance(time.Second * 10)
+	g.evaluate()
+	assert.Equal(t, 4, g.Size())
+
+	// 不会超过 Max
+	require.Eventually(t, func() bool {
+		return q.Len() == 7
+	}, time.Second*5, time.Millisecond*10)
+	for i := 0; i < 4; i++ {
+		require.NoError(t, q.Enqueue(ctx, i))
+	}
+	clock.Advance(time.Second * 10)
+	g.evaluate()
+	assert.Equal(t, 4, g.Size())
+
+	// 处理完积压之后，每次缩容一个
+	close(release)
+	require.Eventually(t, func() bool {
+		return q.Len() == 0 && atomic.LoadInt64(&g.busy) == 0
+	}, time.Second*5, time.Millisecond*10)
+	// 还在冷却
+	clock.Advance(time.Second * 10)
+	g.evaluate()
+	assert.Equal(t, 4, g.Size())
+	for want := 3; want >= 1; want-- {
+		clock.Advance(time.Second * 30)
+		g.evaluate()
+		assert.Equal(t, want, g.Size())
+	}
+	// 不会少于 Min
+	clock.Advance(time.Second * 30)
+	g.evaluate()
+	assert.Equal(t, 1, g.Size())
+
+	require.NoError(t, g.Stop(ctx))
+	assert.Equal(t, 0, g.Size())
+	mutex.Lock()
+	defer mutex.Unlock()
+	assert.Equal(t, [][2]int{{1, 3}, {3, 4}, {4, 3}, {3, 2}, {2, 1}}, scales)
+}
+
+func TestConsumerGroup_ScaleBySojournAndLatency(t *testing.T) {
+	t.Parallel()
+	testCases := []struct {
+		name   string
+		policy AutoscalePolicy
+		// handler 每处理一个元素，时钟前进多长时间
+		latency time.Duration
+	}{
+		{
+			name: "sojourn",
+			policy: AutoscalePolicy{
+				Max:           2,
+				TargetDepth:   100,
+				TargetSojourn: time.Millisecond * 10,
+			},
+		},
+		{
+			name: "latency",
+			policy: AutoscalePolicy{
+				Max:           2,
+				TargetDepth:   100,
+				TargetLatency: time.Second,
+			},
+			latency: time.Second * 2,
+		},
+	}
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			clock := NewManualClock(time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC))
+			q := NewArrayBlockingQueue[int](10, WithSojournTracking[int]())
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+			defer cancel()
+			release := make(chan struct{})
+			handled := make(chan struct{}, 10)
+			tc.policy.Interval = time.Hour * 24
+			g := NewConsumerGroup[int](q, func(ctx context.Context, t int) {
+				clock.Advance(tc.latency)
+				handled <- struct{}{}
+				if t > 0 {
+					<-release
+				}
+			}, tc.policy, WithGroupClock[int](clock))
+
+			// 第一个元素很快就处理完了，第二个元素会卡住，这样第三个元素就会积压
+			for i := 0; i < 3; i++ {
+				require.NoError(t, q.Enqueue(ctx, i))
+			}
+			<-handled
+			<-handled
+			time.Sleep(time.Millisecond * 20)
+			g.evaluate()
+			assert.Equal(t, 2, g.Size())
+			close(release)
+			require.NoError(t, g.Stop(ctx))
+		})
+	}
+}
+
+func TestConsumerGroup_Panic(t *testing.T) {
+	t.Parallel()
+	q := NewArrayBlockingQueue[int](10)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+	panics := make(chan int, 10)
+	var sum int64
+	g := NewConsumerGroup[int](q, func(ctx context.Context, val int) {
+		if val%2 == 0 {
+			panic("mock panic")
+		}
+		atomic.AddInt64(&sum, int64(val))
+	}, AutoscalePolicy{}, WithPanicReporter[int](func(val int, r any) {
+		assert.Equal(t, "mock panic", r)
+		panics <- val
+	}))
+	for i := 1; i <= 4; i++ {
+		require.NoError(t, q.Enqueue(ctx, i))
+	}
+	assert.Equal(t, 2, <-panics)
+	assert.Equal(t, 4, <-panics)
+	require.Eventually(t, func() bool {
+		return atomic.LoadInt64(&sum) == 4
+	}, time.Second*5, time.Millisecond*10)
+	require.NoError(t, g.Stop(ctx))
+}
+
+func TestConsumerGroup_DefaultPanicReporter(t *testing.T) {
+	// 修改了全局的 log 输出，所以不能并发执行
+	buf := &bytes.Buffer{}
+	log.SetOutput(buf)
+	defer log.SetOutput(os.Stderr)
+
+	q := NewArrayBlockingQueue[int](10)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+	handled := make(chan int, 10)
+	g := NewConsumerGroup[int](q, func(ctx context.Context, val int) {
+		handled <- val
+		if val == 1 {
+			panic("mock panic")
+		}
+	}, AutoscalePolicy{}, WithPanicReporter[int](nil))
+	require.NoError(t, q.Enqueue(ctx, 1))
+	require.NoError(t, q.Enqueue(ctx, 2))
+	assert.Equal(t, 1, <-handled)
+	// panic 之后依旧可以处理下一个元素
+	assert.Equal(t, 2, <-handled)
+	require.NoError(t, g.Stop(ctx))
+
+	// panic 的值和调用栈都会被输出
+	out := buf.String()
+	assert.Contains(t, out, "mock panic")
+	assert.Contains(t, out, "TestConsumerGroup_DefaultPanicReporter")
+}
+
+func TestConsumerGroup_Stop(t *testing.T) {
+	t.Parallel()
+	q := NewArrayBlockingQueue[int](10)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+	started := make(chan struct{})
+	stopped := make(chan error, 1)
+	g := NewConsumerGroup[int](q, func(ctx context.Context, t int) {
+		close(started)
+		<-ctx.Done()
+		stopped <- ctx.Err()
+	}, AutoscalePolicy{Min: 2})
+	assert.Equal(t, 2, g.Size())
+	require.NoError(t, q.Enqueue(ctx, 1))
+	<-started
+
+	// handler 一直不结束，超时之后它拿到的 ctx 会被取消
+	stopCtx, stopCancel := context.WithTimeout(ctx, time.Millisecond*50)
+	defer stopCancel()
+	assert.Equal(t, context.DeadlineExceeded, g.Stop(stopCtx))
+	assert.Equal(t, context.Canceled, <-stopped)
+	require.NoError(t, g.Stop(ctx))
+
+	// 队列中剩余的元素不会被处理
+	require.NoError(t, q.Enqueue(ctx, 2))
+	assert.Equal(t, 1, q.Len())
+}
+
+func TestConsumerGroup_QueueClosed(t *testing.T) {
+	t.Parallel()
+	q := NewArrayBlockingQueue[int](10)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+	var handled int64
+	g := NewConsumerGroup[int](q, func(ctx context.Context, t int) {
+		atomic.AddInt64(&handled, 1)
+	}, AutoscalePolicy{Min: 3})
+	for i := 0; i < 5; i++ {
+		require.NoError(t, q.Enqueue(ctx, i))
+	}
+	require.NoError(t, q.Close())
+	// 取完剩余的元素之后，消费者全部退出
+	require.Eventually(t, func() bool {
+		return g.Size() == 0
+	}, time.Second*5, time.Millisecond*10)
+	assert.Equal(t, int64(5), atomic.LoadInt64(&handled))
+	// 队列关闭之后不会再扩容
+	g.evaluate()
+	assert.Equal(t, 0, g.Size())
+	require.NoError(t, g.Stop(ctx))
+}
+
+func TestConsumerGroup_DequeueError(t *testing.T) {
+	t.Parallel()
+	q := &flakyQueue[int]{SizedBlockingQueue: NewArrayBlockingQueue[int](10), failures: 3}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+	handled := make(chan int, 1)
+	g := NewConsumerGroup[int](q, func(ctx context.Context, t int) {
+		handled <- t
+	}, AutoscalePolicy{Min: 1})
+	require.NoError(t, q.Enqueue(ctx, 1))
+	// 暂时的错误不会让消费者退出，退避之后继续消费
+	select {
+	case val := <-handled:
+		assert.Equal(t, 1, val)
+	case <-ctx.Done():
+		t.Fatal("元素没有被处理")
+	}
+	assert.Equal(t, 1, g.Size())
+	assert.Equal(t, int64(0), atomic.LoadInt64(&q.failures))
+	require.NoError(t, g.Stop(ctx))
+}
+
+// flakyQueue 前 failures 次出队返回错误
+type flakyQueue[T any] struct {
+	SizedBlockingQueue[T]
+	failures int64
+}
+
+func (q *flakyQueue[T]) Dequeue(ctx context.Context) (T, error) {
+	if atomic.AddInt64(&q.failures, -1) >= 0 {
+		var t T
+		return t, errors.New("ekit: 暂时不可用")
+	}
+	atomic.StoreInt64(&q.failures, 0)
+	return q.SizedBlockingQueue.Dequeue(ctx)
+}
@@ -0,0 +1,249 @@
+package concurrent_queue
+
+import (
+	"concurrent_queue/errs"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CronSchedule 标准的 5 段 cron 表达式：分 时 日 月 周
+//
+//	字段   取值范围           名字
+//	分     0-59
+//	时     0-23
+//	日     1-31
+//	月     1-12              JAN-DEC
+//	周     0-7，0 和 7 都是周日 SUN-SAT
+//
+// 每个字段支持 *、a、a-b、*/n、a-b/n、a/n 以及用逗号分隔的列表。
+// 和 Vixie cron 一样，日和周都不是以 * 开头的时候，只要满足其中一个就可以。
+// 也支持 @yearly、@annually、@monthly、@weekly、@daily、@midnight 和 @hourly。
+type CronSchedule struct {
+	minute uint64
+	hour   uint64
+	dom    uint64
+	month  uint64
+	dow    uint64
+	// domStar 和 dowStar 日和周是否以 * 开头
+	domStar bool
+	dowStar bool
+	loc     *time.Location
+}
+
+type cronField struct {
+	min, max int
+	names    map[string]int
+}
+
+var (
+	minuteField = cronField{min: 0, max: 59}
+	hourField   = cronField{min: 0, max: 23}
+	domField    = cronField{min: 1, max: 31}
+	monthField  = cronField{min: 1, max: 12, names: map[string]int{
+		"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6,
+		"jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12,
+	}}
+	// 7 也是周日，解析之后会被合并到 0
+	dowField = cronField{min: 0, max: 7, names: map[string]int{
+		"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6,
+	}}
+)
+
+var cronDescriptors = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// ParseCron 解析 cron 表达式，使用 time.Local 时区
+// 可以通过 CRON_TZ= 或者 TZ= 前缀指定时区，例如 "CRON_TZ=Asia/Shanghai 0 9 * * MON-FRI"
+// 表达式不合法的时候返回的 error 包装了 errs.ErrInvalidCron
+func ParseCron(spec string) (*CronSchedule, error) {
+	return ParseCronInLocation(spec, time.Local)
+}
+
+// ParseCronInLocation 和 ParseCron 一样，但是默认使用 loc 时区
+func ParseCronInLocation(spec string, loc *time.Location) (*CronSchedule, error) {
+	spec = strings.TrimSpace(spec)
+	if strings.HasPrefix(spec, "CRON_TZ=") || strings.HasPrefix(spec, "TZ=") {
+		i := strings.IndexAny(spec, " \t")
+		if i < 0 {
+			return nil, fmt.Errorf("%w: 缺少时区之后的表达式 %q", errs.ErrInvalidCron, spec)
+		}
+		name := spec[strings.Index(spec, "=")+1 : i]
+		var err error
+		if loc, err = time.LoadLocation(name); err != nil {
+			return nil, fmt.Errorf("%w: 未知的时区 %q: %v", errs.ErrInvalidCron, name, err)
+		}
+		spec = strings.TrimSpace(spec[i:])
+	}
+	if expr, ok := cronDescriptors[strings.ToLower(spec)]; ok {
+		spec = expr
+	}
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("%w: 需要 5 个字段，实际是 %d 个 %q", errs.ErrInvalidCron, len(fields), spec)
+	}
+	res := &CronSchedule{
+		domStar: strings.HasPrefix(fields[2], "*"),
+		dowStar: strings.HasPrefix(fields[4], "*"),
+		loc:     loc,
+	}
+	var err error
+	for i, f := range []struct {
+		bits  *uint64
+		field cronField
+	}{
+		{bits: &res.minute, field: minuteField},
+		{bits: &res.hour, field: hourField},
+		{bits: &res.dom, field: domField},
+		{bits: &res.month, field: monthField},
+		{bits: &res.dow, field: dowField},
+	} {
+		if *f.bits, err = f.field.parse(fields[i]); err != nil {
+			return nil, err
+		}
+	}
+	if res.dow&(1<<7) != 0 {
+		res.dow |= 1
+	}
+	return res, nil
+}
+
+// parse 把字段解析为位图，第 i 位为 1 说明 i 是允许的值
+func (f cronField) parse(expr string) (uint64, error) {
+	var res uint64
+	for _, part := range strings.Split(expr, ",") {
+		bits, err := f.parsePart(part)
+		if err != nil {
+			return 0, err
+		}
+		res |= bits
+	}
+	return res, nil
+}
+
+func (f cronField) parsePart(part string) (uint64, error) {
+	rangeExpr, stepExpr, hasStep := strings.Cut(part, "/")
+	step := 1
+	if hasStep {
+		var err error
+		if step, err = strconv.Atoi(stepExpr); err != nil || step <= 0 {
+			return 0, fmt.Errorf("%w: 步长不合法 %q", errs.ErrInvalidCron, part)
+		}
+	}
+	var start, end int
+	switch {
+	case rangeExpr == "*":
+		start, end = f.min, f.max
+	case strings.Contains(rangeExpr, "-"):
+		lo, hi, _ := strings.Cut(rangeExpr, "-")
+		var err error
+		if start, err = f.value(lo); err != nil {
+			return 0, err
+		}
+		if end, err = f.value(hi); err != nil {
+			return 0, err
+		}
+	default:
+		var err error
+		if start, err = f.value(rangeExpr); err != nil {
+			return 0, err
+		}
+		end = start
+		// a/n 表示从 a 开始到最大值
+		if hasStep {
+			end = f.max
+		}
+	}
+	if start > end {
+		return 0, fmt.Errorf("%w: 范围的起点大于终点 %q", errs.ErrInvalidCron, part)
+	}
+	var res uint64
+	for i := start; i <= end; i += step {
+		res |= 1 << uint(i)
+	}
+	return res, nil
+}
+
+func (f cronField) value(expr string) (int, error) {
+	if v, ok := f.names[strings.ToLower(expr)]; ok {
+		return v, nil
+	}
+	v, err := strconv.Atoi(expr)
+	if err != nil || v < f.min || v > f.max {
+		return 0, fmt.Errorf("%w: %q 不在 %d-%d 之间", errs.ErrInvalidCron, expr, f.min, f.max)
+	}
+	return v, nil
+}
+
+// Next 返回 t 之后下一个满足表达式的时间，精确到分钟，返回的时间和 t 在同一个时区
+// 五年之内都找不到的时候返回零值，例如 "0 0 30 2 *"
+func (c *CronSchedule) Next(t time.Time) time.Time {
+	origLoc := t.Location()
+	t = t.In(c.loc)
+	// 从下一分钟开始找
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	yearLimit := t.Year() + 5
+
+	// 低位的字段溢出的时候，要重新检查高位的字段
+wrap:
+	if t.Year() > yearLimit {
+		return time.Time{}
+	}
+	for c.month&(1<<uint(t.Month())) == 0 {
+		t = dayStart(t.Year(), t.Month()+1, 1, c.loc)
+		if t.Month() == time.January {
+			goto wrap
+		}
+	}
+	for !c.dayMatches(t) {
+		month := t.Month()
+		t = dayStart(t.Year(), t.Month(), t.Day()+1, c.loc)
+		if t.Month() != month {
+			goto wrap
+		}
+	}
+	// 小时和分钟都是按照绝对时间往后推的，这样夏令时导致某个小时不存在或者重复的时候也不会死循环
+	for c.hour&(1<<uint(t.Hour())) == 0 {
+		day := t.Day()
+		t = t.Truncate(time.Minute).Add(time.Duration(60-t.Minute()) * time.Minute)
+		if t.Day() != day {
+			goto wrap
+		}
+	}
+	for c.minute&(1<<uint(t.Minute())) == 0 {
+		hour := t.Hour()
+		t = t.Add(time.Minute)
+		if t.Hour() != hour {
+			goto wrap
+		}
+	}
+	return t.In(origLoc)
+}
+
+// dayStart 返回 loc 时区中 y-m-d 这一天的第一个时刻，d 可以超出这个月的天数
+// 夏令时可能导致 0 点不存在，这时候 time.Date 有可能返回前一天的时间
+func dayStart(y int, m time.Month, d int, loc *time.Location) time.Time {
+	noon := time.Date(y, m, d, 12, 0, 0, 0, loc)
+	res := time.Date(noon.Year(), noon.Month(), noon.Day(), 0, 0, 0, 0, loc)
+	for res.Day() != noon.Day() {
+		res = res.Add(time.Hour)
+	}
+	return res
+}
+
+func (c *CronSchedule) dayMatches(t time.Time) bool {
+	domMatch := c.dom&(1<<uint(t.Day())) != 0
+	dowMatch := c.dow&(1<<uint(t.Weekday())) != 0
+	if c.domStar || c.dowStar {
+		return domMatch && dowMatch
+	}
+	return domMatch || dowMatch
+}
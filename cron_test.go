@@ -0,0 +1,162 @@
+package concurrent_queue
+
+import (
+	"concurrent_queue/errs"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestCronSchedule_Next(t *testing.T) {
+	t.Parallel()
+	shanghai, err := time.LoadLocation("Asia/Shanghai")
+	require.NoError(t, err)
+	newYork, err := time.LoadLocation("America/New_York")
+	require.NoError(t, err)
+	// 2023-01-01 是周日
+	start := time.Date(2023, 1, 1, 10, 30, 15, 0, time.UTC)
+	testCases := []struct {
+		name string
+		spec string
+		from time.Time
+		want time.Time
+	}{
+		{
+			name: "every minute",
+			spec: "* * * * *",
+			from: start,
+			want: time.Date(2023, 1, 1, 10, 31, 0, 0, time.UTC),
+		},
+		{
+			name: "step",
+			spec: "*/15 * * * *",
+			from: start,
+			want: time.Date(2023, 1, 1, 10, 45, 0, 0, time.UTC),
+		},
+		{
+			name: "next hour",
+			spec: "0,10 * * * *",
+			from: start,
+			want: time.Date(2023, 1, 1, 11, 0, 0, 0, time.UTC),
+		},
+		{
+			name: "range with step",
+			spec: "0 9-17/4 * * *",
+			from: start,
+			want: time.Date(2023, 1, 1, 13, 0, 0, 0, time.UTC),
+		},
+		{
+			name: "weekday names",
+			spec: "0 9 * * MON-FRI",
+			from: start,
+			want: time.Date(2023, 1, 2, 9, 0, 0, 0, time.UTC),
+		},
+		{
+			name: "sunday as 7",
+			spec: "0 9 * * 7",
+			from: time.Date(2023, 1, 2, 0, 0, 0, 0, time.UTC),
+			want: time.Date(2023, 1, 8, 9, 0, 0, 0, time.UTC),
+		},
+		{
+			name: "month names",
+			spec: "0 0 1 mar,jun *",
+			from: start,
+			want: time.Date(2023, 3, 1, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			// 日和周都有限制的时候，满足其中一个就可以
+			name: "dom or dow",
+			spec: "0 0 15 * FRI",
+			from: start,
+			want: time.Date(2023, 1, 6, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			// 周以 * 开头，那么日和周都要满足
+			name: "dom and dow star",
+			spec: "0 0 15 * */1",
+			from: start,
+			want: time.Date(2023, 1, 15, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name: "leap day",
+			spec: "0 0 29 2 *",
+			from: start,
+			want: time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name: "never",
+			spec: "0 0 30 2 *",
+			from: start,
+		},
+		{
+			name: "descriptor",
+			spec: "@monthly",
+			from: start,
+			want: time.Date(2023, 2, 1, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name: "year wrap",
+			spec: "@yearly",
+			from: time.Date(2023, 12, 31, 23, 59, 0, 0, time.UTC),
+			want: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			// 上海的 9 点是 UTC 的 1 点，返回的时间和传入的时间在同一个时区
+			name: "time zone",
+			spec: "CRON_TZ=Asia/Shanghai 0 9 * * *",
+			from: start,
+			want: time.Date(2023, 1, 2, 1, 0, 0, 0, time.UTC),
+		},
+		{
+			name: "time zone with TZ",
+			spec: "TZ=Asia/Shanghai 0 9 * * *",
+			from: time.Date(2023, 1, 1, 8, 0, 0, 0, shanghai),
+			want: time.Date(2023, 1, 1, 9, 0, 0, 0, shanghai),
+		},
+		{
+			// 纽约 2023-03-12 凌晨 2 点进入夏令时，2:30 不存在
+			name: "daylight saving gap",
+			spec: "CRON_TZ=America/New_York 30 2 * * *",
+			from: time.Date(2023, 3, 11, 3, 0, 0, 0, newYork),
+			want: time.Date(2023, 3, 13, 2, 30, 0, 0, newYork),
+		},
+	}
+	for _, tt := range testCases {
+		tc := tt
+		t.Run(tc.name, func(t *testing.T) {
+			c, err := ParseCronInLocation(tc.spec, time.UTC)
+			require.NoError(t, err)
+			got := c.Next(tc.from)
+			assert.True(t, tc.want.Equal(got), "want %v, got %v", tc.want, got)
+			if !got.IsZero() {
+				assert.Equal(t, tc.from.Location(), got.Location())
+			}
+		})
+	}
+}
+
+func TestParseCron(t *testing.T) {
+	t.Parallel()
+	testCases := []struct {
+		name string
+		spec string
+	}{
+		{name: "too few fields", spec: "* * * *"},
+		{name: "too many fields", spec: "* * * * * *"},
+		{name: "out of range", spec: "60 * * * *"},
+		{name: "dom zero", spec: "* * 0 * *"},
+		{name: "invalid name", spec: "* * * foo *"},
+		{name: "invalid step", spec: "*/0 * * * *"},
+		{name: "reversed range", spec: "* 10-5 * * *"},
+		{name: "unknown time zone", spec: "CRON_TZ=Mars/Olympus * * * * *"},
+		{name: "missing expression", spec: "CRON_TZ=UTC"},
+	}
+	for _, tt := range testCases {
+		tc := tt
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := ParseCron(tc.spec)
+			assert.ErrorIs(t, err, errs.ErrInvalidCron)
+		})
+	}
+}
@@ -9,47 +9,99 @@ import (
 )
 
 type DelayQueue[T Delayable] struct {
-	pq            *PriorityQueue[T]
+	pq            *PriorityQueue[*delayEntry[T]]
 	mutex         *sync.Mutex
 	DequeueSignal *cond
 	EnqueueSignal *cond
+	// 队列是否已经关闭
+	closed bool
+
+	stats *queueStats
+	clock Clock
+}
+
+// DelayHandle 入队之后返回的句柄，用于取消元素或者调整元素的延时时间
+type DelayHandle[T Delayable] struct {
+	entry *delayEntry[T]
+}
+
+// delayEntry 堆中的元素
+type delayEntry[T Delayable] struct {
+	val T
+	// index 在堆中的位置，不在堆中的时候为 -1
+	index int
+	// deadline 在入队的时候就确定下来，这样元素在堆中的顺序不会随着时间变化
+	deadline time.Time
 }
 
-func NewDelayQueue[T Delayable](capacity int) *DelayQueue[T] {
+// NewDelayQueue 创建延时队列
+// 元素实现了 Deadlined 的时候以 Deadline 为准，否则以入队时候的 Delay 为准
+// 支持的 Option：WithObserver, WithClock
+func NewDelayQueue[T Delayable](capacity int, opts ...Option[T]) *DelayQueue[T] {
 	m := &sync.Mutex{}
+	options := newQueueOptions(opts)
+	pq := NewPriorityQueue[*delayEntry[T]](capacity, func(src, dst *delayEntry[T]) int {
+		if src.deadline.After(dst.deadline) {
+			return 1
+		}
+		if src.deadline.Equal(dst.deadline) {
+			return 0
+		}
+		return -1
+	})
+	pq.setIndex = func(e *delayEntry[T], i int) {
+		e.index = i
+	}
 	return &DelayQueue[T]{
-		pq: NewPriorityQueue[T](capacity, func(src, dst T) int {
-			srcDelay := src.Delay()
-			dstDelay := dst.Delay()
-			if srcDelay > dstDelay {
-				return 1
-			}
-			if srcDelay == dstDelay {
-				return 0
-			}
-			return -1
-		}),
+		pq:            pq,
 		mutex:         m,
 		EnqueueSignal: newCond(m),
 		DequeueSignal: newCond(m),
+		stats:         newQueueStats(capacity, options.observer),
+		clock:         options.clock,
 	}
 }
 
+func (q *DelayQueue[T]) newEntry(data T) *delayEntry[T] {
+	entry := &delayEntry[T]{val: data, index: -1}
+	if d, ok := any(data).(Deadlined); ok {
+		entry.deadline = d.Deadline()
+	} else {
+		entry.deadline = q.clock.Now().Add(data.Delay())
+	}
+	return entry
+}
+
 func (q *DelayQueue[T]) Enqueue(ctx context.Context, data T) error {
+	_, err := q.EnqueueWithHandle(ctx, data)
+	return err
+}
+
+// EnqueueWithHandle 和 Enqueue 一样，但是会返回一个句柄，
+// 之后可以通过 Cancel 和 Reschedule 取消元素或者调整元素的延时时间
+func (q *DelayQueue[T]) EnqueueWithHandle(ctx context.Context, data T) (_ DelayHandle[T], err error) {
+	entry := q.newEntry(data)
+	w := q.stats.producerWaiter()
+	defer w.done(&err)
 	for {
 		select {
 		case <-ctx.Done():
-			return ctx.Err()
+			return DelayHandle[T]{}, ctx.Err()
 		default:
 		}
 		// 跑过来这边，逻辑就是
 		// 如果入队后的元素，过期时间更短，那么就要唤醒出队的
 		// 或者，一点都不管，就直接唤醒出队的
 		q.mutex.Lock()
+		if q.closed {
+			q.mutex.Unlock()
+			return DelayHandle[T]{}, errs.ErrQueueClosed
+		}
 
-		err := q.pq.Enqueue(data)
+		err = q.pq.enqueue(entry)
 		switch err {
 		case nil:
+			q.stats.enqueue(1)
 			// 入队成功
 			// 发送入队信号，唤醒出队阻塞的
 
@@ -59,25 +111,114 @@ func (q *DelayQueue[T]) Enqueue(ctx context.Context, data T) error {
 			//
 			// }
 			q.EnqueueSignal.broadcast()
-			return nil
+			return DelayHandle[T]{entry: entry}, nil
 		case errs.ErrOutOfCapacity:
+			w.wait()
 			signalCh := q.DequeueSignal.signalCh()
 			// 阻塞，开始睡觉了
 			select {
 			case <-ctx.Done():
-				return ctx.Err()
+				return DelayHandle[T]{}, ctx.Err()
 			case <-signalCh:
 			}
 		default:
 			q.mutex.Unlock()
-			return fmt.Errorf("延时队列入队的时候遇到未知错误 %w，请上报", err)
+			return DelayHandle[T]{}, fmt.Errorf("延时队列入队的时候遇到未知错误 %w，请上报", err)
 		}
 
 	}
 }
 
 func (q *DelayQueue[T]) Dequeue(ctx context.Context) (T, error) {
-	var timer *time.Timer
+	// 返回的时候持有锁
+	w := q.stats.consumerWaiter()
+	_, err := q.waitExpired(ctx, &w)
+	if err != nil {
+		var t T
+		return t, err
+	}
+	entry, err := q.pq.dequeue()
+	if err != nil {
+		var t T
+		q.mutex.Unlock()
+		return t, err
+	}
+	q.stats.dequeue(1, w.waited)
+	q.DequeueSignal.broadcast()
+	return entry.val, nil
+}
+
+// DequeueExpired 批量出队，最多返回 max 个元素
+// 会阻塞直到至少有一个元素到期，而后在一次加锁中把当下所有已经到期的元素一并取走
+func (q *DelayQueue[T]) DequeueExpired(ctx context.Context, max int) ([]T, error) {
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	if max <= 0 {
+		return nil, nil
+	}
+	w := q.stats.consumerWaiter()
+	// 返回的时候持有锁
+	if _, err := q.waitExpired(ctx, &w); err != nil {
+		return nil, err
+	}
+	now := q.clock.Now()
+	res := make([]T, 0, 1)
+	for len(res) < max {
+		entry, err := q.pq.Peek()
+		if err != nil || entry.deadline.After(now) {
+			break
+		}
+		_, _ = q.pq.dequeue()
+		res = append(res, entry.val)
+	}
+	q.stats.dequeue(len(res), w.waited)
+	q.DequeueSignal.broadcast()
+	return res, nil
+}
+
+// NextDeadline 返回堆顶元素的到期时间，队列为空的时候返回 false
+func (q *DelayQueue[T]) NextDeadline() (time.Time, bool) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	entry, err := q.pq.Peek()
+	if err != nil {
+		return time.Time{}, false
+	}
+	return entry.deadline, true
+}
+
+// Peek 返回堆顶元素，但是不会将其取出，也不会检查该元素是否到期
+// 队列为空的时候返回 errs.ErrEmptyQueue
+func (q *DelayQueue[T]) Peek() (T, error) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	entry, err := q.pq.Peek()
+	if err != nil {
+		var t T
+		return t, err
+	}
+	return entry.val, nil
+}
+
+// PeekWait 阻塞直到堆顶元素到期，返回该元素，但是不会将其取出
+// 超时的时候返回 ctx 的错误，队列关闭并且为空的时候返回 errs.ErrQueueClosed
+func (q *DelayQueue[T]) PeekWait(ctx context.Context) (T, error) {
+	w := q.stats.consumerWaiter()
+	val, err := q.waitExpired(ctx, &w)
+	if err != nil {
+		return val, err
+	}
+	q.mutex.Unlock()
+	return val, nil
+}
+
+// waitExpired 阻塞直到堆顶元素到期
+// 返回 nil 的时候依旧持有锁，由调用者负责解锁；返回 error 的时候已经释放了锁
+// 返回之后可以通过 w.waited 拿到阻塞的时间
+func (q *DelayQueue[T]) waitExpired(ctx context.Context, w *waiter) (_ T, err error) {
+	defer w.done(&err)
+	var timer Timer
 	defer func() {
 		if timer != nil {
 			timer.Stop()
@@ -100,24 +241,18 @@ func (q *DelayQueue[T]) Dequeue(ctx context.Context) (T, error) {
 			return t, ctx.Err()
 		default:
 		}
-		val, err := q.pq.Peek()
+		entry, err := q.pq.Peek()
 		switch err {
 		case nil:
-			delayTime := val.Delay()
+			delayTime := entry.deadline.Sub(q.clock.Now())
 			if delayTime <= 0 {
-				val, err = q.pq.Dequeue()
-				if err != nil {
-					var t T
-					q.mutex.Unlock()
-					return t, err
-				}
-				q.DequeueSignal.broadcast()
-				return val, nil
+				return entry.val, nil
 			}
+			w.wait()
 			// 要在这里解锁
 			signalCh := q.EnqueueSignal.signalCh()
 			if timer == nil {
-				timer = time.NewTimer(delayTime)
+				timer = q.clock.NewTimer(delayTime)
 			} else {
 				timer.Reset(delayTime)
 			}
@@ -125,7 +260,7 @@ func (q *DelayQueue[T]) Dequeue(ctx context.Context) (T, error) {
 			case <-ctx.Done():
 				var t T
 				return t, ctx.Err()
-			case <-timer.C:
+			case <-timer.C():
 				// 在这里不能这么写，因为这里已经无锁保护了
 				// c.mu.Lock()
 				// val, err = c.pq.Dequeue()
@@ -134,6 +269,13 @@ func (q *DelayQueue[T]) Dequeue(ctx context.Context) (T, error) {
 			case <-signalCh:
 			}
 		case errs.ErrEmptyQueue:
+			// 队列关闭了，并且元素已经被取完
+			if q.closed {
+				q.mutex.Unlock()
+				var t T
+				return t, errs.ErrQueueClosed
+			}
+			w.wait()
 			signalCh := q.EnqueueSignal.signalCh()
 			// 阻塞，开始 sleep
 			select {
@@ -150,6 +292,165 @@ func (q *DelayQueue[T]) Dequeue(ctx context.Context) (T, error) {
 	}
 }
 
+// TryEnqueue 尝试入队，不会阻塞
+// 队列已满或者已经关闭的时候返回 false
+func (q *DelayQueue[T]) TryEnqueue(data T) bool {
+	return q.tryEnqueue(data) == nil
+}
+
+func (q *DelayQueue[T]) tryEnqueue(data T) error {
+	q.mutex.Lock()
+	if q.closed {
+		q.mutex.Unlock()
+		return errs.ErrQueueClosed
+	}
+	if err := q.pq.enqueue(q.newEntry(data)); err != nil {
+		q.mutex.Unlock()
+		q.stats.full()
+		return err
+	}
+	q.stats.enqueue(1)
+	q.EnqueueSignal.broadcast()
+	return nil
+}
+
+// TryDequeue 尝试取出一个已经到期的元素，不会阻塞
+// 没有到期元素的时候返回 false
+func (q *DelayQueue[T]) TryDequeue() (T, bool) {
+	val, err := q.tryDequeue()
+	return val, err == nil
+}
+
+// tryDequeue 没有到期的元素时，返回 errs.ErrEmptyQueue
+func (q *DelayQueue[T]) tryDequeue() (T, error) {
+	q.mutex.Lock()
+	entry, err := q.pq.Peek()
+	if err != nil {
+		closed := q.closed
+		q.mutex.Unlock()
+		var t T
+		if closed {
+			return t, errs.ErrQueueClosed
+		}
+		q.stats.empty()
+		return t, err
+	}
+	if entry.deadline.After(q.clock.Now()) {
+		q.mutex.Unlock()
+		q.stats.empty()
+		var t T
+		return t, errs.ErrEmptyQueue
+	}
+	q.pq.dequeue()
+	q.stats.dequeue(1, 0)
+	q.DequeueSignal.broadcast()
+	return entry.val, nil
+}
+
+// Cancel 把句柄对应的元素从队列中删除，时间复杂度 O(log n)
+// 元素已经出队或者已经被取消的时候返回 false
+func (q *DelayQueue[T]) Cancel(h DelayHandle[T]) bool {
+	q.mutex.Lock()
+	i, ok := q.indexOf(h)
+	if !ok {
+		q.mutex.Unlock()
+		return false
+	}
+	q.pq.remove(i)
+	q.stats.remove(1)
+	// 删除的是堆顶，那么等待堆顶到期的出队者需要重新设置定时器
+	if i == 1 {
+		q.EnqueueSignal.broadcast()
+		q.mutex.Lock()
+	}
+	// 腾出了位置，唤醒阻塞的入队者
+	q.DequeueSignal.broadcast()
+	return true
+}
+
+// Reschedule 把句柄对应的元素的延时时间调整为 delay，也就是在 delay 之后到期，时间复杂度 O(log n)
+// 元素已经出队或者已经被取消的时候返回 errs.ErrInvalidHandle
+func (q *DelayQueue[T]) Reschedule(h DelayHandle[T], delay time.Duration) error {
+	q.mutex.Lock()
+	i, ok := q.indexOf(h)
+	if !ok {
+		q.mutex.Unlock()
+		return errs.ErrInvalidHandle
+	}
+	h.entry.deadline = q.clock.Now().Add(delay)
+	// 堆顶发生了变化，那么等待堆顶到期的出队者需要重新设置定时器
+	if j := q.pq.fix(i); i == 1 || j == 1 {
+		q.EnqueueSignal.broadcast()
+		return nil
+	}
+	q.mutex.Unlock()
+	return nil
+}
+
+// indexOf 返回句柄对应的元素在堆中的位置，必须持有锁
+func (q *DelayQueue[T]) indexOf(h DelayHandle[T]) (int, bool) {
+	e := h.entry
+	// 检查 data[e.index] 是为了防止用别的队列的句柄
+	if e == nil || e.index < 1 || e.index > q.pq.Len() || q.pq.data[e.index] != e {
+		return 0, false
+	}
+	return e.index, true
+}
+
+// Offer 在 timeout 内将元素放入队列
+// timeout <= 0 的时候不会阻塞，队列已满则返回 errs.ErrOutOfCapacity；
+// 否则会一直等待，超时返回 context.DeadlineExceeded
+func (q *DelayQueue[T]) Offer(data T, timeout time.Duration) error {
+	if timeout <= 0 {
+		return q.tryEnqueue(data)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return q.Enqueue(ctx, data)
+}
+
+// Poll 在 timeout 内获得一个到期的元素
+// timeout <= 0 的时候不会阻塞，没有到期的元素则返回 errs.ErrEmptyQueue；
+// 否则会一直等待，超时返回 context.DeadlineExceeded
+func (q *DelayQueue[T]) Poll(timeout time.Duration) (T, error) {
+	if timeout <= 0 {
+		return q.tryDequeue()
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return q.Dequeue(ctx)
+}
+
+// Len 队列中的元素个数，包括还没有到期的元素
+func (q *DelayQueue[T]) Len() int {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	return q.pq.Len()
+}
+
+// Stats 返回统计信息的快照，不需要加锁
+// 等待元素到期也计算在阻塞的出队者里面
+func (q *DelayQueue[T]) Stats() Stats {
+	return q.stats.snapshot()
+}
+
+// Close 关闭队列
+// 关闭之后入队会返回 errs.ErrQueueClosed。
+// 出队依旧会等到元素的延时时间到了才能取走，在取完剩余的元素之后返回 errs.ErrQueueClosed
+func (q *DelayQueue[T]) Close() error {
+	q.mutex.Lock()
+	if q.closed {
+		q.mutex.Unlock()
+		return nil
+	}
+	q.closed = true
+	// 唤醒所有阻塞的入队者和出队者，broadcast 会释放锁
+	q.DequeueSignal.broadcast()
+	q.mutex.Lock()
+	q.EnqueueSignal.broadcast()
+	return nil
+}
+
 type cond struct {
 	signal chan struct{}
 	l      sync.Locker
@@ -1,6 +1,7 @@
 package concurrent_queue
 
 import (
+	"concurrent_queue/errs"
 	"context"
 	"fmt"
 	"github.com/stretchr/testify/assert"
@@ -247,9 +248,8 @@ func TestDelayQueue_Enqueue(t *testing.T) {
 	})
 
 	// 入队相同过期时间的元素
-	// 但是因为我们在入队的时候是分别计算 Delay 的
-	// 那么就会导致虽然过期时间是相同的，但是因为调用 Delay 有先后之分
-	// 所以会造成 dstDelay 就是要比 srcDelay 小一点
+	// delayElem 实现了 Deadlined，过期时间相同的元素比较的结果是相等的，
+	// 所以出队的顺序只取决于堆的结构，不会因为调用 Delay 有先后之分而变化
 	t.Run("Enqueue with same deadline", func(t *testing.T) {
 		t.Parallel()
 		q := NewDelayQueue[delayElem](3)
@@ -277,6 +277,347 @@ func TestDelayQueue_Enqueue(t *testing.T) {
 	})
 }
 
+func TestDelayQueue_Close(t *testing.T) {
+	t.Parallel()
+	// 关闭之后，入队失败，剩余的元素到期之后依旧可以取出来
+	t.Run("drain after close", func(t *testing.T) {
+		q := newDelayQueue(t, delayElem{val: 123, deadline: time.Now().Add(time.Millisecond * 100)})
+		require.NoError(t, q.Close())
+		// 重复关闭
+		require.NoError(t, q.Close())
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		err := q.Enqueue(ctx, delayElem{val: 234, deadline: time.Now()})
+		assert.Equal(t, errs.ErrQueueClosed, err)
+
+		ele, err := q.Dequeue(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, 123, ele.val)
+		_, err = q.Dequeue(ctx)
+		assert.Equal(t, errs.ErrQueueClosed, err)
+	})
+
+	t.Run("wake up blocked producer", func(t *testing.T) {
+		q := newDelayQueue(t, delayElem{val: 123, deadline: time.Now().Add(time.Minute)})
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+		defer cancel()
+		go func() {
+			time.Sleep(time.Millisecond * 100)
+			_ = q.Close()
+		}()
+		err := q.Enqueue(ctx, delayElem{val: 234, deadline: time.Now()})
+		assert.Equal(t, errs.ErrQueueClosed, err)
+		assert.Nil(t, ctx.Err())
+	})
+
+	t.Run("wake up blocked consumer", func(t *testing.T) {
+		q := NewDelayQueue[delayElem](3)
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+		defer cancel()
+		go func() {
+			time.Sleep(time.Millisecond * 100)
+			_ = q.Close()
+		}()
+		_, err := q.Dequeue(ctx)
+		assert.Equal(t, errs.ErrQueueClosed, err)
+		assert.Nil(t, ctx.Err())
+	})
+}
+
+func TestDelayQueue_TryEnqueueAndTryDequeue(t *testing.T) {
+	t.Parallel()
+	q := NewDelayQueue[delayElem](2)
+	assert.True(t, q.TryEnqueue(delayElem{val: 123, deadline: time.Now()}))
+	assert.True(t, q.TryEnqueue(delayElem{val: 234, deadline: time.Now().Add(time.Millisecond * 200)}))
+	// 满了
+	assert.False(t, q.TryEnqueue(delayElem{val: 345, deadline: time.Now()}))
+	assert.Equal(t, errs.ErrOutOfCapacity, q.Offer(delayElem{val: 345}, 0))
+
+	ele, ok := q.TryDequeue()
+	assert.True(t, ok)
+	assert.Equal(t, 123, ele.val)
+	// 还没到期
+	_, ok = q.TryDequeue()
+	assert.False(t, ok)
+	_, err := q.Poll(0)
+	assert.Equal(t, errs.ErrEmptyQueue, err)
+	_, err = q.Poll(time.Millisecond * 10)
+	assert.Equal(t, context.DeadlineExceeded, err)
+
+	ele, err = q.Poll(time.Second)
+	require.NoError(t, err)
+	assert.Equal(t, 234, ele.val)
+
+	require.NoError(t, q.Close())
+	assert.False(t, q.TryEnqueue(delayElem{val: 456}))
+	_, err = q.Poll(0)
+	assert.Equal(t, errs.ErrQueueClosed, err)
+}
+
+func TestDelayQueue_Peek(t *testing.T) {
+	t.Parallel()
+	q := NewDelayQueue[delayElem](3)
+	_, err := q.Peek()
+	assert.Equal(t, errs.ErrEmptyQueue, err)
+
+	require.NoError(t, q.Enqueue(context.Background(), delayElem{val: 123, deadline: time.Now().Add(time.Millisecond * 200)}))
+	// Peek 不检查是否到期
+	ele, err := q.Peek()
+	require.NoError(t, err)
+	assert.Equal(t, 123, ele.val)
+
+	// 还没到期
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*50)
+	defer cancel()
+	_, err = q.PeekWait(ctx)
+	assert.Equal(t, context.DeadlineExceeded, err)
+
+	ctx, cancel = context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	ele, err = q.PeekWait(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 123, ele.val)
+	assert.True(t, ele.deadline.Before(time.Now()))
+
+	// 不会取走元素
+	ele, err = q.Dequeue(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 123, ele.val)
+}
+
+func TestDelayQueue_Stats(t *testing.T) {
+	t.Parallel()
+	q := NewDelayQueue[delayElem](2)
+	assert.Equal(t, Stats{Cap: 2}, q.Stats())
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	require.NoError(t, q.Enqueue(ctx, delayElem{val: 123, deadline: time.Now().Add(time.Millisecond * 100)}))
+	require.NoError(t, q.Enqueue(ctx, delayElem{val: 234, deadline: time.Now().Add(time.Second * 10)}))
+
+	// 等待元素到期也算阻塞
+	go func() {
+		_, _ = q.Dequeue(ctx)
+	}()
+	require.Eventually(t, func() bool {
+		return q.Stats().BlockedConsumers == 1
+	}, time.Second, time.Millisecond*10)
+	require.Eventually(t, func() bool {
+		return q.Stats().Dequeued == 1
+	}, time.Second, time.Millisecond*10)
+
+	stats := q.Stats()
+	assert.Equal(t, uint64(2), stats.Enqueued)
+	assert.Equal(t, 1, stats.Len)
+	// 没有到期的元素也算在里面
+	assert.Equal(t, 1, q.Len())
+	assert.Equal(t, 2, stats.HighWaterMark)
+	assert.Equal(t, 0, stats.BlockedConsumers)
+	assert.True(t, stats.WaitTime > 0)
+}
+
+func TestDelayQueue_Cancel(t *testing.T) {
+	t.Parallel()
+	q := NewDelayQueue[delayElem](2)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	now := time.Now()
+	h1, err := q.EnqueueWithHandle(ctx, delayElem{deadline: now.Add(time.Millisecond * 50), val: 1})
+	require.NoError(t, err)
+	h2, err := q.EnqueueWithHandle(ctx, delayElem{deadline: now.Add(time.Millisecond * 100), val: 2})
+	require.NoError(t, err)
+
+	// 队列满了，取消之后入队者会被唤醒
+	go func() {
+		time.Sleep(time.Millisecond * 10)
+		assert.True(t, q.Cancel(h2))
+	}()
+	_, err = q.EnqueueWithHandle(ctx, delayElem{deadline: now.Add(time.Millisecond * 200), val: 3})
+	require.NoError(t, err)
+	assert.False(t, q.Cancel(h2))
+	assert.Equal(t, 2, q.Stats().Len)
+
+	// 取消堆顶之后，出队者要重新等待新的堆顶
+	go func() {
+		time.Sleep(time.Millisecond * 10)
+		assert.True(t, q.Cancel(h1))
+	}()
+	val, err := q.Dequeue(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 3, val.val)
+	assert.True(t, time.Since(now) >= time.Millisecond*190)
+	assert.False(t, q.Cancel(h1))
+	assert.False(t, q.Cancel(DelayHandle[delayElem]{}))
+	// 别的队列的句柄
+	other := NewDelayQueue[delayElem](2)
+	h, err := other.EnqueueWithHandle(ctx, delayElem{deadline: now, val: 4})
+	require.NoError(t, err)
+	assert.False(t, q.Cancel(h))
+	assert.Equal(t, 0, q.Stats().Len)
+}
+
+func TestDelayQueue_Reschedule(t *testing.T) {
+	t.Parallel()
+	q := NewDelayQueue[delayElem](0)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	now := time.Now()
+	handles := make([]DelayHandle[delayElem], 0, 5)
+	for i := 0; i < 5; i++ {
+		h, err := q.EnqueueWithHandle(ctx, delayElem{deadline: now.Add(time.Second * time.Duration(i+1)), val: i})
+		require.NoError(t, err)
+		handles = append(handles, h)
+	}
+	// 出队者正在等待 1 秒之后到期的堆顶，调整之后需要被唤醒
+	go func() {
+		time.Sleep(time.Millisecond * 10)
+		assert.NoError(t, q.Reschedule(handles[3], time.Millisecond*30))
+	}()
+	val, err := q.Dequeue(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 3, val.val)
+	assert.True(t, time.Since(now) < time.Millisecond*500)
+	assert.Equal(t, errs.ErrInvalidHandle, q.Reschedule(handles[3], 0))
+
+	// 推迟堆顶
+	require.NoError(t, q.Reschedule(handles[0], time.Hour))
+	require.NoError(t, q.Reschedule(handles[4], 0))
+	require.NoError(t, q.Reschedule(handles[2], -time.Second))
+	var vals []int
+	for i := 0; i < 2; i++ {
+		val, err = q.Dequeue(ctx)
+		require.NoError(t, err)
+		vals = append(vals, val.val)
+	}
+	assert.Equal(t, []int{2, 4}, vals)
+	peek, err := q.Peek()
+	require.NoError(t, err)
+	assert.Equal(t, 1, peek.val)
+	assert.True(t, q.Cancel(handles[0]))
+	assert.True(t, q.Cancel(handles[1]))
+	assert.Equal(t, 0, q.Stats().Len)
+}
+
+func TestDelayQueue_ManualClock(t *testing.T) {
+	t.Parallel()
+	now := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock := NewManualClock(now)
+	q := NewDelayQueue[deadlineElem](0, WithClock[deadlineElem](clock))
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+	require.NoError(t, q.Enqueue(ctx, deadlineElem{deadline: now.Add(time.Hour * 2), val: 2}))
+	require.NoError(t, q.Enqueue(ctx, deadlineElem{deadline: now.Add(time.Hour), val: 1}))
+	require.NoError(t, q.Enqueue(ctx, deadlineElem{deadline: now, val: 0}))
+
+	_, ok := q.TryDequeue()
+	assert.True(t, ok)
+	_, ok = q.TryDequeue()
+	assert.False(t, ok)
+
+	res := make(chan int, 2)
+	go func() {
+		for i := 0; i < 2; i++ {
+			val, err := q.Dequeue(ctx)
+			if err != nil {
+				return
+			}
+			res <- val.val
+		}
+	}()
+	// 等待出队者开始等待堆顶到期
+	clock.BlockUntil(1)
+	clock.Advance(time.Minute * 59)
+	select {
+	case <-res:
+		t.Fatal("元素还没有到期")
+	case <-time.After(time.Millisecond * 10):
+	}
+	clock.Advance(time.Minute)
+	assert.Equal(t, 1, <-res)
+
+	clock.BlockUntil(1)
+	clock.Advance(time.Hour)
+	assert.Equal(t, 2, <-res)
+}
+
+func TestDelayQueue_DelaySnapshot(t *testing.T) {
+	t.Parallel()
+	q := NewDelayQueue[*counterElem](0)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	// Delay 每调用一次都会变化，入队之后的顺序只取决于入队时候的 Delay
+	a := &counterElem{delay: -time.Second, step: time.Hour}
+	b := &counterElem{delay: -time.Millisecond, step: -time.Hour}
+	require.NoError(t, q.Enqueue(ctx, a))
+	require.NoError(t, q.Enqueue(ctx, b))
+	val, err := q.Dequeue(ctx)
+	require.NoError(t, err)
+	assert.Same(t, a, val)
+	val, err = q.Dequeue(ctx)
+	require.NoError(t, err)
+	assert.Same(t, b, val)
+	assert.Equal(t, 1, a.calls)
+	assert.Equal(t, 1, b.calls)
+}
+
+func TestDelayQueue_DequeueExpired(t *testing.T) {
+	t.Parallel()
+	now := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock := NewManualClock(now)
+	q := NewDelayQueue[deadlineElem](0, WithClock[deadlineElem](clock))
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	_, ok := q.NextDeadline()
+	assert.False(t, ok)
+	for i := 5; i > 0; i-- {
+		require.NoError(t, q.Enqueue(ctx, deadlineElem{deadline: now.Add(time.Second * time.Duration(i/2)), val: i}))
+	}
+	deadline, ok := q.NextDeadline()
+	require.True(t, ok)
+	assert.Equal(t, now, deadline)
+
+	vals := func(elems []deadlineElem) []int {
+		res := make([]int, 0, len(elems))
+		for _, e := range elems {
+			res = append(res, e.val)
+		}
+		return res
+	}
+	// max <= 0
+	res, err := q.DequeueExpired(ctx, 0)
+	require.NoError(t, err)
+	assert.Nil(t, res)
+	// 只有一个元素到期了
+	res, err = q.DequeueExpired(ctx, 10)
+	require.NoError(t, err)
+	assert.Equal(t, []int{1}, vals(res))
+	deadline, ok = q.NextDeadline()
+	require.True(t, ok)
+	assert.Equal(t, now.Add(time.Second), deadline)
+
+	// 推进时间之后，一次最多取走 max 个到期的元素
+	clock.Set(now.Add(time.Second * 2))
+	res, err = q.DequeueExpired(ctx, 2)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []int{2, 3}, vals(res))
+	res, err = q.DequeueExpired(ctx, 3)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []int{4, 5}, vals(res))
+	assert.Equal(t, 0, q.Stats().Len)
+	assert.Equal(t, uint64(5), q.Stats().Dequeued)
+
+	timeoutCtx, timeoutCancel := context.WithTimeout(context.Background(), time.Millisecond*10)
+	defer timeoutCancel()
+	_, err = q.DequeueExpired(timeoutCtx, 3)
+	assert.Equal(t, context.DeadlineExceeded, err)
+
+	require.NoError(t, q.Close())
+	_, err = q.DequeueExpired(ctx, 3)
+	assert.Equal(t, errs.ErrQueueClosed, err)
+}
+
 func newDelayQueue(t *testing.T, eles ...delayElem) *DelayQueue[delayElem] {
 	q := NewDelayQueue[delayElem](len(eles))
 	for _, ele := range eles {
@@ -295,6 +636,10 @@ func (d delayElem) Delay() time.Duration {
 	return time.Until(d.deadline)
 }
 
+func (d delayElem) Deadline() time.Time {
+	return d.deadline
+}
+
 func ExampleNewDelayQueue() {
 	q := NewDelayQueue[delayElem](10)
 	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
@@ -334,3 +679,30 @@ func ExampleNewDelayQueue() {
 	// [1 2 3]
 	// delay!
 }
+
+type deadlineElem struct {
+	deadline time.Time
+	val      int
+}
+
+// Delay 实现了 Deadlined 之后不会被调用
+func (d deadlineElem) Delay() time.Duration {
+	panic("不应该调用 Delay")
+}
+
+func (d deadlineElem) Deadline() time.Time {
+	return d.deadline
+}
+
+type counterElem struct {
+	delay time.Duration
+	step  time.Duration
+	calls int
+}
+
+func (c *counterElem) Delay() time.Duration {
+	c.calls++
+	res := c.delay
+	c.delay += c.step
+	return res
+}
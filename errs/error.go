@@ -3,6 +3,20 @@ package errs
 import "errors"
 
 var (
-	ErrOutOfCapacity = errors.New("ekit: 超出最大容量限制")
-	ErrEmptyQueue    = errors.New("ekit: 队列为空")
+	ErrOutOfCapacity     = errors.New("ekit: 超出最大容量限制")
+	ErrEmptyQueue        = errors.New("ekit: 队列为空")
+	ErrQueueClosed       = errors.New("ekit: 队列已关闭")
+	ErrTooManyTaskDone   = errors.New("ekit: TaskDone 的调用次数超过了入队的元素个数")
+	ErrInvalidCapacity   = errors.New("ekit: 容量必须为正数")
+	ErrDuplicateQueue    = errors.New("ekit: 同名的队列已经注册过了")
+	ErrInvalidReceipt    = errors.New("ekit: 回执无效，元素可能已经被确认或者重新投递了")
+	ErrVisibilityTimeout = errors.New("ekit: 超过了 visibility timeout 依旧没有确认")
+	ErrRetryExhausted    = errors.New("ekit: 重试次数已经用完")
+	ErrInvalidHandle     = errors.New("ekit: 句柄无效，元素可能已经出队或者被取消了")
+	ErrInvalidCron       = errors.New("ekit: cron 表达式不合法")
+	ErrScheduleNotFound  = errors.New("ekit: 定时任务不存在，可能已经被取消了")
+	ErrTaskCancelled     = errors.New("ekit: 任务已经被取消")
+	ErrExecutorShutdown  = errors.New("ekit: 执行器已经关闭")
+	ErrTaskPanic         = errors.New("ekit: 任务执行的时候 panic 了")
+	ErrTaskRejected      = errors.New("ekit: 任务被拒绝，执行器的 worker 和队列都已经满了")
 )
@@ -0,0 +1,446 @@
+package concurrent_queue
+
+import (
+	"concurrent_queue/errs"
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// RejectionPolicy worker 个数已经达到上限并且队列也满了的时候，新任务的处理策略
+type RejectionPolicy int
+
+const (
+	// RejectAbort 返回 errs.ErrTaskRejected，这是默认的策略
+	RejectAbort RejectionPolicy = iota
+	// RejectCallerRuns 在调用者的 goroutine 中直接执行任务，可以起到限流的效果
+	RejectCallerRuns
+	// RejectDiscard 丢弃新任务，返回 nil
+	RejectDiscard
+	// RejectDiscardOldest 丢弃队首的任务，然后重新提交新任务
+	RejectDiscardOldest
+)
+
+// ExecutorOption 执行器的可选配置
+type ExecutorOption func(opts *executorOptions)
+
+type executorOptions struct {
+	keepAlive time.Duration
+	rejection RejectionPolicy
+	onPanic   func(r any)
+}
+
+// WithKeepAlive 设置超过核心个数的 worker 空闲多长时间之后退出，默认是一分钟
+func WithKeepAlive(keepAlive time.Duration) ExecutorOption {
+	return func(opts *executorOptions) {
+		if keepAlive > 0 {
+			opts.keepAlive = keepAlive
+		}
+	}
+}
+
+// WithRejectionPolicy 设置任务被拒绝时候的处理策略，默认是 RejectAbort
+func WithRejectionPolicy(policy RejectionPolicy) ExecutorOption {
+	return func(opts *executorOptions) {
+		opts.rejection = policy
+	}
+}
+
+// WithPanicHandler 设置通过 Execute 提交的任务 panic 时候的回调，默认忽略
+// 通过 Submit 提交的任务 panic 会被转化为 Future 的 error，不会调用这个回调
+func WithPanicHandler(fn func(r any)) ExecutorOption {
+	return func(opts *executorOptions) {
+		opts.onPanic = fn
+	}
+}
+
+type executorState int
+
+const (
+	executorRunning executorState = iota
+	// executorShutdown 不再接收新任务，但是会执行完队列中的任务
+	executorShutdown
+	// executorStop 不再接收新任务，也不再执行队列中的任务
+	executorStop
+)
+
+// Executor 协程池，类似于 Java 的 ThreadPoolExecutor
+// 提交任务的时候：
+//  1. worker 个数少于 core，创建新的 worker 执行任务；
+//  2. 否则放入队列，由空闲的 worker 取走；
+//  3. 队列满了，并且 worker 个数少于 max，创建新的 worker 执行任务；
+//  4. 否则按照 RejectionPolicy 处理。
+//
+// 超过 core 的 worker 空闲了 keepAlive 之后会退出。
+// 队列可以是任意的 BlockingQueue，例如 ArrayBlockingQueue、LinkedBlockingQueue。
+// 队列实现了 TryEnqueue 的时候，满了会按照上面的第 3、4 步处理；否则入队会一直阻塞直到有空位或者执行器被关闭。
+// 关闭执行器的时候，通过 TryDequeue 取出还没有开始执行的任务，这个包里面的阻塞队列都实现了；
+// 队列没有实现 TryDequeue 的时候，会用一个已经取消了的 ctx 调用 Dequeue，所以队列需要在这种情况下依旧返回已有的元素。
+// 队列只能由执行器使用，执行器不会关闭队列
+type Executor struct {
+	queue     BlockingQueue[func()]
+	core      int
+	max       int
+	keepAlive time.Duration
+	rejection RejectionPolicy
+	onPanic   func(r any)
+
+	// quit 用于唤醒阻塞在出队上的 worker
+	quit     context.Context
+	stopQuit context.CancelFunc
+	// taskCtx 通过 Submit 提交的任务的 ctx 的 parent，ShutdownNow 的时候会被取消
+	taskCtx   context.Context
+	stopTasks context.CancelFunc
+	wg        sync.WaitGroup
+
+	mutex   sync.Mutex
+	workers int
+	state   executorState
+
+	// resolveMutex 保证同一时刻只有一个 goroutine 在调用 job.ref，resolved 受它保护
+	resolveMutex sync.Mutex
+	resolved     *job
+}
+
+// job 提交给执行器的任务
+// 队列中放的是 ref，取出来之后通过 resolve 找回 job，
+// 这样被 RejectDiscardOldest 丢弃的任务也能通知到对应的 Future
+type job struct {
+	run func()
+	// discard 任务不会再执行的时候调用，err 是原因，可以为 nil
+	// 设置了 discard 的任务，关闭执行器的时候不会被返回给调用者
+	discard func(err error)
+	ref     func()
+}
+
+func (e *Executor) newJob(run func(), discard func(err error)) *job {
+	j := &job{run: run, discard: discard}
+	j.ref = func() {
+		e.resolved = j
+	}
+	return j
+}
+
+// reject 丢弃任务
+func (j *job) reject(err error) {
+	if j.discard != nil {
+		j.discard(err)
+	}
+}
+
+// NewExecutor 创建协程池，worker 是按需创建的
+// queue 只能由执行器使用，不要直接往里面放任务
+// core < 0 的时候按照 0 来处理；max < core 或者 max <= 0 的时候，max 等于 core 和 1 之中较大的那一个
+func NewExecutor(core, max int, queue BlockingQueue[func()], opts ...ExecutorOption) *Executor {
+	if core < 0 {
+		core = 0
+	}
+	if max < core {
+		max = core
+	}
+	if max <= 0 {
+		max = 1
+	}
+	options := &executorOptions{
+		keepAlive: time.Minute,
+	}
+	for _, opt := range opts {
+		opt(options)
+	}
+	e := &Executor{
+		queue:     queue,
+		core:      core,
+		max:       max,
+		keepAlive: options.keepAlive,
+		rejection: options.rejection,
+		onPanic:   options.onPanic,
+	}
+	e.quit, e.stopQuit = context.WithCancel(context.Background())
+	e.taskCtx, e.stopTasks = context.WithCancel(context.Background())
+	return e
+}
+
+// Execute 提交任务
+// 执行器已经关闭的时候返回 errs.ErrExecutorShutdown；任务被拒绝的时候按照 RejectionPolicy 处理
+func (e *Executor) Execute(task func()) error {
+	return e.execute(e.newJob(task, nil))
+}
+
+func (e *Executor) execute(j *job) error {
+	e.mutex.Lock()
+	if e.state != executorRunning {
+		e.mutex.Unlock()
+		return errs.ErrExecutorShutdown
+	}
+	if e.workers < e.core {
+		e.addWorker(j)
+		e.mutex.Unlock()
+		return nil
+	}
+	e.mutex.Unlock()
+
+	if e.offer(j.ref) {
+		e.mutex.Lock()
+		// core 为 0 的时候，需要保证至少有一个 worker 来执行队列中的任务
+		if e.workers == 0 && e.state == executorRunning {
+			e.addWorker(nil)
+		}
+		e.mutex.Unlock()
+		return nil
+	}
+
+	e.mutex.Lock()
+	if e.state == executorRunning && e.workers < e.max {
+		e.addWorker(j)
+		e.mutex.Unlock()
+		return nil
+	}
+	state := e.state
+	e.mutex.Unlock()
+	if state != executorRunning {
+		return errs.ErrExecutorShutdown
+	}
+	return e.reject(j)
+}
+
+// Submit 提交有返回值的任务
+// fn 拿到的 ctx 在 Future.Cancel 或者 ShutdownNow 的时候会被取消；fn panic 的时候 Future.Get 返回的 error 包装了 errs.ErrTaskPanic。
+// 被 RejectDiscard 或者 RejectDiscardOldest 丢弃的任务，Future.Get 返回 errs.ErrTaskRejected；
+// 关闭执行器的时候还没有开始执行的任务，Future.Get 返回 errs.ErrExecutorShutdown，它们也不会出现在 Shutdown 和 ShutdownNow 的返回值里面
+func Submit[R any](e *Executor, fn func(ctx context.Context) (R, error)) (*Future[R], error) {
+	f := newFuture[R]()
+	err := e.execute(e.newJob(func() {
+		ctx, cancel := context.WithCancel(e.taskCtx)
+		defer cancel()
+		if !f.start(cancel) {
+			return
+		}
+		val, err := call(ctx, fn)
+		f.complete(val, err)
+	}, func(err error) {
+		var zero R
+		f.complete(zero, err)
+	}))
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// addWorker 必须持有锁
+func (e *Executor) addWorker(first *job) {
+	e.workers++
+	e.wg.Add(1)
+	go e.work(first)
+}
+
+func (e *Executor) work(j *job) {
+	defer e.wg.Done()
+	for {
+		if j != nil {
+			e.run(j.run)
+		}
+		var ok bool
+		if j, ok = e.next(); !ok {
+			return
+		}
+	}
+}
+
+func (e *Executor) run(task func()) {
+	defer func() {
+		if r := recover(); r != nil && e.onPanic != nil {
+			e.onPanic(r)
+		}
+	}()
+	task()
+}
+
+// next 获取下一个任务，返回 false 的时候 worker 需要退出，并且已经从 workers 中减去了
+func (e *Executor) next() (*job, bool) {
+	for {
+		e.mutex.Lock()
+		state, timed := e.state, e.workers > e.core
+		if state == executorStop {
+			e.workers--
+			e.mutex.Unlock()
+			return nil, false
+		}
+		e.mutex.Unlock()
+
+		if state == executorShutdown {
+			if j, ok := e.poll(); ok {
+				return j, true
+			}
+			e.retire()
+			return nil, false
+		}
+
+		ctx, cancel := e.quit, context.CancelFunc(func() {})
+		if timed {
+			ctx, cancel = context.WithTimeout(e.quit, e.keepAlive)
+		}
+		task, err := e.queue.Dequeue(ctx)
+		cancel()
+		switch {
+		case err == nil:
+			if j := e.resolve(task); j != nil {
+				return j, true
+			}
+		case e.quit.Err() != nil:
+			// 执行器关闭了，重新检查状态
+		case errors.Is(err, context.DeadlineExceeded):
+			// 空闲超时，在持有锁的情况下再次检查，避免 worker 个数少于 core
+			e.mutex.Lock()
+			if e.workers > e.core {
+				e.workers--
+				e.mutex.Unlock()
+				return nil, false
+			}
+			e.mutex.Unlock()
+		default:
+			// 队列被关闭了
+			e.retire()
+			return nil, false
+		}
+	}
+}
+
+func (e *Executor) retire() {
+	e.mutex.Lock()
+	e.workers--
+	e.mutex.Unlock()
+}
+
+// offer 不阻塞地放入队列，队列满了返回 false
+// 队列没有实现 TryEnqueue 的时候会阻塞，直到放入队列或者执行器被关闭
+func (e *Executor) offer(task func()) bool {
+	if q, ok := e.queue.(interface{ TryEnqueue(func()) bool }); ok {
+		return q.TryEnqueue(task)
+	}
+	return e.queue.Enqueue(e.quit, task) == nil
+}
+
+// poll 不阻塞地从队列中取出任务，队列为空的时候返回 false
+func (e *Executor) poll() (*job, bool) {
+	for {
+		task, ok := e.tryDequeue()
+		if !ok {
+			return nil, false
+		}
+		if j := e.resolve(task); j != nil {
+			return j, true
+		}
+	}
+}
+
+// resolve 找回从队列中取出来的任务对应的 job
+// 队列中的 func 没办法在调用之前区分是不是 job.ref，所以队列只能由执行器使用：
+// 不是执行器放进去的任务，会在持有 resolveMutex 的情况下被执行，这时候返回 nil
+func (e *Executor) resolve(task func()) *job {
+	e.resolveMutex.Lock()
+	defer e.resolveMutex.Unlock()
+	task()
+	j := e.resolved
+	e.resolved = nil
+	return j
+}
+
+// tryDequeue 不阻塞地从队列中取出元素
+// 队列没有实现 TryDequeue 的时候，用一个已经取消了的 ctx 调用 Dequeue
+func (e *Executor) tryDequeue() (func(), bool) {
+	if q, ok := e.queue.(interface{ TryDequeue() (func(), bool) }); ok {
+		return q.TryDequeue()
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	task, err := e.queue.Dequeue(ctx)
+	return task, err == nil
+}
+
+func (e *Executor) reject(j *job) error {
+	switch e.rejection {
+	case RejectCallerRuns:
+		e.run(j.run)
+		return nil
+	case RejectDiscard:
+		j.reject(errs.ErrTaskRejected)
+		return nil
+	case RejectDiscardOldest:
+		if oldest, ok := e.poll(); ok {
+			oldest.reject(errs.ErrTaskRejected)
+			return e.execute(j)
+		}
+		return errs.ErrTaskRejected
+	default:
+		return errs.ErrTaskRejected
+	}
+}
+
+// Workers 当前 worker 的个数
+func (e *Executor) Workers() int {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	return e.workers
+}
+
+// Shutdown 关闭执行器，之后提交任务会返回 errs.ErrExecutorShutdown
+// 正在执行的任务和队列中剩余的任务会继续执行，Shutdown 会等待它们执行完毕，返回 nil 和 nil。
+// ctx 超时的时候，效果和 ShutdownNow 一样，返回还没有开始执行的任务和 ctx 的错误
+func (e *Executor) Shutdown(ctx context.Context) ([]func(), error) {
+	e.mutex.Lock()
+	if e.state == executorRunning {
+		e.state = executorShutdown
+	}
+	e.mutex.Unlock()
+	e.stopQuit()
+
+	if err := e.AwaitTermination(ctx); err != nil {
+		return e.ShutdownNow(), err
+	}
+	// worker 全部退出之后，可能还有并发的 Execute 放进去的任务
+	return e.drain(), nil
+}
+
+// ShutdownNow 立刻关闭执行器，不会等待正在执行的任务
+// 返回队列中还没有开始执行的、通过 Execute 提交的任务，通过 Submit 提交的任务拿到的 ctx 会被取消
+func (e *Executor) ShutdownNow() []func() {
+	e.mutex.Lock()
+	e.state = executorStop
+	e.mutex.Unlock()
+	e.stopQuit()
+	e.stopTasks()
+	return e.drain()
+}
+
+// AwaitTermination 等待所有的 worker 退出，通常在 Shutdown 或者 ShutdownNow 之后调用
+func (e *Executor) AwaitTermination(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		e.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// drain 取出队列中剩余的任务，通过 Submit 提交的任务直接结束掉 Future，不会返回
+func (e *Executor) drain() []func() {
+	var res []func()
+	for {
+		j, ok := e.poll()
+		if !ok {
+			return res
+		}
+		if j.discard != nil {
+			j.reject(errs.ErrExecutorShutdown)
+			continue
+		}
+		res = append(res, j.run)
+	}
+}
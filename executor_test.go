@@ -0,0 +1,379 @@
+package concurrent_queue
+
+import (
+	"concurrent_queue/errs"
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestExecutor_Rejection(t *testing.T) {
+	t.Parallel()
+	testCases := []struct {
+		name   string
+		policy RejectionPolicy
+		// 提交任务 3 的结果
+		wantErr error
+		// 任务 3 是不是在调用者的 goroutine 中执行了
+		wantCallerRuns bool
+		// 队列中剩下的任务个数
+		wantQueued int
+		// 所有的任务执行完毕之后，执行过的任务
+		wantExecuted []int
+	}{
+		{
+			name:         "abort",
+			policy:       RejectAbort,
+			wantErr:      errs.ErrTaskRejected,
+			wantQueued:   1,
+			wantExecuted: []int{0, 1, 2},
+		},
+		{
+			name:           "caller runs",
+			policy:         RejectCallerRuns,
+			wantCallerRuns: true,
+			wantQueued:     1,
+			wantExecuted:   []int{0, 1, 2, 3},
+		},
+		{
+			name:         "discard",
+			policy:       RejectDiscard,
+			wantQueued:   1,
+			wantExecuted: []int{0, 1, 2},
+		},
+		{
+			name:         "discard oldest",
+			policy:       RejectDiscardOldest,
+			wantQueued:   1,
+			wantExecuted: []int{0, 2, 3},
+		},
+	}
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			// 一个核心 worker，最多两个 worker，队列只能放一个任务
+			e := NewExecutor(1, 2, NewArrayBlockingQueue[func()](1), WithRejectionPolicy(tc.policy))
+			release := make(chan struct{})
+			var mutex sync.Mutex
+			var executed []int
+			record := func(i int) {
+				mutex.Lock()
+				executed = append(executed, i)
+				mutex.Unlock()
+			}
+			blocking := func(i int) func() {
+				return func() {
+					<-release
+					record(i)
+				}
+			}
+			// 0 由核心 worker 执行，1 放入队列，2 由新建的 worker 执行
+			require.NoError(t, e.Execute(blocking(0)))
+			require.NoError(t, e.Execute(blocking(1)))
+			require.NoError(t, e.Execute(blocking(2)))
+			assert.Equal(t, 2, e.Workers())
+
+			err := e.Execute(func() {
+				record(3)
+			})
+			assert.Equal(t, tc.wantErr, err)
+			mutex.Lock()
+			assert.Equal(t, tc.wantCallerRuns, len(executed) == 1)
+			mutex.Unlock()
+
+			// 正在执行的任务没有结束，只能拿到队列中的任务
+			remaining := e.ShutdownNow()
+			assert.Equal(t, tc.wantQueued, len(remaining))
+			close(release)
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+			defer cancel()
+			require.NoError(t, e.AwaitTermination(ctx))
+			for _, r := range remaining {
+				r()
+			}
+			mutex.Lock()
+			defer mutex.Unlock()
+			assert.ElementsMatch(t, tc.wantExecuted, executed)
+		})
+	}
+}
+
+func TestExecutor_SubmitDiscarded(t *testing.T) {
+	t.Parallel()
+	testCases := []struct {
+		name   string
+		policy RejectionPolicy
+		// 被丢弃的是先提交的还是后提交的任务
+		discardFirst bool
+	}{
+		{
+			name:   "discard",
+			policy: RejectDiscard,
+		},
+		{
+			name:         "discard oldest",
+			policy:       RejectDiscardOldest,
+			discardFirst: true,
+		},
+	}
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			e := NewExecutor(1, 1, NewArrayBlockingQueue[func()](1), WithRejectionPolicy(tc.policy))
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+			defer cancel()
+			release := make(chan struct{})
+			require.NoError(t, e.Execute(func() {
+				<-release
+			}))
+			// first 放入队列，second 会触发拒绝策略
+			first, err := Submit(e, func(ctx context.Context) (int, error) {
+				return 1, nil
+			})
+			require.NoError(t, err)
+			second, err := Submit(e, func(ctx context.Context) (int, error) {
+				return 2, nil
+			})
+			require.NoError(t, err)
+			close(release)
+
+			discarded, kept, want := second, first, 1
+			if tc.discardFirst {
+				discarded, kept, want = first, second, 2
+			}
+			// 被丢弃的任务，Future 也会结束
+			_, err = discarded.Get(ctx)
+			assert.Equal(t, errs.ErrTaskRejected, err)
+			val, err := kept.Get(ctx)
+			require.NoError(t, err)
+			assert.Equal(t, want, val)
+			_, err = e.Shutdown(ctx)
+			require.NoError(t, err)
+		})
+	}
+}
+
+func TestExecutor_KeepAlive(t *testing.T) {
+	t.Parallel()
+	e := NewExecutor(1, 3, NewLinkedBlockingQueue[func()](1), WithKeepAlive(time.Millisecond*50))
+	release := make(chan struct{})
+	for i := 0; i < 4; i++ {
+		require.NoError(t, e.Execute(func() {
+			<-release
+		}))
+	}
+	assert.Equal(t, 3, e.Workers())
+	close(release)
+	// 超过核心个数的 worker 空闲之后会退出
+	assert.Eventually(t, func() bool {
+		return e.Workers() == 1
+	}, time.Second*5, time.Millisecond*10)
+
+	// 剩下的核心 worker 依旧可以执行任务
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+	f, err := Submit(e, func(ctx context.Context) (int, error) {
+		return 1, nil
+	})
+	require.NoError(t, err)
+	val, err := f.Get(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, val)
+	remaining, err := e.Shutdown(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, remaining)
+	assert.Equal(t, 0, e.Workers())
+}
+
+func TestExecutor_Submit(t *testing.T) {
+	t.Parallel()
+	var panics int32
+	e := NewExecutor(0, 1, NewArrayBlockingQueue[func()](10), WithPanicHandler(func(r any) {
+		atomic.AddInt32(&panics, 1)
+	}))
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	// core 为 0 的时候依旧会有 worker 执行队列中的任务
+	f, err := Submit(e, func(ctx context.Context) (string, error) {
+		return "a", nil
+	})
+	require.NoError(t, err)
+	val, err := f.Get(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, "a", val)
+
+	f, err = Submit(e, func(ctx context.Context) (string, error) {
+		panic("mock panic")
+	})
+	require.NoError(t, err)
+	_, err = f.Get(ctx)
+	assert.True(t, errors.Is(err, errs.ErrTaskPanic))
+
+	require.NoError(t, e.Execute(func() {
+		panic("mock panic")
+	}))
+	f, err = Submit(e, func(ctx context.Context) (string, error) {
+		return "b", nil
+	})
+	require.NoError(t, err)
+	val, err = f.Get(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, "b", val)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&panics))
+
+	_, err = e.Shutdown(ctx)
+	require.NoError(t, err)
+	_, err = Submit(e, func(ctx context.Context) (string, error) {
+		return "c", nil
+	})
+	assert.Equal(t, errs.ErrExecutorShutdown, err)
+}
+
+func TestExecutor_Shutdown(t *testing.T) {
+	t.Parallel()
+	e := NewExecutor(1, 1, NewLinkedBlockingQueue[func()](0))
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	running, err := Submit(e, func(ctx context.Context) (int, error) {
+		close(started)
+		<-release
+		return 1, nil
+	})
+	require.NoError(t, err)
+	<-started
+	var executed int32
+	for i := 0; i < 3; i++ {
+		require.NoError(t, e.Execute(func() {
+			atomic.AddInt32(&executed, 1)
+		}))
+	}
+
+	// 队列中剩余的任务会继续执行
+	go func() {
+		time.Sleep(time.Millisecond * 50)
+		close(release)
+	}()
+	remaining, err := e.Shutdown(ctx)
+	require.NoError(t, err)
+	assert.Empty(t, remaining)
+	assert.Equal(t, int32(3), atomic.LoadInt32(&executed))
+	val, err := running.Get(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, val)
+	assert.Equal(t, errs.ErrExecutorShutdown, e.Execute(func() {}))
+}
+
+func TestExecutor_ShutdownTimeout(t *testing.T) {
+	t.Parallel()
+	e := NewExecutor(1, 1, NewLinkedBlockingQueue[func()](0))
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	running, err := Submit(e, func(ctx context.Context) (int, error) {
+		// 只有 ShutdownNow 才能让任务结束
+		<-ctx.Done()
+		return 0, ctx.Err()
+	})
+	require.NoError(t, err)
+	pending, err := Submit(e, func(ctx context.Context) (int, error) {
+		return 2, nil
+	})
+	require.NoError(t, err)
+
+	var executed int32
+	require.NoError(t, e.Execute(func() {
+		atomic.AddInt32(&executed, 1)
+	}))
+
+	shutdownCtx, shutdownCancel := context.WithTimeout(ctx, time.Millisecond*50)
+	defer shutdownCancel()
+	remaining, err := e.Shutdown(shutdownCtx)
+	assert.Equal(t, context.DeadlineExceeded, err)
+	require.Len(t, remaining, 1)
+	_, err = running.Get(ctx)
+	assert.Equal(t, context.Canceled, err)
+	require.NoError(t, e.AwaitTermination(ctx))
+
+	// 通过 Submit 提交的任务，Future 直接结束
+	_, err = pending.Get(ctx)
+	assert.Equal(t, errs.ErrExecutorShutdown, err)
+	// 通过 Execute 提交的任务可以由调用者自己执行
+	remaining[0]()
+	assert.Equal(t, int32(1), atomic.LoadInt32(&executed))
+}
+
+// chanQueue 只实现了 BlockingQueue，没有 TryEnqueue 和 TryDequeue
+// ctx 已经被取消的时候，Dequeue 依旧会返回已有的元素
+type chanQueue struct {
+	ch chan func()
+}
+
+func (q chanQueue) Enqueue(ctx context.Context, t func()) error {
+	select {
+	case q.ch <- t:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (q chanQueue) Dequeue(ctx context.Context) (func(), error) {
+	select {
+	case t := <-q.ch:
+		return t, nil
+	default:
+	}
+	select {
+	case t := <-q.ch:
+		return t, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func TestExecutor_QueueWithoutTry(t *testing.T) {
+	t.Parallel()
+	e := NewExecutor(1, 1, chanQueue{ch: make(chan func(), 1)})
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	require.NoError(t, e.Execute(func() {
+		close(started)
+		<-release
+	}))
+	<-started
+	var executed int32
+	require.NoError(t, e.Execute(func() {
+		atomic.AddInt32(&executed, 1)
+	}))
+	// 队列满了，入队会一直阻塞，直到执行器被关闭
+	blocked := make(chan error, 1)
+	go func() {
+		blocked <- e.Execute(func() {
+			atomic.AddInt32(&executed, 1)
+		})
+	}()
+	time.Sleep(time.Millisecond * 50)
+
+	// 没有 TryDequeue 也能拿到还没有开始执行的任务
+	remaining := e.ShutdownNow()
+	assert.Equal(t, errs.ErrExecutorShutdown, <-blocked)
+	require.Len(t, remaining, 1)
+	close(release)
+	require.NoError(t, e.AwaitTermination(ctx))
+	remaining[0]()
+	assert.Equal(t, int32(1), atomic.LoadInt32(&executed))
+}
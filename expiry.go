@@ -0,0 +1,75 @@
+package concurrent_queue
+
+import (
+	"reflect"
+	"time"
+)
+
+var expirableType = reflect.TypeOf((*Expirable)(nil)).Elem()
+
+// expiry 判断元素是否过期，并且负责调用过期回调
+// 没有设置 TTL 并且 T 也没有实现 Expirable 的时候为 nil，出队的时候就只需要一次判空
+type expiry[T any] struct {
+	ttl time.Duration
+	// T 实现了 Expirable
+	expirable bool
+	onExpire  func(T)
+}
+
+func newExpiry[T any](opts *queueOptions[T]) *expiry[T] {
+	expirable := reflect.TypeOf((*T)(nil)).Elem().Implements(expirableType)
+	if opts.ttl <= 0 && !expirable {
+		return nil
+	}
+	return &expiry[T]{
+		ttl:       opts.ttl,
+		expirable: expirable,
+		onExpire:  opts.onExpire,
+	}
+}
+
+// expired 判断元素是否过期，meta 用于判断 TTL
+func (e *expiry[T]) expired(t T, meta Meta) bool {
+	if e == nil {
+		return false
+	}
+	if e.ttl > 0 && !meta.EnqueuedAt.IsZero() && meta.Sojourn >= e.ttl {
+		return true
+	}
+	if e.expirable {
+		// T 有可能是接口，所以依旧要判断
+		if ex, ok := any(t).(Expirable); ok && ex.Expiry() <= 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// expire 调用过期回调，不能在锁范围内调用
+func (e *expiry[T]) expire(ts ...T) {
+	if e == nil || e.onExpire == nil {
+		return
+	}
+	for _, t := range ts {
+		e.onExpire(t)
+	}
+}
+
+// startSweep 每隔 interval 调用一次 purge，直到 stop 被关闭
+func startSweep(interval time.Duration, stop <-chan struct{}, purge func() int) {
+	if interval <= 0 {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				purge()
+			}
+		}
+	}()
+}
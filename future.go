@@ -0,0 +1,103 @@
+package concurrent_queue
+
+import (
+	"concurrent_queue/errs"
+	"context"
+	"sync"
+)
+
+// Future 异步任务的结果
+type Future[R any] struct {
+	mutex sync.Mutex
+	state futureState
+	done  chan struct{}
+	val   R
+	err   error
+	// stop 取消正在执行的任务的 ctx
+	stop context.CancelFunc
+	// onCancel 任务还没有开始执行就被取消的时候调用，用于把任务从队列中删除
+	onCancel func()
+}
+
+type futureState int
+
+const (
+	futurePending futureState = iota
+	futureRunning
+	futureDone
+)
+
+func newFuture[R any]() *Future[R] {
+	return &Future[R]{
+		done: make(chan struct{}),
+	}
+}
+
+// Get 阻塞直到任务执行完毕，返回任务的结果
+// 任务被取消的时候返回 errs.ErrTaskCancelled，超时的时候返回 ctx 的错误
+func (f *Future[R]) Get(ctx context.Context) (R, error) {
+	select {
+	case <-f.done:
+		return f.val, f.err
+	case <-ctx.Done():
+		var r R
+		return r, ctx.Err()
+	}
+}
+
+// Done 任务执行完毕或者被取消之后会被关闭
+func (f *Future[R]) Done() <-chan struct{} {
+	return f.done
+}
+
+// Cancel 取消任务，任务已经执行完毕的时候返回 false
+// 还没有开始执行的任务不会再执行；正在执行的任务，传给它的 ctx 会被取消，它的结果会被丢弃。
+// 两种情况下 Get 都会立刻返回 errs.ErrTaskCancelled
+func (f *Future[R]) Cancel() bool {
+	f.mutex.Lock()
+	state := f.state
+	if state == futureDone {
+		f.mutex.Unlock()
+		return false
+	}
+	f.finish(*new(R), errs.ErrTaskCancelled)
+	stop, onCancel := f.stop, f.onCancel
+	f.mutex.Unlock()
+	if state == futureRunning && stop != nil {
+		stop()
+	}
+	if state == futurePending && onCancel != nil {
+		onCancel()
+	}
+	return true
+}
+
+// start 开始执行任务，任务已经被取消的时候返回 false
+func (f *Future[R]) start(stop context.CancelFunc) bool {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.state != futurePending {
+		return false
+	}
+	f.state = futureRunning
+	f.stop = stop
+	return true
+}
+
+// complete 设置任务的结果，任务已经被取消的时候返回 false
+func (f *Future[R]) complete(val R, err error) bool {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.state == futureDone {
+		return false
+	}
+	f.finish(val, err)
+	return true
+}
+
+// finish 必须持有锁
+func (f *Future[R]) finish(val R, err error) {
+	f.state = futureDone
+	f.val, f.err = val, err
+	close(f.done)
+}
@@ -0,0 +1,102 @@
+package concurrent_queue
+
+import (
+	"concurrent_queue/errs"
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestFuture(t *testing.T) {
+	t.Parallel()
+	testCases := []struct {
+		name string
+		// 对一个新的 Future 做一些操作
+		act     func(f *Future[int])
+		wantVal int
+		wantErr error
+	}{
+		{
+			name: "complete",
+			act: func(f *Future[int]) {
+				f.start(nil)
+				f.complete(1, nil)
+			},
+			wantVal: 1,
+		},
+		{
+			name: "complete with error",
+			act: func(f *Future[int]) {
+				f.start(nil)
+				f.complete(0, errors.New("mock error"))
+			},
+			wantErr: errors.New("mock error"),
+		},
+		{
+			name: "cancel pending",
+			act: func(f *Future[int]) {
+				assert.True(t, f.Cancel())
+				// 被取消之后不会再执行
+				assert.False(t, f.start(nil))
+			},
+			wantErr: errs.ErrTaskCancelled,
+		},
+		{
+			name: "cancel running",
+			act: func(f *Future[int]) {
+				ctx, cancel := context.WithCancel(context.Background())
+				f.start(cancel)
+				assert.True(t, f.Cancel())
+				assert.Equal(t, context.Canceled, ctx.Err())
+				// 结果会被丢弃
+				assert.False(t, f.complete(1, nil))
+			},
+			wantErr: errs.ErrTaskCancelled,
+		},
+		{
+			name: "cancel done",
+			act: func(f *Future[int]) {
+				f.start(nil)
+				f.complete(1, nil)
+				assert.False(t, f.Cancel())
+			},
+			wantVal: 1,
+		},
+	}
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			f := newFuture[int]()
+			tc.act(f)
+			select {
+			case <-f.Done():
+			default:
+				t.Fatal("Future 应该已经结束了")
+			}
+			val, err := f.Get(context.Background())
+			assert.Equal(t, tc.wantVal, val)
+			assert.Equal(t, tc.wantErr, err)
+		})
+	}
+}
+
+func TestFuture_GetTimeout(t *testing.T) {
+	t.Parallel()
+	f := newFuture[int]()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*10)
+	defer cancel()
+	_, err := f.Get(ctx)
+	assert.Equal(t, context.DeadlineExceeded, err)
+
+	// 取消的时候会调用 onCancel
+	called := false
+	f.onCancel = func() {
+		called = true
+	}
+	assert.True(t, f.Cancel())
+	assert.True(t, called)
+	assert.False(t, f.Cancel())
+}
@@ -3,6 +3,7 @@ module concurrent_queue
 go 1.18
 
 require (
+	github.com/ecodeclub/ekit v0.0.7
 	github.com/google/uuid v1.3.0
 	github.com/stretchr/testify v1.8.2
 	golang.org/x/sync v0.1.0
@@ -10,7 +11,6 @@ require (
 
 require (
 	github.com/davecgh/go-spew v1.1.1 // indirect
-	github.com/ecodeclub/ekit v0.0.7 // indirect
 	github.com/pmezard/go-difflib v1.0.0 // indirect
 	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
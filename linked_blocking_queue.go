@@ -1,9 +1,11 @@
 package concurrent_queue
 
 import (
+	"concurrent_queue/errs"
 	"context"
 	"github.com/ecodeclub/ekit/list"
 	"sync"
+	"time"
 )
 
 type LinkedBlockingQueue[T any] struct {
@@ -16,17 +18,55 @@ type LinkedBlockingQueue[T any] struct {
 
 	notEmpty *cond
 	notFull  *cond
+
+	// 队列是否已经关闭
+	closed bool
+
+	// 未完成的任务，用于支持 TaskDone 和 Join
+	tasks *taskCounter
+
+	// 队列满了之后的处理策略，只对有界队列生效
+	overflow OverflowPolicy
+	// 元素被丢弃时候的回调，可以为 nil
+	onDrop func(T)
+
+	stats *queueStats
+
+	// 开启了 WithSojournTracking 的时候记录每个元素的入队时间，和 linkedlist 一一对应，否则为 nil
+	enqueuedAt *list.LinkedList[time.Time]
+	// 没有设置 TTL 并且元素也没有实现 Expirable 的时候为 nil
+	expiry *expiry[T]
+	// 关闭的时候会 close 掉，用于通知后台清理过期元素的 goroutine 退出
+	closeCh chan struct{}
 }
 
-func NewLinkedBlockingQueue[T any](capacity int) *LinkedBlockingQueue[T] {
+// NewLinkedBlockingQueue 创建一个链表阻塞队列
+// capacity <= 0 时，为无界队列
+// 支持的 Option：WithOverflowPolicy, WithDropCallback, WithObserver, WithSojournTracking,
+// WithTTL, WithExpireCallback, WithExpirySweep
+func NewLinkedBlockingQueue[T any](capacity int, opts ...Option[T]) *LinkedBlockingQueue[T] {
 	mutex := &sync.RWMutex{}
-	return &LinkedBlockingQueue[T]{
+	options := newQueueOptions(opts)
+	res := &LinkedBlockingQueue[T]{
 		mutex:      mutex,
 		maxSize:    capacity,
 		notEmpty:   newCond(mutex),
 		notFull:    newCond(mutex),
 		linkedlist: list.NewLinkedList[T](),
+		tasks:      newTaskCounter(),
+		overflow:   options.overflow,
+		onDrop:     options.onDrop,
+		stats:      newQueueStats(capacity, options.observer),
+		expiry:     newExpiry(options),
+		closeCh:    make(chan struct{}),
+	}
+	if options.sojourn {
+		res.enqueuedAt = list.NewLinkedList[time.Time]()
+	}
+	if res.expiry != nil {
+		startSweep(options.sweep, res.closeCh, res.Purge)
 	}
+	return res
 }
 
 // Enqueue 入队
@@ -36,7 +76,68 @@ func (q *LinkedBlockingQueue[T]) Enqueue(ctx context.Context, data T) error {
 		return ctx.Err()
 	}
 	q.mutex.Lock()
-	for q.maxSize > 0 && q.isFull() {
+	if !q.closed && q.isFull() && q.overflow != OverflowBlock {
+		q.stats.full()
+		// 这里会释放锁
+		return q.overflowLocked(data)
+	}
+	w := q.stats.producerWaiter()
+	if err := q.waitNotFull(ctx, &w); err != nil {
+		return err
+	}
+	if q.closed {
+		q.mutex.Unlock()
+		return errs.ErrQueueClosed
+	}
+	err := q.append(data)
+
+	// 这里会释放锁
+	q.notEmpty.broadcast()
+	return err
+}
+
+// overflowLocked 队列已满的时候按照 overflow 策略处理
+// 必须在锁范围内调用，返回的时候已经释放了锁
+func (q *LinkedBlockingQueue[T]) overflowLocked(data T) error {
+	switch q.overflow {
+	case OverflowDropNewest:
+		q.mutex.Unlock()
+		q.stats.drop(1)
+		q.drop(data)
+		return nil
+	case OverflowDropOldest:
+		old, err := q.deleteHead()
+		if err != nil {
+			q.mutex.Unlock()
+			return err
+		}
+		q.stats.evict(1)
+		// 被丢弃的元素永远不会有人调用 TaskDone
+		_ = q.tasks.taskDone()
+		err = q.append(data)
+		// 这里会释放锁
+		q.notEmpty.broadcast()
+		q.drop(old)
+		return err
+	default:
+		q.mutex.Unlock()
+		return errs.ErrOutOfCapacity
+	}
+}
+
+// drop 调用丢弃元素的回调，不能在锁范围内调用
+func (q *LinkedBlockingQueue[T]) drop(data T) {
+	if q.onDrop != nil {
+		q.onDrop(data)
+	}
+}
+
+// waitNotFull 阻塞直到队列不满或者队列已经关闭
+// 必须在锁范围内调用。返回 nil 的时候依旧持有锁，返回 error 的时候已经释放了锁
+func (q *LinkedBlockingQueue[T]) waitNotFull(ctx context.Context, w *waiter) (err error) {
+	defer w.done(&err)
+	for !q.closed && q.isFull() {
+		w.wait()
 		signal := q.notFull.signalCh()
 		select {
 		case <-ctx.Done():
@@ -45,36 +146,484 @@ func (q *LinkedBlockingQueue[T]) Enqueue(ctx context.Context, data T) error {
 			q.mutex.Lock()
 		}
 	}
-	err := q.linkedlist.Append(data)
+	return nil
+}
 
-	// 这里会释放锁
-	q.notEmpty.broadcast()
-	return err
+// waitNotEmpty 阻塞直到队列不为空，队列关闭并且为空的时候返回 errs.ErrQueueClosed
+// 必须在锁范围内调用。返回 nil 的时候依旧持有锁，返回 error 的时候已经释放了锁
+// 返回之后可以通过 w.waited 拿到阻塞的时间
+func (q *LinkedBlockingQueue[T]) waitNotEmpty(ctx context.Context, w *waiter) (err error) {
+	defer w.done(&err)
+	for q.isEmpty() {
+		// 队列关闭了，并且元素已经被取完
+		if q.closed {
+			q.mutex.Unlock()
+			return errs.ErrQueueClosed
+		}
+		w.wait()
+		signal := q.notEmpty.signalCh()
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-signal:
+			q.mutex.Lock()
+		}
+	}
+	return nil
 }
 
 // Dequeue 出队
 // 注意：目前我们已经通过broadcast实现了超时控制
 func (q *LinkedBlockingQueue[T]) Dequeue(ctx context.Context) (T, error) {
+	val, _, err := q.DequeueWithMeta(ctx)
+	return val, err
+}
+
+// DequeueWithMeta 和 Dequeue 一样，但是会额外返回元素的 Meta
+// 没有开启 WithSojournTracking 的时候 Meta 为零值
+func (q *LinkedBlockingQueue[T]) DequeueWithMeta(ctx context.Context) (T, Meta, error) {
+	if ctx.Err() != nil {
+		var val T
+		return val, Meta{}, ctx.Err()
+	}
+	q.mutex.Lock()
+	w := q.stats.consumerWaiter()
+	if err := q.waitLive(ctx, &w); err != nil {
+		var val T
+		return val, Meta{}, err
+	}
+	meta := q.headMeta()
+	val, err := q.deleteHead()
+	if err == nil {
+		q.stats.dequeue(1, w.waited)
+	}
+	// 这里会释放锁
+	q.notFull.broadcast()
+
+	return val, meta, err
+}
+
+// OldestAge 返回队首元素在队列中已经停留的时间
+// 队列为空或者没有开启 WithSojournTracking 的时候返回 0
+func (q *LinkedBlockingQueue[T]) OldestAge() time.Duration {
+	q.mutex.RLock()
+	defer q.mutex.RUnlock()
+	return q.headMeta().Sojourn
+}
+
+// headMeta 返回队首元素的 Meta，必须在锁范围内调用
+func (q *LinkedBlockingQueue[T]) headMeta() Meta {
+	if q.enqueuedAt == nil {
+		return Meta{}
+	}
+	enqueuedAt, err := q.enqueuedAt.Get(0)
+	if err != nil {
+		return Meta{}
+	}
+	return newMeta(enqueuedAt)
+}
+
+// deleteHead 删除队首元素，必须在锁范围内调用
+func (q *LinkedBlockingQueue[T]) deleteHead() (T, error) {
+	val, err := q.linkedlist.Delete(0)
+	if err == nil && q.enqueuedAt != nil {
+		_, _ = q.enqueuedAt.Delete(0)
+	}
+	return val, err
+}
+
+// Peek 返回队首元素，但是不会将其取出
+// 和 Dequeue 一样，过期的队首元素会被移除，
+// 队列为空或者只剩下过期元素的时候返回 errs.ErrEmptyQueue
+func (q *LinkedBlockingQueue[T]) Peek() (T, error) {
+	if q.expiry == nil {
+		q.mutex.RLock()
+		defer q.mutex.RUnlock()
+		return q.peek()
+	}
+	q.mutex.Lock()
+	expired := q.removeExpiredHead()
+	val, err := q.peek()
+	if len(expired) == 0 {
+		q.mutex.Unlock()
+		return val, err
+	}
+	// 过期的元素释放了容量，这里会释放锁
+	q.notFull.broadcast()
+	q.expiry.expire(expired...)
+	return val, err
+}
+
+// peek 必须在锁范围内调用
+func (q *LinkedBlockingQueue[T]) peek() (T, error) {
+	if q.isEmpty() {
+		var val T
+		return val, errs.ErrEmptyQueue
+	}
+	return q.linkedlist.Get(0)
+}
+
+// PeekWait 阻塞直到队列中有元素，返回队首元素，但是不会将其取出
+// 超时的时候返回 ctx 的错误，队列关闭并且为空的时候返回 errs.ErrQueueClosed
+func (q *LinkedBlockingQueue[T]) PeekWait(ctx context.Context) (T, error) {
 	if ctx.Err() != nil {
 		var val T
 		return val, ctx.Err()
 	}
 	q.mutex.Lock()
-	for q.isEmpty() {
-		signal := q.notEmpty.signalCh()
+	w := q.stats.consumerWaiter()
+	if err := q.waitLive(ctx, &w); err != nil {
+		var val T
+		return val, err
+	}
+	defer q.mutex.Unlock()
+	return q.linkedlist.Get(0)
+}
+
+// EnqueueAll 批量入队，返回成功入队的元素个数
+// 每次抢到锁之后，都会放入尽可能多的元素，而后才唤醒出队的 goroutine。
+// 在超时或者队列关闭的情况下，返回已经入队的个数以及对应的 error
+// 如果设置了非阻塞的 overflow 策略，那么会逐个入队，被丢弃的元素也计算在返回值里面
+func (q *LinkedBlockingQueue[T]) EnqueueAll(ctx context.Context, ts []T) (int, error) {
+	if ctx.Err() != nil {
+		return 0, ctx.Err()
+	}
+	cnt := 0
+	if q.overflow != OverflowBlock {
+		for _, t := range ts {
+			if err := q.Enqueue(ctx, t); err != nil {
+				return cnt, err
+			}
+			cnt++
+		}
+		return cnt, nil
+	}
+	for cnt < len(ts) {
+		q.mutex.Lock()
+		w := q.stats.producerWaiter()
+		if err := q.waitNotFull(ctx, &w); err != nil {
+			return cnt, err
+		}
+		if q.closed {
+			q.mutex.Unlock()
+			return cnt, errs.ErrQueueClosed
+		}
+		n := len(ts) - cnt
+		if free := q.maxSize - q.len(); q.maxSize > 0 && n > free {
+			n = free
+		}
+		err := q.append(ts[cnt : cnt+n]...)
+		// 这里会释放锁
+		q.notEmpty.broadcast()
+		if err != nil {
+			return cnt, err
+		}
+		cnt += n
+	}
+	return cnt, nil
+}
+
+// DequeueUpTo 批量出队，最多返回 max 个元素
+// 会阻塞直到至少有一个元素，而后把当下队列中的元素一并取走
+func (q *LinkedBlockingQueue[T]) DequeueUpTo(ctx context.Context, max int) ([]T, error) {
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	if max <= 0 {
+		return nil, nil
+	}
+	q.mutex.Lock()
+	w := q.stats.consumerWaiter()
+	if err := q.waitLive(ctx, &w); err != nil {
+		return nil, err
+	}
+	res, expired, err := q.deleteUpTo(max, w.waited)
+	// 这里会释放锁
+	q.notFull.broadcast()
+	q.expiry.expire(expired...)
+	return res, err
+}
+
+// DrainTo 取出队列中的元素放入 dst，最多取 len(dst) 个，返回取出的个数
+// 不会阻塞
+func (q *LinkedBlockingQueue[T]) DrainTo(dst []T) int {
+	q.mutex.Lock()
+	res, expired, _ := q.deleteUpTo(len(dst), 0)
+	// 这里会释放锁
+	q.notFull.broadcast()
+	q.expiry.expire(expired...)
+	return copy(dst, res)
+}
+
+// requeue 把元素重新放回队尾，不受容量限制，也不管队列是否已经关闭
+// 用于把已经取出去的元素还回来，所以不能因为队列满了而失败
+func (q *LinkedBlockingQueue[T]) requeue(data T) {
+	q.mutex.Lock()
+	_ = q.append(data)
+	// 这里会释放锁
+	q.notEmpty.broadcast()
+}
+
+// append 在队尾加入元素，并且记录未完成的任务，必须在锁范围内调用
+func (q *LinkedBlockingQueue[T]) append(ts ...T) error {
+	if err := q.linkedlist.Append(ts...); err != nil {
+		return err
+	}
+	if q.enqueuedAt != nil {
+		now := time.Now()
+		for range ts {
+			_ = q.enqueuedAt.Append(now)
+		}
+	}
+	q.tasks.add(len(ts))
+	q.stats.enqueue(len(ts))
+	return nil
+}
+
+// deleteUpTo 从队首开始删除最多 n 个没有过期的元素，过期的元素会被挑出来放在 expired 里面
+// 必须在锁范围内调用，waited 是出队者为此阻塞的时间
+func (q *LinkedBlockingQueue[T]) deleteUpTo(n int, waited time.Duration) (res []T, expired []T, err error) {
+	if n > q.len() {
+		n = q.len()
+	}
+	res = make([]T, 0, n)
+	for len(res) < n {
+		expired = append(expired, q.removeExpiredHead()...)
+		if q.isEmpty() {
+			break
+		}
+		val, err := q.deleteHead()
+		if err != nil {
+			q.stats.dequeue(len(res), waited)
+			return res, expired, err
+		}
+		res = append(res, val)
+	}
+	q.stats.dequeue(len(res), waited)
+	return res, expired, nil
+}
+
+// waitLive 阻塞直到队首有一个没有过期的元素，过期的元素会被丢弃
+// 必须在锁范围内调用。返回 nil 的时候依旧持有锁，返回 error 的时候已经释放了锁
+func (q *LinkedBlockingQueue[T]) waitLive(ctx context.Context, w *waiter) error {
+	for {
+		if err := q.waitNotEmpty(ctx, w); err != nil {
+			return err
+		}
+		expired := q.removeExpiredHead()
+		if len(expired) == 0 {
+			return nil
+		}
+		// 过期的元素释放了容量，这里会释放锁
+		q.notFull.broadcast()
+		q.expiry.expire(expired...)
+		q.mutex.Lock()
+	}
+}
+
+// removeExpiredHead 从队首开始删除连续的过期元素，必须在锁范围内调用
+func (q *LinkedBlockingQueue[T]) removeExpiredHead() []T {
+	if q.expiry == nil {
+		return nil
+	}
+	var res []T
+	for !q.isEmpty() {
+		val, _ := q.linkedlist.Get(0)
+		if !q.expiry.expired(val, q.headMeta()) {
+			break
+		}
+		_, _ = q.deleteHead()
+		q.stats.expire(1)
+		// 过期的元素永远不会有人调用 TaskDone
+		_ = q.tasks.taskDone()
+		res = append(res, val)
+	}
+	return res
+}
+
+// Purge 清理队列中所有过期的元素，返回清理掉的个数
+// 没有设置 TTL 并且元素也没有实现 Expirable 的时候什么也不会做
+func (q *LinkedBlockingQueue[T]) Purge() int {
+	if q.expiry == nil {
+		return 0
+	}
+	q.mutex.Lock()
+	vals := q.linkedlist.AsSlice()
+	var times []time.Time
+	if q.enqueuedAt != nil {
+		times = q.enqueuedAt.AsSlice()
+	}
+	live := list.NewLinkedList[T]()
+	var liveTimes *list.LinkedList[time.Time]
+	if q.enqueuedAt != nil {
+		liveTimes = list.NewLinkedList[time.Time]()
+	}
+	var expired []T
+	for i, val := range vals {
+		var meta Meta
+		if times != nil {
+			meta = newMeta(times[i])
+		}
+		if q.expiry.expired(val, meta) {
+			expired = append(expired, val)
+			continue
+		}
+		_ = live.Append(val)
+		if liveTimes != nil {
+			_ = liveTimes.Append(times[i])
+		}
+	}
+	if len(expired) == 0 {
+		q.mutex.Unlock()
+		return 0
+	}
+	q.linkedlist, q.enqueuedAt = live, liveTimes
+	q.stats.expire(len(expired))
+	for range expired {
+		_ = q.tasks.taskDone()
+	}
+	// 这里会释放锁
+	q.notFull.broadcast()
+	q.expiry.expire(expired...)
+	return len(expired)
+}
+
+// TryEnqueue 尝试入队，不会阻塞
+// 队列已满或者已经关闭的时候返回 false
+func (q *LinkedBlockingQueue[T]) TryEnqueue(data T) bool {
+	return q.tryEnqueue(data) == nil
+}
+
+func (q *LinkedBlockingQueue[T]) tryEnqueue(data T) error {
+	q.mutex.Lock()
+	if q.closed {
+		q.mutex.Unlock()
+		return errs.ErrQueueClosed
+	}
+	if q.maxSize > 0 && q.isFull() {
+		q.mutex.Unlock()
+		q.stats.full()
+		return errs.ErrOutOfCapacity
+	}
+	err := q.append(data)
+	// 这里会释放锁
+	q.notEmpty.broadcast()
+	return err
+}
+
+// TryDequeue 尝试出队，不会阻塞
+// 队列为空的时候返回 false
+func (q *LinkedBlockingQueue[T]) TryDequeue() (T, bool) {
+	val, err := q.tryDequeue()
+	return val, err == nil
+}
+
+func (q *LinkedBlockingQueue[T]) tryDequeue() (T, error) {
+	q.mutex.Lock()
+	expired := q.removeExpiredHead()
+	if q.isEmpty() {
+		closed := q.closed
+		if len(expired) > 0 {
+			// 过期的元素释放了容量，这里会释放锁
+			q.notFull.broadcast()
+			q.expiry.expire(expired...)
+		} else {
+			q.mutex.Unlock()
+		}
+		var val T
+		if closed {
+			return val, errs.ErrQueueClosed
+		}
+		q.stats.empty()
+		return val, errs.ErrEmptyQueue
+	}
+	val, err := q.deleteHead()
+	if err == nil {
+		q.stats.dequeue(1, 0)
+	}
+	// 这里会释放锁
+	q.notFull.broadcast()
+	q.expiry.expire(expired...)
+	return val, err
+}
+
+// Offer 在 timeout 内将元素放入队列
+// timeout <= 0 的时候不会阻塞，队列已满则返回 errs.ErrOutOfCapacity；
+// 否则会一直等待，超时返回 context.DeadlineExceeded
+func (q *LinkedBlockingQueue[T]) Offer(data T, timeout time.Duration) error {
+	if timeout <= 0 {
+		return q.tryEnqueue(data)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return q.Enqueue(ctx, data)
+}
+
+// Poll 在 timeout 内从队首获得一个元素
+// timeout <= 0 的时候不会阻塞，队列为空则返回 errs.ErrEmptyQueue；
+// 否则会一直等待，超时返回 context.DeadlineExceeded
+func (q *LinkedBlockingQueue[T]) Poll(timeout time.Duration) (T, error) {
+	if timeout <= 0 {
+		return q.tryDequeue()
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return q.Dequeue(ctx)
+}
+
+// TaskDone 标记一个已经出队的元素处理完毕
+// 每一个入队的元素都对应一个未完成的任务，调用次数超过入队的元素个数时返回 errs.ErrTooManyTaskDone
+func (q *LinkedBlockingQueue[T]) TaskDone() error {
+	return q.tasks.taskDone()
+}
+
+// Join 阻塞直到所有入队的元素都被调用了 TaskDone
+// 也就是说，不仅仅是被取走了，还要被处理完毕
+func (q *LinkedBlockingQueue[T]) Join(ctx context.Context) error {
+	return q.tasks.join(ctx)
+}
+
+// WaitEmpty 阻塞直到队列为空
+// 和 Join 不同，WaitEmpty 只关心元素是否已经被取走，不关心是否已经被处理完毕
+func (q *LinkedBlockingQueue[T]) WaitEmpty(ctx context.Context) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	q.mutex.Lock()
+	// 每次出队都会广播 notFull
+	for !q.isEmpty() {
+		signal := q.notFull.signalCh()
 		select {
 		case <-ctx.Done():
-			var val T
-			return val, ctx.Err()
+			return ctx.Err()
 		case <-signal:
 			q.mutex.Lock()
 		}
 	}
-	val, err := q.linkedlist.Delete(0)
-	// 这里会释放锁
+	q.mutex.Unlock()
+	return nil
+}
+
+// Close 关闭队列
+// 关闭之后入队会返回 errs.ErrQueueClosed，出队在取完剩余的元素之后返回 errs.ErrQueueClosed
+func (q *LinkedBlockingQueue[T]) Close() error {
+	q.mutex.Lock()
+	if q.closed {
+		q.mutex.Unlock()
+		return nil
+	}
+	q.closed = true
+	close(q.closeCh)
+	// 唤醒所有阻塞的入队者和出队者，broadcast 会释放锁
 	q.notFull.broadcast()
+	q.mutex.Lock()
+	q.notEmpty.broadcast()
+	return nil
+}
 
-	return val, err
+// Stats 返回统计信息的快照，不需要加锁
+func (q *LinkedBlockingQueue[T]) Stats() Stats {
+	return q.stats.snapshot()
 }
 
 func (q *LinkedBlockingQueue[T]) Len() int {
@@ -104,7 +653,29 @@ func (q *LinkedBlockingQueue[T]) IsFull() bool {
 }
 
 func (q *LinkedBlockingQueue[T]) isFull() bool {
-	return q.linkedlist.Len() == q.maxSize
+	return q.maxSize > 0 && q.linkedlist.Len() >= q.maxSize
+}
+
+// Cap 队列的容量，无界队列返回的是创建时候传入的非正数
+func (q *LinkedBlockingQueue[T]) Cap() int {
+	q.mutex.RLock()
+	defer q.mutex.RUnlock()
+	return q.maxSize
+}
+
+// SetCapacity 修改队列的容量，capacity 必须为正数，无界队列只能在创建的时候指定
+// 扩容会唤醒阻塞的入队者；
+// 缩容到比当前元素个数还小的时候，已有的元素会被保留，只是在元素个数降到新的容量以下之前，入队都会阻塞
+func (q *LinkedBlockingQueue[T]) SetCapacity(capacity int) error {
+	if capacity <= 0 {
+		return errs.ErrInvalidCapacity
+	}
+	q.mutex.Lock()
+	q.maxSize = capacity
+	q.stats.setCap(capacity)
+	// 这里会释放锁，不管是扩容还是缩容，让入队者重新检查一下
+	q.notFull.broadcast()
+	return nil
 }
 
 func (q *LinkedBlockingQueue[T]) AsSlice() []T {
@@ -1,12 +1,14 @@
 package concurrent_queue
 
 import (
+	"concurrent_queue/errs"
 	"context"
 	"fmt"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"math/rand"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -281,6 +283,519 @@ func TestLinkedBlockingQueue(t *testing.T) {
 	wg.Wait()
 }
 
+func TestLinkedBlockingQueue_Close(t *testing.T) {
+	// 关闭之后，入队失败，剩余的元素依旧可以取出来
+	t.Run("drain after close", func(t *testing.T) {
+		q := NewLinkedBlockingQueue[int](3)
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		require.NoError(t, q.Enqueue(ctx, 123))
+		require.NoError(t, q.Enqueue(ctx, 234))
+		require.NoError(t, q.Close())
+		// 重复关闭
+		require.NoError(t, q.Close())
+
+		err := q.Enqueue(ctx, 345)
+		assert.Equal(t, errs.ErrQueueClosed, err)
+
+		val, err := q.Dequeue(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, 123, val)
+		val, err = q.Dequeue(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, 234, val)
+		_, err = q.Dequeue(ctx)
+		assert.Equal(t, errs.ErrQueueClosed, err)
+	})
+
+	t.Run("wake up blocked producer", func(t *testing.T) {
+		q := NewLinkedBlockingQueue[int](1)
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+		defer cancel()
+		require.NoError(t, q.Enqueue(ctx, 123))
+		go func() {
+			time.Sleep(time.Millisecond * 100)
+			_ = q.Close()
+		}()
+		err := q.Enqueue(ctx, 234)
+		assert.Equal(t, errs.ErrQueueClosed, err)
+		assert.Nil(t, ctx.Err())
+		assert.Equal(t, []int{123}, q.AsSlice())
+	})
+
+	t.Run("wake up blocked consumer", func(t *testing.T) {
+		q := NewLinkedBlockingQueue[int](1)
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+		defer cancel()
+		go func() {
+			time.Sleep(time.Millisecond * 100)
+			_ = q.Close()
+		}()
+		_, err := q.Dequeue(ctx)
+		assert.Equal(t, errs.ErrQueueClosed, err)
+		assert.Nil(t, ctx.Err())
+	})
+}
+
+func TestLinkedBlockingQueue_Batch(t *testing.T) {
+	t.Run("enqueue all and dequeue up to", func(t *testing.T) {
+		q := NewLinkedBlockingQueue[int](3)
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		n, err := q.EnqueueAll(ctx, []int{1, 2})
+		require.NoError(t, err)
+		assert.Equal(t, 2, n)
+
+		vals, err := q.DequeueUpTo(ctx, 5)
+		require.NoError(t, err)
+		assert.Equal(t, []int{1, 2}, vals)
+
+		n, err = q.EnqueueAll(ctx, []int{3, 4, 5})
+		require.NoError(t, err)
+		assert.Equal(t, 3, n)
+		vals, err = q.DequeueUpTo(ctx, 2)
+		require.NoError(t, err)
+		assert.Equal(t, []int{3, 4}, vals)
+		assert.Equal(t, []int{5}, q.AsSlice())
+	})
+
+	t.Run("enqueue all more than capacity", func(t *testing.T) {
+		q := NewLinkedBlockingQueue[int](2)
+		ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*200)
+		defer cancel()
+		n, err := q.EnqueueAll(ctx, []int{1, 2, 3})
+		assert.Equal(t, context.DeadlineExceeded, err)
+		assert.Equal(t, 2, n)
+
+		ctx, cancel = context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		go func() {
+			time.Sleep(time.Millisecond * 100)
+			_, _ = q.DequeueUpTo(ctx, 2)
+		}()
+		n, err = q.EnqueueAll(ctx, []int{3, 4})
+		require.NoError(t, err)
+		assert.Equal(t, 2, n)
+		assert.Equal(t, []int{3, 4}, q.AsSlice())
+	})
+
+	t.Run("unbounded enqueue all", func(t *testing.T) {
+		q := NewLinkedBlockingQueue[int](0)
+		n, err := q.EnqueueAll(context.Background(), []int{1, 2, 3, 4})
+		require.NoError(t, err)
+		assert.Equal(t, 4, n)
+	})
+
+	t.Run("dequeue up to timeout", func(t *testing.T) {
+		q := NewLinkedBlockingQueue[int](3)
+		ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*100)
+		defer cancel()
+		_, err := q.DequeueUpTo(ctx, 3)
+		assert.Equal(t, context.DeadlineExceeded, err)
+	})
+
+	t.Run("drain to", func(t *testing.T) {
+		q := NewLinkedBlockingQueue[int](3)
+		_, err := q.EnqueueAll(context.Background(), []int{1, 2, 3})
+		require.NoError(t, err)
+
+		dst := make([]int, 2)
+		assert.Equal(t, 2, q.DrainTo(dst))
+		assert.Equal(t, []int{1, 2}, dst)
+		dst = make([]int, 5)
+		assert.Equal(t, 1, q.DrainTo(dst))
+		assert.Equal(t, 3, dst[0])
+		assert.Equal(t, 0, q.DrainTo(dst))
+	})
+}
+
+func TestLinkedBlockingQueue_Peek(t *testing.T) {
+	q := NewLinkedBlockingQueue[int](3)
+	_, err := q.Peek()
+	assert.Equal(t, errs.ErrEmptyQueue, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*100)
+	defer cancel()
+	_, err = q.PeekWait(ctx)
+	assert.Equal(t, context.DeadlineExceeded, err)
+
+	ctx, cancel = context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	go func() {
+		time.Sleep(time.Millisecond * 100)
+		_ = q.Enqueue(ctx, 123)
+	}()
+	val, err := q.PeekWait(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 123, val)
+	// 不会取走元素
+	val, err = q.Peek()
+	require.NoError(t, err)
+	assert.Equal(t, 123, val)
+	assert.Equal(t, 1, q.Len())
+
+	_, err = q.Dequeue(ctx)
+	require.NoError(t, err)
+	require.NoError(t, q.Close())
+	_, err = q.PeekWait(ctx)
+	assert.Equal(t, errs.ErrQueueClosed, err)
+}
+
+func TestLinkedBlockingQueue_Join(t *testing.T) {
+	q := NewLinkedBlockingQueue[int](10)
+	// 没有任务的时候直接返回
+	require.NoError(t, q.Join(context.Background()))
+	require.NoError(t, q.WaitEmpty(context.Background()))
+	assert.Equal(t, errs.ErrTooManyTaskDone, q.TaskDone())
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	_, err := q.EnqueueAll(ctx, []int{1, 2, 3})
+	require.NoError(t, err)
+
+	var processed int64
+	go func() {
+		for i := 0; i < 3; i++ {
+			_, err := q.Dequeue(ctx)
+			if err != nil {
+				return
+			}
+			time.Sleep(time.Millisecond * 10)
+			atomic.AddInt64(&processed, 1)
+			_ = q.TaskDone()
+		}
+	}()
+	require.NoError(t, q.WaitEmpty(ctx))
+	require.NoError(t, q.Join(ctx))
+	assert.Equal(t, int64(3), atomic.LoadInt64(&processed))
+	assert.Equal(t, errs.ErrTooManyTaskDone, q.TaskDone())
+
+	// 出队了但是没有调用 TaskDone
+	require.NoError(t, q.Enqueue(ctx, 4))
+	_, err = q.Dequeue(ctx)
+	require.NoError(t, err)
+	require.NoError(t, q.WaitEmpty(ctx))
+	timeoutCtx, timeoutCancel := context.WithTimeout(context.Background(), time.Millisecond*100)
+	defer timeoutCancel()
+	assert.Equal(t, context.DeadlineExceeded, q.Join(timeoutCtx))
+}
+
+func TestLinkedBlockingQueue_Stats(t *testing.T) {
+	q := NewLinkedBlockingQueue[int](2, WithOverflowPolicy[int](OverflowDropOldest))
+	assert.Equal(t, Stats{Cap: 2}, q.Stats())
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	_, err := q.EnqueueAll(ctx, []int{1, 2, 3})
+	require.NoError(t, err)
+	stats := q.Stats()
+	assert.Equal(t, uint64(3), stats.Enqueued)
+	assert.Equal(t, uint64(1), stats.Dropped)
+	assert.Equal(t, 2, stats.Len)
+	assert.Equal(t, 2, stats.HighWaterMark)
+
+	_, err = q.DequeueUpTo(ctx, 2)
+	require.NoError(t, err)
+	// 阻塞的出队者
+	go func() {
+		_, _ = q.Dequeue(ctx)
+	}()
+	require.Eventually(t, func() bool {
+		return q.Stats().BlockedConsumers == 1
+	}, time.Second, time.Millisecond*10)
+	require.NoError(t, q.Enqueue(ctx, 4))
+	require.Eventually(t, func() bool {
+		return q.Stats().BlockedConsumers == 0
+	}, time.Second, time.Millisecond*10)
+
+	timeoutCtx, timeoutCancel := context.WithTimeout(context.Background(), time.Millisecond*50)
+	defer timeoutCancel()
+	_, err = q.PeekWait(timeoutCtx)
+	assert.Equal(t, context.DeadlineExceeded, err)
+
+	stats = q.Stats()
+	assert.Equal(t, uint64(4), stats.Enqueued)
+	assert.Equal(t, uint64(3), stats.Dequeued)
+	assert.Equal(t, 0, stats.Len)
+	assert.Equal(t, uint64(1), stats.Timeouts)
+	assert.True(t, stats.WaitTime >= time.Millisecond*50)
+}
+
+func TestLinkedBlockingQueue_DequeueWithMeta(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	q := NewLinkedBlockingQueue[int](0)
+	require.NoError(t, q.Enqueue(ctx, 1))
+	assert.Equal(t, time.Duration(0), q.OldestAge())
+	_, meta, err := q.DequeueWithMeta(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, Meta{}, meta)
+
+	q = NewLinkedBlockingQueue[int](2, WithSojournTracking[int](),
+		WithOverflowPolicy[int](OverflowDropOldest))
+	require.NoError(t, q.Enqueue(ctx, 1))
+	time.Sleep(time.Millisecond * 20)
+	_, err = q.EnqueueAll(ctx, []int{2, 3})
+	require.NoError(t, err)
+	// 1 被丢弃了，队首是 2
+	assert.True(t, q.OldestAge() < time.Millisecond*20)
+
+	val, meta, err := q.DequeueWithMeta(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 2, val)
+	assert.False(t, meta.EnqueuedAt.IsZero())
+	assert.True(t, meta.Sojourn < time.Millisecond*20)
+	res, err := q.DequeueUpTo(ctx, 10)
+	require.NoError(t, err)
+	assert.Equal(t, []int{3}, res)
+	assert.Equal(t, time.Duration(0), q.OldestAge())
+
+	// 出队的方式不同也不会错位
+	require.NoError(t, q.Enqueue(ctx, 4))
+	time.Sleep(time.Millisecond * 20)
+	require.NoError(t, q.Enqueue(ctx, 5))
+	_, ok := q.TryDequeue()
+	assert.True(t, ok)
+	assert.True(t, q.OldestAge() < time.Millisecond*20)
+}
+
+func TestLinkedBlockingQueue_TTL(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	var expired []int
+	var mutex sync.Mutex
+	q := NewLinkedBlockingQueue[int](2, WithTTL[int](time.Millisecond*50),
+		WithExpireCallback[int](func(t int) {
+			mutex.Lock()
+			expired = append(expired, t)
+			mutex.Unlock()
+		}))
+	_, err := q.EnqueueAll(ctx, []int{1, 2})
+	require.NoError(t, err)
+
+	// 过期之后，阻塞的入队者会被唤醒
+	enqueued := make(chan error, 1)
+	go func() {
+		enqueued <- q.Enqueue(ctx, 3)
+	}()
+	time.Sleep(time.Millisecond * 60)
+	assert.Equal(t, 2, q.Purge())
+	require.NoError(t, <-enqueued)
+	res, err := q.DequeueUpTo(ctx, 10)
+	require.NoError(t, err)
+	assert.Equal(t, []int{3}, res)
+
+	// 全都过期了，出队者会继续等
+	require.NoError(t, q.Enqueue(ctx, 4))
+	time.Sleep(time.Millisecond * 60)
+	go func() {
+		time.Sleep(time.Millisecond * 20)
+		_ = q.Enqueue(ctx, 5)
+	}()
+	val, err := q.Dequeue(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 5, val)
+
+	require.NoError(t, q.Enqueue(ctx, 6))
+	time.Sleep(time.Millisecond * 60)
+	_, ok := q.TryDequeue()
+	assert.False(t, ok)
+
+	// Peek 会移除过期的队首元素
+	require.NoError(t, q.Enqueue(ctx, 7))
+	time.Sleep(time.Millisecond * 60)
+	require.NoError(t, q.Enqueue(ctx, 8))
+	val, err = q.Peek()
+	require.NoError(t, err)
+	assert.Equal(t, 8, val)
+	assert.Equal(t, 1, q.Len())
+	val, err = q.Dequeue(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 8, val)
+	require.NoError(t, q.Enqueue(ctx, 9))
+	time.Sleep(time.Millisecond * 60)
+	_, err = q.Peek()
+	assert.Equal(t, errs.ErrEmptyQueue, err)
+
+	mutex.Lock()
+	assert.Equal(t, []int{1, 2, 4, 6, 7, 9}, expired)
+	mutex.Unlock()
+	stats := q.Stats()
+	assert.Equal(t, uint64(6), stats.Expired)
+	assert.Equal(t, 0, stats.Len)
+}
+
+func TestLinkedBlockingQueue_Expirable(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	q := NewLinkedBlockingQueue[expirableElem](0, WithExpirySweep[expirableElem](time.Millisecond*10))
+	defer func() {
+		_ = q.Close()
+	}()
+	now := time.Now()
+	_, err := q.EnqueueAll(ctx, []expirableElem{
+		{val: 1, deadline: now},
+		{val: 2, deadline: now.Add(time.Second)},
+		{val: 3, deadline: now},
+		{val: 4, deadline: now.Add(time.Second)},
+	})
+	require.NoError(t, err)
+	res, err := q.DequeueUpTo(ctx, 10)
+	require.NoError(t, err)
+	assert.Equal(t, []expirableElem{
+		{val: 2, deadline: now.Add(time.Second)},
+		{val: 4, deadline: now.Add(time.Second)},
+	}, res)
+
+	// 后台清理
+	require.NoError(t, q.Enqueue(ctx, expirableElem{val: 5, deadline: time.Now().Add(time.Millisecond * 20)}))
+	require.Eventually(t, func() bool {
+		return q.Len() == 0
+	}, time.Second, time.Millisecond*10)
+	assert.Equal(t, uint64(3), q.Stats().Expired)
+}
+
+type expirableElem struct {
+	val      int
+	deadline time.Time
+}
+
+func (e expirableElem) Expiry() time.Duration {
+	return time.Until(e.deadline)
+}
+
+func TestLinkedBlockingQueue_OverflowPolicy(t *testing.T) {
+	testCases := []struct {
+		name        string
+		policy      OverflowPolicy
+		wantErr     error
+		wantSlice   []int
+		wantDropped []int
+	}{
+		{
+			name:      "reject",
+			policy:    OverflowReject,
+			wantErr:   errs.ErrOutOfCapacity,
+			wantSlice: []int{1, 2, 3},
+		},
+		{
+			name:        "drop oldest",
+			policy:      OverflowDropOldest,
+			wantSlice:   []int{2, 3, 4},
+			wantDropped: []int{1},
+		},
+		{
+			name:        "drop newest",
+			policy:      OverflowDropNewest,
+			wantSlice:   []int{1, 2, 3},
+			wantDropped: []int{4},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var dropped []int
+			q := NewLinkedBlockingQueue[int](3, WithOverflowPolicy[int](tc.policy),
+				WithDropCallback(func(t int) {
+					dropped = append(dropped, t)
+				}))
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+			n, err := q.EnqueueAll(ctx, []int{1, 2, 3})
+			require.NoError(t, err)
+			require.Equal(t, 3, n)
+
+			// 不会阻塞
+			err = q.Enqueue(ctx, 4)
+			assert.Equal(t, tc.wantErr, err)
+			assert.Nil(t, ctx.Err())
+			assert.Equal(t, tc.wantSlice, q.AsSlice())
+			assert.Equal(t, tc.wantDropped, dropped)
+		})
+	}
+
+	// 被丢弃的元素不需要调用 TaskDone
+	t.Run("drop oldest and join", func(t *testing.T) {
+		q := NewLinkedBlockingQueue[int](1, WithOverflowPolicy[int](OverflowDropOldest))
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		n, err := q.EnqueueAll(ctx, []int{1, 2, 3})
+		require.NoError(t, err)
+		require.Equal(t, 3, n)
+		val, err := q.Dequeue(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, 3, val)
+		require.NoError(t, q.TaskDone())
+		require.NoError(t, q.Join(ctx))
+	})
+}
+
+func TestLinkedBlockingQueue_SetCapacity(t *testing.T) {
+	q := NewLinkedBlockingQueue[int](2)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	require.NoError(t, q.Enqueue(ctx, 1))
+	require.NoError(t, q.Enqueue(ctx, 2))
+	// 扩容会唤醒阻塞的入队者
+	go func() {
+		time.Sleep(time.Millisecond * 100)
+		require.NoError(t, q.SetCapacity(3))
+	}()
+	require.NoError(t, q.Enqueue(ctx, 3))
+	assert.Equal(t, 3, q.Cap())
+
+	// 缩容之后，元素保留，直到元素个数降到新的容量以下才能入队
+	require.NoError(t, q.SetCapacity(1))
+	assert.Equal(t, []int{1, 2, 3}, q.AsSlice())
+	assert.True(t, q.IsFull())
+	assert.False(t, q.TryEnqueue(4))
+	_, err := q.DequeueUpTo(ctx, 2)
+	require.NoError(t, err)
+	assert.False(t, q.TryEnqueue(4))
+	_, err = q.Dequeue(ctx)
+	require.NoError(t, err)
+	assert.True(t, q.TryEnqueue(4))
+
+	// 不能变为无界队列，容量保持不变
+	assert.Equal(t, errs.ErrInvalidCapacity, q.SetCapacity(0))
+	assert.Equal(t, errs.ErrInvalidCapacity, q.SetCapacity(-1))
+	assert.Equal(t, 1, q.Cap())
+	assert.False(t, q.TryEnqueue(5))
+}
+
+func TestLinkedBlockingQueue_TryEnqueueAndTryDequeue(t *testing.T) {
+	q := NewLinkedBlockingQueue[int](2)
+	assert.True(t, q.TryEnqueue(123))
+	assert.True(t, q.TryEnqueue(234))
+	// 满了
+	assert.False(t, q.TryEnqueue(345))
+	assert.Equal(t, errs.ErrOutOfCapacity, q.Offer(345, 0))
+	assert.Equal(t, context.DeadlineExceeded, q.Offer(345, time.Millisecond*100))
+
+	val, ok := q.TryDequeue()
+	assert.True(t, ok)
+	assert.Equal(t, 123, val)
+	val, err := q.Poll(time.Second)
+	require.NoError(t, err)
+	assert.Equal(t, 234, val)
+	// 空了
+	_, ok = q.TryDequeue()
+	assert.False(t, ok)
+	_, err = q.Poll(0)
+	assert.Equal(t, errs.ErrEmptyQueue, err)
+	_, err = q.Poll(time.Millisecond * 100)
+	assert.Equal(t, context.DeadlineExceeded, err)
+
+	require.NoError(t, q.Close())
+	assert.False(t, q.TryEnqueue(456))
+	assert.Equal(t, errs.ErrQueueClosed, q.Offer(456, 0))
+	_, err = q.Poll(0)
+	assert.Equal(t, errs.ErrQueueClosed, err)
+}
+
 func ExampleNewLinkedBlockingQueue() {
 	// 创建一个容量为 10 的有界并发阻塞队列，如果传入 0 或者负数，那么创建的是无界并发阻塞队列
 	q := NewLinkedBlockingQueue[int](10)
@@ -11,14 +11,19 @@ type LinkedQueue[T any] struct {
 	head  unsafe.Pointer
 	tail  unsafe.Pointer
 	count uint64
+	stats *queueStats
 }
 
-func NewLinkedQueue[T any]() *LinkedQueue[T] {
+// NewLinkedQueue 创建一个无锁的无界队列
+// 支持的 Option：WithObserver
+func NewLinkedQueue[T any](opts ...Option[T]) *LinkedQueue[T] {
+	options := newQueueOptions(opts)
 	head := &node[T]{}
 	ptr := unsafe.Pointer(head)
 	return &LinkedQueue[T]{
-		head: ptr,
-		tail: ptr,
+		head:  ptr,
+		tail:  ptr,
+		stats: newQueueStats(0, options.observer),
 	}
 }
 
@@ -49,9 +54,11 @@ func (q *LinkedQueue[T]) Enqueue(ctx context.Context, data T) error {
 			// 在这一步，就要讲 tail.next 指向 c.tail
 			// tail.next = c.tail
 			tailNode := (*node[T])(tailPtr)
+			// 先计数再把结点接上去，否则出队者可能先减掉计数，count 会短暂地下溢
+			atomic.AddUint64(&q.count, 1)
+			q.stats.enqueue(1)
 			// 你在这一步，c.tail 被人修改了
 			atomic.StorePointer(&tailNode.next, newNodePtr)
-			atomic.AddUint64(&q.count, 1)
 			return nil
 		}
 
@@ -93,6 +100,7 @@ func (q *LinkedQueue[T]) Dequeue(ctx context.Context) (T, error) {
 			// 不需要做更多检测，在当下这一刻，我们就认为没有元素，即便这时候正好有人入队
 			// 但是并不妨碍我们在它彻底入队完成——即所有的指针都调整好——之前，
 			// 认为其实还是没有元素
+			q.stats.empty()
 			var t T
 			return t, errs.ErrEmptyQueue
 		}
@@ -108,6 +116,9 @@ func (q *LinkedQueue[T]) Dequeue(ctx context.Context) (T, error) {
 			// 返回当前对头节点。
 
 			headNextNode := (*node[T])(headNextPtr)
+			// 相当于 count - 1
+			atomic.AddUint64(&q.count, ^uint64(0))
+			q.stats.dequeue(1, 0)
 			// TODO TestLinkedQueue 测试这一步貌似出现问题
 			return headNextNode.val, nil
 		}
@@ -116,12 +127,33 @@ func (q *LinkedQueue[T]) Dequeue(ctx context.Context) (T, error) {
 	// CAS 返回失败，说明队头变了，其他想要出队的，已经抢先出队而且完成了，那就要重头再来
 }
 
+// Peek 返回队首元素，但是不会将其取出
+// 队列为空的时候返回 errs.ErrEmptyQueue
+// 在并发的情况下，返回的元素有可能在这一刻已经被别人取走了
+func (q *LinkedQueue[T]) Peek() (T, error) {
+	headPtr := atomic.LoadPointer(&q.head)
+	headNode := (*node[T])(headPtr)
+	headNextPtr := atomic.LoadPointer(&headNode.next)
+	// 有人修改了 tail 但是还没来得及把 next 接上，同样认为没有元素
+	if headNextPtr == nil {
+		var t T
+		return t, errs.ErrEmptyQueue
+	}
+	return (*node[T])(headNextPtr).val, nil
+}
+
 // TODO 无最大容量限制
 //func (q *LinkedQueue[T]) IsFull() bool {
 //	// TODO implement me
 //	panic("implement me")
 //}
 
+// Stats 返回统计信息的快照，不需要加锁
+// LinkedQueue 是无界并且不会阻塞的，所以容量和阻塞相关的数据总是为 0
+func (q *LinkedQueue[T]) Stats() Stats {
+	return q.stats.snapshot()
+}
+
 func (q *LinkedQueue[T]) IsEmpty() bool {
 	return atomic.LoadUint64(&q.count) == 0
 }
@@ -180,6 +180,46 @@ func TestLinkedQueue(t *testing.T) {
 	wg.Wait()
 }
 
+func TestLinkedQueue_Peek(t *testing.T) {
+	q := NewLinkedQueue[int]()
+	_, err := q.Peek()
+	assert.Equal(t, errs.ErrEmptyQueue, err)
+
+	require.NoError(t, q.Enqueue(context.Background(), 123))
+	require.NoError(t, q.Enqueue(context.Background(), 234))
+	val, err := q.Peek()
+	require.NoError(t, err)
+	assert.Equal(t, 123, val)
+
+	val, err = q.Dequeue(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 123, val)
+	val, err = q.Peek()
+	require.NoError(t, err)
+	assert.Equal(t, 234, val)
+}
+
+func TestLinkedQueue_Stats(t *testing.T) {
+	q := NewLinkedQueue[int]()
+	require.NoError(t, q.Enqueue(context.Background(), 123))
+	require.NoError(t, q.Enqueue(context.Background(), 234))
+	_, err := q.Dequeue(context.Background())
+	require.NoError(t, err)
+	// Dequeue 之后 Len 也要减少
+	assert.Equal(t, uint64(1), q.Len())
+	assert.False(t, q.IsEmpty())
+
+	stats := q.Stats()
+	assert.Equal(t, uint64(2), stats.Enqueued)
+	assert.Equal(t, uint64(1), stats.Dequeued)
+	assert.Equal(t, 1, stats.Len)
+	assert.Equal(t, 2, stats.HighWaterMark)
+
+	_, err = q.Dequeue(context.Background())
+	require.NoError(t, err)
+	assert.True(t, q.IsEmpty())
+}
+
 func (q *LinkedQueue[T]) asSlice() []T {
 	var res []T
 	//curPointer := (*node[T])(q.head).next
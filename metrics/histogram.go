@@ -0,0 +1,73 @@
+package metrics
+
+import (
+	"concurrent_queue"
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultWaitBuckets 等待时间直方图默认的桶，单位是秒
+// 覆盖了 100 微秒到 10 秒
+var DefaultWaitBuckets = []float64{
+	0.0001, 0.00025, 0.0005, 0.001, 0.0025, 0.005, 0.01,
+	0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10,
+}
+
+// waitHistogram 记录出队者的等待时间
+// 它本身就是一个 Observer，只关心 OnDequeue
+type waitHistogram struct {
+	concurrent_queue.NopObserver
+	// 桶的上界，升序，单位是秒
+	bounds []float64
+	// counts[i] 是落在 (bounds[i-1], bounds[i]] 里面的次数，最后一个是 +Inf
+	// 输出的时候再累加
+	counts   []uint64
+	sumNanos int64
+}
+
+func newWaitHistogram(buckets []float64) *waitHistogram {
+	bounds := make([]float64, len(buckets))
+	copy(bounds, buckets)
+	sort.Float64s(bounds)
+	return &waitHistogram{
+		bounds: bounds,
+		counts: make([]uint64, len(bounds)+1),
+	}
+}
+
+func (h *waitHistogram) OnDequeue(waited time.Duration) {
+	h.observe(waited)
+}
+
+func (h *waitHistogram) observe(waited time.Duration) {
+	seconds := waited.Seconds()
+	// 第一个大于等于 seconds 的上界，找不到就落在 +Inf
+	i := sort.SearchFloat64s(h.bounds, seconds)
+	atomic.AddUint64(&h.counts[i], 1)
+	atomic.AddInt64(&h.sumNanos, int64(waited))
+}
+
+// histogramSnapshot 直方图的快照，buckets 已经是累加之后的结果
+type histogramSnapshot struct {
+	bounds  []float64
+	buckets []uint64
+	count   uint64
+	sum     float64
+}
+
+func (h *waitHistogram) snapshot() histogramSnapshot {
+	res := histogramSnapshot{
+		bounds:  h.bounds,
+		buckets: make([]uint64, len(h.counts)),
+	}
+	var cumulative uint64
+	for i := range h.counts {
+		cumulative += atomic.LoadUint64(&h.counts[i])
+		res.buckets[i] = cumulative
+	}
+	// 用桶的总数作为 count，保证 +Inf 桶和 count 一致
+	res.count = cumulative
+	res.sum = time.Duration(atomic.LoadInt64(&h.sumNanos)).Seconds()
+	return res
+}
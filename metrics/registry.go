@@ -0,0 +1,385 @@
+package metrics
+
+import (
+	"bufio"
+	"concurrent_queue"
+	"concurrent_queue/errs"
+	"expvar"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Queue 能够提供统计信息的队列，concurrent_queue 里面的队列都实现了这个接口
+type Queue interface {
+	Stats() concurrent_queue.Stats
+}
+
+// Registry 管理命名的队列，并且把它们的统计信息以 Prometheus 文本格式或者 expvar 的形式暴露出去
+//
+//	reg := metrics.NewRegistry()
+//	q := concurrent_queue.NewArrayBlockingQueue[int](10,
+//		concurrent_queue.WithObserver[int](reg.Observer("ingest")))
+//	_ = reg.Register("ingest", q)
+//	http.Handle("/metrics", reg)
+type Registry struct {
+	mutex   sync.RWMutex
+	entries map[string]*entry
+	buckets []float64
+}
+
+type entry struct {
+	queue Queue
+	wait  *waitHistogram
+}
+
+// NewRegistry 创建 Registry，等待时间的直方图使用 DefaultWaitBuckets
+func NewRegistry() *Registry {
+	return NewRegistryWithBuckets(DefaultWaitBuckets)
+}
+
+// NewRegistryWithBuckets 创建 Registry，buckets 是等待时间直方图的桶的上界，单位是秒
+func NewRegistryWithBuckets(buckets []float64) *Registry {
+	return &Registry{
+		entries: make(map[string]*entry, 4),
+		buckets: buckets,
+	}
+}
+
+// Register 注册一个队列，深度、容量以及各种计数都是从 q.Stats() 里面读取的
+// 同名的队列已经注册过的时候返回 errs.ErrDuplicateQueue
+func (r *Registry) Register(name string, q Queue) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	e, ok := r.entries[name]
+	if !ok {
+		e = &entry{}
+		r.entries[name] = e
+	}
+	if e.queue != nil {
+		return errs.ErrDuplicateQueue
+	}
+	e.queue = q
+	return nil
+}
+
+// Unregister 移除队列，包括它的等待时间直方图
+func (r *Registry) Unregister(name string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	delete(r.entries, name)
+}
+
+// Observer 返回 name 对应的 Observer，用来统计出队者等待时间的直方图
+// 需要在创建队列的时候通过 concurrent_queue.WithObserver 传入。
+// 同一个 name 多次调用返回的是同一个 Observer
+func (r *Registry) Observer(name string) concurrent_queue.Observer {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	e, ok := r.entries[name]
+	if !ok {
+		e = &entry{}
+		r.entries[name] = e
+	}
+	if e.wait == nil {
+		e.wait = newWaitHistogram(r.buckets)
+	}
+	return e.wait
+}
+
+// sample 某个队列在某一刻的数据
+type sample struct {
+	name  string
+	stats *concurrent_queue.Stats
+	wait  *histogramSnapshot
+}
+
+// collect 按照名字排序，保证输出的顺序是稳定的
+func (r *Registry) collect() []sample {
+	r.mutex.RLock()
+	res := make([]sample, 0, len(r.entries))
+	for name, e := range r.entries {
+		s := sample{name: name}
+		if e.queue != nil {
+			stats := e.queue.Stats()
+			s.stats = &stats
+		}
+		if e.wait != nil {
+			wait := e.wait.snapshot()
+			s.wait = &wait
+		}
+		res = append(res, s)
+	}
+	r.mutex.RUnlock()
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].name < res[j].name
+	})
+	return res
+}
+
+// ServeHTTP 以 Prometheus 文本格式输出所有队列的数据
+func (r *Registry) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	_ = r.WritePrometheus(w)
+}
+
+type metric struct {
+	name string
+	help string
+	typ  string
+	val  func(stats *concurrent_queue.Stats) float64
+}
+
+var metricList = []metric{
+	{
+		name: "concurrent_queue_depth",
+		help: "Current number of items in the queue.",
+		typ:  "gauge",
+		val: func(stats *concurrent_queue.Stats) float64 {
+			return float64(stats.Len)
+		},
+	},
+	{
+		name: "concurrent_queue_capacity",
+		help: "Capacity of the queue, 0 for unbounded queues.",
+		typ:  "gauge",
+		val: func(stats *concurrent_queue.Stats) float64 {
+			return float64(stats.Cap)
+		},
+	},
+	{
+		name: "concurrent_queue_high_water_mark",
+		help: "Maximum number of items ever held by the queue.",
+		typ:  "gauge",
+		val: func(stats *concurrent_queue.Stats) float64 {
+			return float64(stats.HighWaterMark)
+		},
+	},
+	{
+		name: "concurrent_queue_cost_budget",
+		help: "Total cost budget of the queue, 0 for queues not limited by cost.",
+		typ:  "gauge",
+		val: func(stats *concurrent_queue.Stats) float64 {
+			return float64(stats.Budget)
+		},
+	},
+	{
+		name: "concurrent_queue_cost_used",
+		help: "Total cost of the items in the queue, 0 for queues not limited by cost.",
+		typ:  "gauge",
+		val: func(stats *concurrent_queue.Stats) float64 {
+			return float64(stats.Used)
+		},
+	},
+	{
+		name: "concurrent_queue_blocked_producers",
+		help: "Number of goroutines currently blocked on enqueue.",
+		typ:  "gauge",
+		val: func(stats *concurrent_queue.Stats) float64 {
+			return float64(stats.BlockedProducers)
+		},
+	},
+	{
+		name: "concurrent_queue_blocked_consumers",
+		help: "Number of goroutines currently blocked on dequeue.",
+		typ:  "gauge",
+		val: func(stats *concurrent_queue.Stats) float64 {
+			return float64(stats.BlockedConsumers)
+		},
+	},
+	{
+		name: "concurrent_queue_enqueued_total",
+		help: "Total number of items enqueued.",
+		typ:  "counter",
+		val: func(stats *concurrent_queue.Stats) float64 {
+			return float64(stats.Enqueued)
+		},
+	},
+	{
+		name: "concurrent_queue_dequeued_total",
+		help: "Total number of items dequeued.",
+		typ:  "counter",
+		val: func(stats *concurrent_queue.Stats) float64 {
+			return float64(stats.Dequeued)
+		},
+	},
+	{
+		name: "concurrent_queue_dropped_total",
+		help: "Total number of items dropped by the overflow policy.",
+		typ:  "counter",
+		val: func(stats *concurrent_queue.Stats) float64 {
+			return float64(stats.Dropped)
+		},
+	},
+	{
+		name: "concurrent_queue_expired_total",
+		help: "Total number of items discarded because they expired.",
+		typ:  "counter",
+		val: func(stats *concurrent_queue.Stats) float64 {
+			return float64(stats.Expired)
+		},
+	},
+	{
+		name: "concurrent_queue_timeouts_total",
+		help: "Total number of blocking calls that ended because the context was done.",
+		typ:  "counter",
+		val: func(stats *concurrent_queue.Stats) float64 {
+			return float64(stats.Timeouts)
+		},
+	},
+	{
+		name: "concurrent_queue_wait_seconds_total",
+		help: "Total time spent blocked on enqueue and dequeue.",
+		typ:  "counter",
+		val: func(stats *concurrent_queue.Stats) float64 {
+			return stats.WaitTime.Seconds()
+		},
+	},
+}
+
+const waitHistogramName = "concurrent_queue_dequeue_wait_seconds"
+
+// WritePrometheus 以 Prometheus 文本格式把所有队列的数据写入 w
+func (r *Registry) WritePrometheus(w io.Writer) error {
+	samples := r.collect()
+	bw := bufio.NewWriter(w)
+	for _, m := range metricList {
+		header := false
+		for _, s := range samples {
+			if s.stats == nil {
+				continue
+			}
+			if !header {
+				writeHeader(bw, m.name, m.help, m.typ)
+				header = true
+			}
+			bw.WriteString(m.name)
+			bw.WriteString(`{queue="`)
+			bw.WriteString(escapeLabel(s.name))
+			bw.WriteString(`"} `)
+			bw.WriteString(formatFloat(m.val(s.stats)))
+			bw.WriteByte('\n')
+		}
+	}
+
+	header := false
+	for _, s := range samples {
+		if s.wait == nil {
+			continue
+		}
+		if !header {
+			writeHeader(bw, waitHistogramName,
+				"Time consumers spent blocked before getting an item.", "histogram")
+			header = true
+		}
+		label := escapeLabel(s.name)
+		for i, cnt := range s.wait.buckets {
+			le := math.Inf(1)
+			if i < len(s.wait.bounds) {
+				le = s.wait.bounds[i]
+			}
+			bw.WriteString(waitHistogramName)
+			bw.WriteString(`_bucket{queue="`)
+			bw.WriteString(label)
+			bw.WriteString(`",le="`)
+			bw.WriteString(formatFloat(le))
+			bw.WriteString(`"} `)
+			bw.WriteString(strconv.FormatUint(cnt, 10))
+			bw.WriteByte('\n')
+		}
+		bw.WriteString(waitHistogramName)
+		bw.WriteString(`_sum{queue="`)
+		bw.WriteString(label)
+		bw.WriteString(`"} `)
+		bw.WriteString(formatFloat(s.wait.sum))
+		bw.WriteByte('\n')
+		bw.WriteString(waitHistogramName)
+		bw.WriteString(`_count{queue="`)
+		bw.WriteString(label)
+		bw.WriteString(`"} `)
+		bw.WriteString(strconv.FormatUint(s.wait.count, 10))
+		bw.WriteByte('\n')
+	}
+	return bw.Flush()
+}
+
+func writeHeader(bw *bufio.Writer, name, help, typ string) {
+	bw.WriteString("# HELP ")
+	bw.WriteString(name)
+	bw.WriteByte(' ')
+	bw.WriteString(help)
+	bw.WriteString("\n# TYPE ")
+	bw.WriteString(name)
+	bw.WriteByte(' ')
+	bw.WriteString(typ)
+	bw.WriteByte('\n')
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabel(val string) string {
+	return labelEscaper.Replace(val)
+}
+
+func formatFloat(val float64) string {
+	switch {
+	case math.IsInf(val, 1):
+		return "+Inf"
+	case math.IsInf(val, -1):
+		return "-Inf"
+	case math.IsNaN(val):
+		return "NaN"
+	default:
+		return strconv.FormatFloat(val, 'g', -1, 64)
+	}
+}
+
+// PublishExpvar 把所有队列的数据通过 expvar 以 name 发布出去
+// 和 expvar.Publish 一样，同一个 name 只能发布一次，重复发布会 panic
+func (r *Registry) PublishExpvar(name string) {
+	expvar.Publish(name, expvar.Func(r.expvarValue))
+}
+
+func (r *Registry) expvarValue() any {
+	samples := r.collect()
+	res := make(map[string]any, len(samples))
+	for _, s := range samples {
+		val := make(map[string]any, 14)
+		if s.stats != nil {
+			val["depth"] = s.stats.Len
+			val["capacity"] = s.stats.Cap
+			val["high_water_mark"] = s.stats.HighWaterMark
+			val["cost_budget"] = s.stats.Budget
+			val["cost_used"] = s.stats.Used
+			val["blocked_producers"] = s.stats.BlockedProducers
+			val["blocked_consumers"] = s.stats.BlockedConsumers
+			val["enqueued"] = s.stats.Enqueued
+			val["dequeued"] = s.stats.Dequeued
+			val["dropped"] = s.stats.Dropped
+			val["expired"] = s.stats.Expired
+			val["timeouts"] = s.stats.Timeouts
+			val["wait_seconds"] = s.stats.WaitTime.Seconds()
+		}
+		if s.wait != nil {
+			buckets := make(map[string]uint64, len(s.wait.buckets))
+			for i, cnt := range s.wait.buckets {
+				le := math.Inf(1)
+				if i < len(s.wait.bounds) {
+					le = s.wait.bounds[i]
+				}
+				buckets[formatFloat(le)] = cnt
+			}
+			val["dequeue_wait"] = map[string]any{
+				"count":   s.wait.count,
+				"sum":     s.wait.sum,
+				"buckets": buckets,
+			}
+		}
+		res[s.name] = val
+	}
+	return res
+}
@@ -0,0 +1,156 @@
+package metrics
+
+import (
+	"bytes"
+	"concurrent_queue"
+	"concurrent_queue/errs"
+	"context"
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRegistry_WritePrometheus(t *testing.T) {
+	reg := NewRegistryWithBuckets([]float64{0.01, 1})
+	ingest := concurrent_queue.NewArrayBlockingQueue[int](10,
+		concurrent_queue.WithObserver[int](reg.Observer("ingest")))
+	require.NoError(t, reg.Register("ingest", ingest))
+	// 没有 Observer，也就没有直方图
+	retries := concurrent_queue.NewDelayQueue[delayElem](0)
+	require.NoError(t, reg.Register("retries", retries))
+	assert.Equal(t, errs.ErrDuplicateQueue, reg.Register("ingest", ingest))
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	_, err := ingest.EnqueueAll(ctx, []int{1, 2, 3})
+	require.NoError(t, err)
+	_, err = ingest.Dequeue(ctx)
+	require.NoError(t, err)
+	go func() {
+		time.Sleep(time.Millisecond * 20)
+		_ = retries.Enqueue(ctx, delayElem{deadline: time.Now()})
+	}()
+	_, err = retries.Dequeue(ctx)
+	require.NoError(t, err)
+
+	buf := &bytes.Buffer{}
+	require.NoError(t, reg.WritePrometheus(buf))
+	res := buf.String()
+	assert.Contains(t, res, "# TYPE concurrent_queue_depth gauge\n"+
+		`concurrent_queue_depth{queue="ingest"} 2`+"\n"+
+		`concurrent_queue_depth{queue="retries"} 0`+"\n")
+	assert.Contains(t, res, `concurrent_queue_capacity{queue="ingest"} 10`+"\n")
+	assert.Contains(t, res, "# TYPE concurrent_queue_enqueued_total counter\n"+
+		`concurrent_queue_enqueued_total{queue="ingest"} 3`+"\n"+
+		`concurrent_queue_enqueued_total{queue="retries"} 1`+"\n")
+	assert.Contains(t, res, `concurrent_queue_dequeued_total{queue="ingest"} 1`+"\n")
+	assert.Contains(t, res, `concurrent_queue_timeouts_total{queue="ingest"} 0`+"\n")
+	assert.Contains(t, res, "# TYPE concurrent_queue_dequeue_wait_seconds histogram\n"+
+		`concurrent_queue_dequeue_wait_seconds_bucket{queue="ingest",le="0.01"} 1`+"\n"+
+		`concurrent_queue_dequeue_wait_seconds_bucket{queue="ingest",le="1"} 1`+"\n"+
+		`concurrent_queue_dequeue_wait_seconds_bucket{queue="ingest",le="+Inf"} 1`+"\n"+
+		`concurrent_queue_dequeue_wait_seconds_sum{queue="ingest"} 0`+"\n"+
+		`concurrent_queue_dequeue_wait_seconds_count{queue="ingest"} 1`+"\n")
+	assert.NotContains(t, res, `concurrent_queue_dequeue_wait_seconds_count{queue="retries"}`)
+
+	// 按照开销限制容量的队列，预算单独输出，不会和元素个数混在一起
+	payloads := concurrent_queue.NewWeightedBlockingQueue[string](100, func(s string) int64 {
+		return int64(len(s))
+	})
+	require.NoError(t, reg.Register("payloads", payloads))
+	require.NoError(t, payloads.Enqueue(ctx, "abcdefghij"))
+	buf.Reset()
+	require.NoError(t, reg.WritePrometheus(buf))
+	res = buf.String()
+	assert.Contains(t, res, `concurrent_queue_depth{queue="payloads"} 1`+"\n")
+	assert.Contains(t, res, `concurrent_queue_capacity{queue="payloads"} 0`+"\n")
+	assert.Contains(t, res, `concurrent_queue_cost_budget{queue="payloads"} 100`+"\n")
+	assert.Contains(t, res, `concurrent_queue_cost_used{queue="payloads"} 10`+"\n")
+	assert.Contains(t, res, `concurrent_queue_cost_budget{queue="ingest"} 0`+"\n")
+
+	reg.Unregister("retries")
+	buf.Reset()
+	require.NoError(t, reg.WritePrometheus(buf))
+	assert.NotContains(t, buf.String(), "retries")
+}
+
+func TestRegistry_ServeHTTP(t *testing.T) {
+	reg := NewRegistry()
+	q := concurrent_queue.NewLinkedBlockingQueue[int](0)
+	require.NoError(t, reg.Register(`a"b`, q))
+
+	server := httptest.NewServer(reg)
+	defer server.Close()
+	resp, err := http.Get(server.URL)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "text/plain; version=0.0.4; charset=utf-8", resp.Header.Get("Content-Type"))
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	// 标签的值需要转义
+	assert.Contains(t, string(body), `concurrent_queue_capacity{queue="a\"b"} 0`)
+}
+
+// expvarSeq 同一个名字只能发布一次，-count 大于 1 的时候每一轮都要换一个名字
+var expvarSeq int64
+
+func TestRegistry_PublishExpvar(t *testing.T) {
+	reg := NewRegistryWithBuckets([]float64{1})
+	q := concurrent_queue.NewLinkedQueue[int](concurrent_queue.WithObserver[int](reg.Observer("ingest")))
+	require.NoError(t, reg.Register("ingest", q))
+	require.NoError(t, q.Enqueue(context.Background(), 1))
+	require.NoError(t, q.Enqueue(context.Background(), 2))
+	_, err := q.Dequeue(context.Background())
+	require.NoError(t, err)
+
+	name := fmt.Sprintf("%s_%d", t.Name(), atomic.AddInt64(&expvarSeq, 1))
+	reg.PublishExpvar(name)
+	var res map[string]struct {
+		Depth       int    `json:"depth"`
+		Enqueued    uint64 `json:"enqueued"`
+		Dequeued    uint64 `json:"dequeued"`
+		DequeueWait struct {
+			Count   uint64            `json:"count"`
+			Buckets map[string]uint64 `json:"buckets"`
+		} `json:"dequeue_wait"`
+	}
+	val := expvar.Get(name).String()
+	require.NoError(t, json.Unmarshal([]byte(val), &res))
+	ingest := res["ingest"]
+	assert.Equal(t, 1, ingest.Depth)
+	assert.Equal(t, uint64(2), ingest.Enqueued)
+	assert.Equal(t, uint64(1), ingest.Dequeued)
+	assert.Equal(t, uint64(1), ingest.DequeueWait.Count)
+	assert.Equal(t, map[string]uint64{"1": 1, "+Inf": 1}, ingest.DequeueWait.Buckets)
+}
+
+func TestWaitHistogram(t *testing.T) {
+	h := newWaitHistogram([]float64{1, 0.1})
+	h.OnDequeue(time.Millisecond * 50)
+	h.OnDequeue(time.Millisecond * 100)
+	h.OnDequeue(time.Millisecond * 500)
+	h.OnDequeue(time.Second * 2)
+	res := h.snapshot()
+	// 桶会被排序，并且是累加的，刚好等于上界的落在这个桶里面
+	assert.Equal(t, []float64{0.1, 1}, res.bounds)
+	assert.Equal(t, []uint64{2, 3, 4}, res.buckets)
+	assert.Equal(t, uint64(4), res.count)
+	assert.InDelta(t, 2.65, res.sum, 1e-9)
+}
+
+type delayElem struct {
+	deadline time.Time
+}
+
+func (d delayElem) Delay() time.Duration {
+	return time.Until(d.deadline)
+}
@@ -0,0 +1,37 @@
+package concurrent_queue
+
+import "time"
+
+// Observer 观察队列中发生的事件，可以用来对接监控和链路追踪
+// 回调是同步调用的，并且有可能在队列的锁范围内调用，
+// 所以实现必须足够快，也不能在回调里面操作队列本身
+type Observer interface {
+	// OnEnqueue 一个元素入队成功
+	OnEnqueue()
+	// OnDequeue 一个元素出队成功，waited 是出队者为此阻塞的时间，没有阻塞的话为 0
+	OnDequeue(waited time.Duration)
+	// OnBlock 入队者或者出队者开始阻塞
+	OnBlock()
+	// OnTimeout 阻塞的过程中 ctx 超时或者被取消了
+	OnTimeout()
+	// OnFull 入队的时候发现队列已满
+	OnFull()
+	// OnEmpty 出队的时候发现没有可以取的元素
+	OnEmpty()
+}
+
+// NopObserver 什么也不做的 Observer，这是默认的 Observer
+// 嵌入 NopObserver 之后就只需要实现关心的方法
+type NopObserver struct{}
+
+func (NopObserver) OnEnqueue() {}
+
+func (NopObserver) OnDequeue(waited time.Duration) {}
+
+func (NopObserver) OnBlock() {}
+
+func (NopObserver) OnTimeout() {}
+
+func (NopObserver) OnFull() {}
+
+func (NopObserver) OnEmpty() {}
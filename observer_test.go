@@ -0,0 +1,201 @@
+package concurrent_queue
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestObserver_ArrayBlockingQueue(t *testing.T) {
+	o := &countingObserver{}
+	q := NewArrayBlockingQueue[int](1, WithObserver[int](o))
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	require.NoError(t, q.Enqueue(ctx, 1))
+	assert.False(t, q.TryEnqueue(2))
+	timeoutCtx, timeoutCancel := context.WithTimeout(context.Background(), time.Millisecond*50)
+	defer timeoutCancel()
+	assert.Equal(t, context.DeadlineExceeded, q.Enqueue(timeoutCtx, 2))
+	_, err := q.Dequeue(ctx)
+	require.NoError(t, err)
+	_, ok := q.TryDequeue()
+	assert.False(t, ok)
+
+	go func() {
+		time.Sleep(time.Millisecond * 50)
+		_ = q.Enqueue(ctx, 3)
+	}()
+	_, err = q.Dequeue(ctx)
+	require.NoError(t, err)
+
+	assert.Equal(t, countingObserver{
+		enqueue: 2,
+		dequeue: 2,
+		block:   2,
+		timeout: 1,
+		full:    2,
+		empty:   2,
+	}, o.snapshot())
+	assert.True(t, time.Duration(atomic.LoadInt64(&o.waited)) >= time.Millisecond*50)
+}
+
+func TestObserver_LinkedBlockingQueue(t *testing.T) {
+	o := &countingObserver{}
+	q := NewLinkedBlockingQueue[int](1, WithObserver[int](o),
+		WithOverflowPolicy[int](OverflowDropNewest))
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	require.NoError(t, q.Enqueue(ctx, 1))
+	// 被丢弃了
+	require.NoError(t, q.Enqueue(ctx, 2))
+	_, err := q.DequeueUpTo(ctx, 10)
+	require.NoError(t, err)
+	timeoutCtx, timeoutCancel := context.WithTimeout(context.Background(), time.Millisecond*50)
+	defer timeoutCancel()
+	_, err = q.Dequeue(timeoutCtx)
+	assert.Equal(t, context.DeadlineExceeded, err)
+
+	assert.Equal(t, countingObserver{
+		enqueue: 1,
+		dequeue: 1,
+		block:   1,
+		timeout: 1,
+		full:    1,
+		empty:   1,
+	}, o.snapshot())
+}
+
+func TestObserver_LinkedQueue(t *testing.T) {
+	o := &countingObserver{}
+	q := NewLinkedQueue[int](WithObserver[int](o))
+	require.NoError(t, q.Enqueue(context.Background(), 1))
+	_, err := q.Dequeue(context.Background())
+	require.NoError(t, err)
+	_, err = q.Dequeue(context.Background())
+	assert.Error(t, err)
+
+	assert.Equal(t, countingObserver{
+		enqueue: 1,
+		dequeue: 1,
+		empty:   1,
+	}, o.snapshot())
+}
+
+func TestObserver_PriorityQueue(t *testing.T) {
+	o := &countingObserver{}
+	q := NewPriorityQueue[int](1, compare(), WithObserver[int](o))
+	require.NoError(t, q.Enqueue(1))
+	assert.Error(t, q.Enqueue(2))
+	_, err := q.Dequeue()
+	require.NoError(t, err)
+	_, err = q.Dequeue()
+	assert.Error(t, err)
+
+	assert.Equal(t, countingObserver{
+		enqueue: 1,
+		dequeue: 1,
+		full:    1,
+		empty:   1,
+	}, o.snapshot())
+}
+
+func TestObserver_DelayQueue(t *testing.T) {
+	o := &countingObserver{}
+	q := NewDelayQueue[delayElem](2, WithObserver[delayElem](o))
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	require.NoError(t, q.Enqueue(ctx, delayElem{val: 1, deadline: time.Now().Add(time.Millisecond * 50)}))
+	// 还没到期
+	_, ok := q.TryDequeue()
+	assert.False(t, ok)
+	_, err := q.Dequeue(ctx)
+	require.NoError(t, err)
+
+	res := o.snapshot()
+	assert.Equal(t, countingObserver{
+		enqueue: 1,
+		dequeue: 1,
+		block:   1,
+		empty:   2,
+	}, res)
+	assert.True(t, time.Duration(atomic.LoadInt64(&o.waited)) > 0)
+}
+
+func TestObserver_ArrayBlockingQueueV2(t *testing.T) {
+	o := &countingObserver{}
+	q := NewArrayBlockingQueueV2[int](1)
+	// V2 不支持 Option，直接替换掉统计
+	q.stats = newQueueStats(1, o)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	go func() {
+		time.Sleep(time.Millisecond * 50)
+		_ = q.Enqueue(ctx, 1)
+	}()
+	_, err := q.Dequeue(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), o.snapshot().dequeue)
+	assert.True(t, time.Duration(atomic.LoadInt64(&o.waited)) >= time.Millisecond*50)
+}
+
+func TestWithObserver_Nil(t *testing.T) {
+	q := NewArrayBlockingQueue[int](1, WithObserver[int](nil))
+	require.NoError(t, q.Enqueue(context.Background(), 1))
+	_, err := q.Dequeue(context.Background())
+	require.NoError(t, err)
+}
+
+// countingObserver 记录各个回调被调用的次数
+type countingObserver struct {
+	enqueue int64
+	dequeue int64
+	block   int64
+	timeout int64
+	full    int64
+	empty   int64
+	waited  int64
+}
+
+func (c *countingObserver) OnEnqueue() {
+	atomic.AddInt64(&c.enqueue, 1)
+}
+
+func (c *countingObserver) OnDequeue(waited time.Duration) {
+	atomic.AddInt64(&c.dequeue, 1)
+	atomic.AddInt64(&c.waited, int64(waited))
+}
+
+func (c *countingObserver) OnBlock() {
+	atomic.AddInt64(&c.block, 1)
+}
+
+func (c *countingObserver) OnTimeout() {
+	atomic.AddInt64(&c.timeout, 1)
+}
+
+func (c *countingObserver) OnFull() {
+	atomic.AddInt64(&c.full, 1)
+}
+
+func (c *countingObserver) OnEmpty() {
+	atomic.AddInt64(&c.empty, 1)
+}
+
+// snapshot 返回除了 waited 之外的计数，方便断言
+func (c *countingObserver) snapshot() countingObserver {
+	return countingObserver{
+		enqueue: atomic.LoadInt64(&c.enqueue),
+		dequeue: atomic.LoadInt64(&c.dequeue),
+		block:   atomic.LoadInt64(&c.block),
+		timeout: atomic.LoadInt64(&c.timeout),
+		full:    atomic.LoadInt64(&c.full),
+		empty:   atomic.LoadInt64(&c.empty),
+	}
+}
@@ -0,0 +1,129 @@
+package concurrent_queue
+
+import "time"
+
+// Option 创建队列时候的可选配置
+// 并不是所有的队列都支持所有的配置，队列不支持的配置会被忽略，具体参考各个队列的构造函数
+type Option[T any] func(opts *queueOptions[T])
+
+type queueOptions[T any] struct {
+	overflow OverflowPolicy
+	onDrop   func(T)
+	observer Observer
+	sojourn  bool
+
+	ttl      time.Duration
+	onExpire func(T)
+	sweep    time.Duration
+
+	maxReceives int
+	deadLetter  BlockingQueue[DeadLetter[T]]
+
+	clock Clock
+}
+
+func newQueueOptions[T any](opts []Option[T]) *queueOptions[T] {
+	res := &queueOptions[T]{
+		overflow: OverflowBlock,
+		observer: NopObserver{},
+		clock:    systemClock{},
+	}
+	for _, opt := range opts {
+		opt(res)
+	}
+	return res
+}
+
+// OverflowPolicy 有界队列满了之后，入队的处理策略
+type OverflowPolicy int
+
+const (
+	// OverflowBlock 阻塞入队者，直到队列有空位或者 ctx 超时，这是默认的策略
+	OverflowBlock OverflowPolicy = iota
+	// OverflowReject 立刻返回 errs.ErrOutOfCapacity
+	OverflowReject
+	// OverflowDropOldest 丢弃队首的元素，然后放入新元素
+	// ArrayBlockingQueue 中的元素都已经被出队者预定了的时候，丢弃的是新元素，入队者永远不会阻塞
+	OverflowDropOldest
+	// OverflowDropNewest 丢弃新元素，入队返回 nil
+	OverflowDropNewest
+)
+
+// WithOverflowPolicy 设置队列满了之后的处理策略
+func WithOverflowPolicy[T any](policy OverflowPolicy) Option[T] {
+	return func(opts *queueOptions[T]) {
+		opts.overflow = policy
+	}
+}
+
+// WithDropCallback 设置元素被丢弃时候的回调，可以用来统计或者记录被丢弃的元素
+// 回调是在锁之外调用的，可以在回调里面操作队列
+func WithDropCallback[T any](fn func(t T)) Option[T] {
+	return func(opts *queueOptions[T]) {
+		opts.onDrop = fn
+	}
+}
+
+// WithObserver 设置队列的 Observer，传入 nil 等同于不设置
+func WithObserver[T any](observer Observer) Option[T] {
+	return func(opts *queueOptions[T]) {
+		if observer != nil {
+			opts.observer = observer
+		}
+	}
+}
+
+// WithSojournTracking 记录每个元素的入队时间
+// 开启之后可以通过 DequeueWithMeta 拿到元素在队列中停留的时间，通过 OldestAge 拿到队首元素的年龄
+func WithSojournTracking[T any]() Option[T] {
+	return func(opts *queueOptions[T]) {
+		opts.sojourn = true
+	}
+}
+
+// WithTTL 元素在队列中最多停留 ttl 这么长时间，过期的元素在出队的时候会被丢弃
+// TTL 依赖于入队时间，所以会同时开启 WithSojournTracking
+func WithTTL[T any](ttl time.Duration) Option[T] {
+	return func(opts *queueOptions[T]) {
+		opts.ttl = ttl
+		if ttl > 0 {
+			opts.sojourn = true
+		}
+	}
+}
+
+// WithExpireCallback 设置元素过期被丢弃时候的回调，可以用来把过期的元素转移到别的地方
+// 回调是在锁之外调用的，可以在回调里面操作队列
+func WithExpireCallback[T any](fn func(t T)) Option[T] {
+	return func(opts *queueOptions[T]) {
+		opts.onExpire = fn
+	}
+}
+
+// WithExpirySweep 启动一个后台 goroutine，每隔 interval 清理一次过期的元素
+// 这样即便没有人出队，过期的元素也会被及时清理掉，释放容量。goroutine 会在队列关闭的时候退出
+func WithExpirySweep[T any](interval time.Duration) Option[T] {
+	return func(opts *queueOptions[T]) {
+		opts.sweep = interval
+	}
+}
+
+// WithDeadLetterQueue 元素被接收了 maxReceives 次依旧处理失败的时候，把它连同失败的原因一起放入 dlq
+// 放入 dlq 不会阻塞，dlq 满了的时候元素会被放回队列，下一次失败的时候再试。
+// 只对 ReliableQueue 生效
+func WithDeadLetterQueue[T any](dlq BlockingQueue[DeadLetter[T]], maxReceives int) Option[T] {
+	return func(opts *queueOptions[T]) {
+		opts.deadLetter = dlq
+		opts.maxReceives = maxReceives
+	}
+}
+
+// WithClock 使用 clock 来获取当前时间和创建定时器，默认使用 SystemClock
+// 测试的时候可以传入 ManualClock 来控制时间，nil 会被忽略
+func WithClock[T any](clock Clock) Option[T] {
+	return func(opts *queueOptions[T]) {
+		if clock != nil {
+			opts.clock = clock
+		}
+	}
+}
@@ -0,0 +1,263 @@
+package concurrent_queue
+
+import (
+	"concurrent_queue/errs"
+	"context"
+	"sync"
+	"time"
+)
+
+// PriorityBlockingQueue 基于 PriorityQueue 的并发安全的阻塞优先队列
+// 每次出队的都是 compare 意义上最小的元素，优先级相同的元素之间不保证顺序
+type PriorityBlockingQueue[T any] struct {
+	mutex *sync.Mutex
+	pq    *PriorityQueue[T]
+
+	notEmpty *cond
+	notFull  *cond
+
+	// 队列是否已经关闭
+	closed bool
+}
+
+// NewPriorityBlockingQueue 创建阻塞优先队列
+// capacity <= 0 时，为无界队列，入队永远不会阻塞，底层切片会动态扩缩容
+// 支持的 Option：WithObserver, WithSojournTracking
+func NewPriorityBlockingQueue[T any](capacity int, compare Comparator[T], opts ...Option[T]) *PriorityBlockingQueue[T] {
+	m := &sync.Mutex{}
+	return &PriorityBlockingQueue[T]{
+		mutex:    m,
+		pq:       NewPriorityQueue[T](capacity, compare, opts...),
+		notEmpty: newCond(m),
+		notFull:  newCond(m),
+	}
+}
+
+// Enqueue 入队，队列满了的时候会阻塞直到有空位或者 ctx 超时
+// 队列已经关闭的时候返回 errs.ErrQueueClosed
+func (q *PriorityBlockingQueue[T]) Enqueue(ctx context.Context, t T) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	q.mutex.Lock()
+	w := q.pq.stats.producerWaiter()
+	if err := q.waitNotFull(ctx, &w); err != nil {
+		return err
+	}
+	if q.closed {
+		q.mutex.Unlock()
+		return errs.ErrQueueClosed
+	}
+	err := q.pq.Enqueue(t)
+	// 这里会释放锁
+	q.notEmpty.broadcast()
+	return err
+}
+
+// waitNotFull 阻塞直到队列不满或者队列已经关闭
+// 必须在锁范围内调用。返回 nil 的时候依旧持有锁，返回 error 的时候已经释放了锁
+func (q *PriorityBlockingQueue[T]) waitNotFull(ctx context.Context, w *waiter) (err error) {
+	defer w.done(&err)
+	for !q.closed && q.pq.IsFull() {
+		w.wait()
+		signal := q.notFull.signalCh()
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-signal:
+			q.mutex.Lock()
+		}
+	}
+	return nil
+}
+
+// waitNotEmpty 阻塞直到队列不为空，队列关闭并且为空的时候返回 errs.ErrQueueClosed
+// 必须在锁范围内调用。返回 nil 的时候依旧持有锁，返回 error 的时候已经释放了锁
+// 返回之后可以通过 w.waited 拿到阻塞的时间
+func (q *PriorityBlockingQueue[T]) waitNotEmpty(ctx context.Context, w *waiter) (err error) {
+	defer w.done(&err)
+	for q.pq.IsEmpty() {
+		// 队列关闭了，并且元素已经被取完
+		if q.closed {
+			q.mutex.Unlock()
+			return errs.ErrQueueClosed
+		}
+		w.wait()
+		signal := q.notEmpty.signalCh()
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-signal:
+			q.mutex.Lock()
+		}
+	}
+	return nil
+}
+
+// Dequeue 取出优先级最高的元素，队列为空的时候会阻塞直到有元素入队或者 ctx 超时
+// 队列关闭并且元素已经被取完的时候返回 errs.ErrQueueClosed
+func (q *PriorityBlockingQueue[T]) Dequeue(ctx context.Context) (T, error) {
+	val, _, err := q.DequeueWithMeta(ctx)
+	return val, err
+}
+
+// DequeueWithMeta 和 Dequeue 一样，但是会额外返回元素的 Meta
+// 没有开启 WithSojournTracking 的时候 Meta 为零值
+func (q *PriorityBlockingQueue[T]) DequeueWithMeta(ctx context.Context) (T, Meta, error) {
+	if ctx.Err() != nil {
+		var t T
+		return t, Meta{}, ctx.Err()
+	}
+	q.mutex.Lock()
+	w := q.pq.stats.consumerWaiter()
+	if err := q.waitNotEmpty(ctx, &w); err != nil {
+		var t T
+		return t, Meta{}, err
+	}
+	t, meta := q.take(w.waited)
+	// 这里会释放锁
+	q.notFull.broadcast()
+	return t, meta, nil
+}
+
+// take 取出堆顶元素，调用者需要持有锁并且保证队列不为空
+func (q *PriorityBlockingQueue[T]) take(waited time.Duration) (T, Meta) {
+	var meta Meta
+	if q.pq.enqueuedAt != nil {
+		meta = newMeta(q.pq.enqueuedAt[1])
+	}
+	t, _ := q.pq.dequeue()
+	q.pq.stats.dequeue(1, waited)
+	return t, meta
+}
+
+// TryEnqueue 尝试入队，不会阻塞
+// 队列已满或者已经关闭的时候返回 false
+func (q *PriorityBlockingQueue[T]) TryEnqueue(t T) bool {
+	return q.tryEnqueue(t) == nil
+}
+
+func (q *PriorityBlockingQueue[T]) tryEnqueue(t T) error {
+	q.mutex.Lock()
+	if q.closed {
+		q.mutex.Unlock()
+		return errs.ErrQueueClosed
+	}
+	if err := q.pq.Enqueue(t); err != nil {
+		q.mutex.Unlock()
+		return err
+	}
+	// 这里会释放锁
+	q.notEmpty.broadcast()
+	return nil
+}
+
+// TryDequeue 尝试出队，不会阻塞
+// 队列为空的时候返回 false
+func (q *PriorityBlockingQueue[T]) TryDequeue() (T, bool) {
+	t, err := q.tryDequeue()
+	return t, err == nil
+}
+
+func (q *PriorityBlockingQueue[T]) tryDequeue() (T, error) {
+	q.mutex.Lock()
+	if q.pq.IsEmpty() {
+		closed := q.closed
+		q.mutex.Unlock()
+		var t T
+		if closed {
+			return t, errs.ErrQueueClosed
+		}
+		q.pq.stats.empty()
+		return t, errs.ErrEmptyQueue
+	}
+	t, _ := q.take(0)
+	// 这里会释放锁
+	q.notFull.broadcast()
+	return t, nil
+}
+
+// Offer 在 timeout 内将元素放入队列
+// timeout <= 0 的时候不会阻塞，队列已满则返回 errs.ErrOutOfCapacity；
+// 否则会一直等待，超时返回 context.DeadlineExceeded
+func (q *PriorityBlockingQueue[T]) Offer(t T, timeout time.Duration) error {
+	if timeout <= 0 {
+		return q.tryEnqueue(t)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return q.Enqueue(ctx, t)
+}
+
+// Poll 在 timeout 内获得优先级最高的元素
+// timeout <= 0 的时候不会阻塞，队列为空则返回 errs.ErrEmptyQueue；
+// 否则会一直等待，超时返回 context.DeadlineExceeded
+func (q *PriorityBlockingQueue[T]) Poll(timeout time.Duration) (T, error) {
+	if timeout <= 0 {
+		return q.tryDequeue()
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return q.Dequeue(ctx)
+}
+
+// Peek 返回优先级最高的元素，但是不会将其取出
+// 队列为空的时候返回 errs.ErrEmptyQueue
+func (q *PriorityBlockingQueue[T]) Peek() (T, error) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	return q.pq.Peek()
+}
+
+// OldestAge 返回堆顶元素在队列中已经停留的时间
+// 注意堆顶元素并不一定是最早入队的元素
+// 队列为空或者没有开启 WithSojournTracking 的时候返回 0
+func (q *PriorityBlockingQueue[T]) OldestAge() time.Duration {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	return q.pq.OldestAge()
+}
+
+// Close 关闭队列
+// 关闭之后入队会返回 errs.ErrQueueClosed，出队在取完剩余的元素之后返回 errs.ErrQueueClosed
+func (q *PriorityBlockingQueue[T]) Close() error {
+	q.mutex.Lock()
+	if q.closed {
+		q.mutex.Unlock()
+		return nil
+	}
+	q.closed = true
+	// 唤醒所有阻塞的入队者和出队者，broadcast 会释放锁
+	q.notFull.broadcast()
+	q.mutex.Lock()
+	q.notEmpty.broadcast()
+	return nil
+}
+
+// Stats 返回统计信息的快照，不需要加锁
+func (q *PriorityBlockingQueue[T]) Stats() Stats {
+	return q.pq.Stats()
+}
+
+func (q *PriorityBlockingQueue[T]) Len() int {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	return q.pq.Len()
+}
+
+func (q *PriorityBlockingQueue[T]) IsEmpty() bool {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	return q.pq.IsEmpty()
+}
+
+func (q *PriorityBlockingQueue[T]) IsFull() bool {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	return q.pq.IsFull()
+}
+
+// Cap 队列的容量，无界队列返回 0
+func (q *PriorityBlockingQueue[T]) Cap() int {
+	return q.pq.Cap()
+}
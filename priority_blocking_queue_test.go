@@ -0,0 +1,284 @@
+package concurrent_queue
+
+import (
+	"concurrent_queue/errs"
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"math/rand"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestPriorityBlockingQueue_Enqueue(t *testing.T) {
+	t.Parallel()
+	testCases := []struct {
+		name     string
+		capacity int
+		data     []int
+		timeout  time.Duration
+		val      int
+		wantErr  error
+		wantLen  int
+	}{
+		{
+			name:     "bounded",
+			capacity: 3,
+			data:     []int{3, 1},
+			timeout:  time.Second,
+			val:      2,
+			wantLen:  3,
+		},
+		{
+			name:     "bounded full",
+			capacity: 3,
+			data:     []int{3, 1, 2},
+			timeout:  time.Millisecond * 50,
+			val:      4,
+			wantErr:  context.DeadlineExceeded,
+			wantLen:  3,
+		},
+		{
+			name:    "unbounded",
+			data:    []int{3, 1, 2},
+			timeout: time.Second,
+			val:     4,
+			wantLen: 4,
+		},
+		{
+			name:     "invalid context",
+			capacity: 3,
+			timeout:  -time.Second,
+			val:      1,
+			wantErr:  context.DeadlineExceeded,
+		},
+	}
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			q := NewPriorityBlockingQueue[int](tc.capacity, ComparatorRealNumber[int])
+			for _, d := range tc.data {
+				require.NoError(t, q.Enqueue(context.Background(), d))
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), tc.timeout)
+			defer cancel()
+			err := q.Enqueue(ctx, tc.val)
+			assert.Equal(t, tc.wantErr, err)
+			assert.Equal(t, tc.wantLen, q.Len())
+		})
+	}
+
+	// 入队阻塞，然后出队，然后入队成功
+	t.Run("enqueue blocking and dequeue", func(t *testing.T) {
+		t.Parallel()
+		q := NewPriorityBlockingQueue[int](1, ComparatorRealNumber[int])
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		require.NoError(t, q.Enqueue(ctx, 123))
+		go func() {
+			time.Sleep(time.Millisecond * 100)
+			_, _ = q.Dequeue(ctx)
+		}()
+		require.NoError(t, q.Enqueue(ctx, 234))
+		val, err := q.Dequeue(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, 234, val)
+	})
+}
+
+func TestPriorityBlockingQueue_Dequeue(t *testing.T) {
+	t.Parallel()
+	q := NewPriorityBlockingQueue[int](0, ComparatorRealNumber[int])
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	for _, d := range []int{5, 3, 8, 1, 4} {
+		require.NoError(t, q.Enqueue(ctx, d))
+	}
+	top, err := q.Peek()
+	require.NoError(t, err)
+	assert.Equal(t, 1, top)
+	res := make([]int, 0, 5)
+	for i := 0; i < 5; i++ {
+		val, err := q.Dequeue(ctx)
+		require.NoError(t, err)
+		res = append(res, val)
+	}
+	assert.Equal(t, []int{1, 3, 4, 5, 8}, res)
+	assert.True(t, q.IsEmpty())
+
+	t.Run("dequeue timeout", func(t *testing.T) {
+		t.Parallel()
+		q := NewPriorityBlockingQueue[int](3, ComparatorRealNumber[int])
+		ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*50)
+		defer cancel()
+		val, err := q.Dequeue(ctx)
+		assert.Equal(t, context.DeadlineExceeded, err)
+		assert.Equal(t, 0, val)
+		assert.Equal(t, 1, int(q.Stats().Timeouts))
+	})
+
+	// 出队阻塞，然后入队，然后出队成功
+	t.Run("dequeue blocking and enqueue", func(t *testing.T) {
+		t.Parallel()
+		q := NewPriorityBlockingQueue[int](3, ComparatorRealNumber[int])
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		go func() {
+			time.Sleep(time.Millisecond * 100)
+			_ = q.Enqueue(ctx, 123)
+		}()
+		val, err := q.Dequeue(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, 123, val)
+		assert.True(t, q.Stats().WaitTime > 0)
+	})
+}
+
+func TestPriorityBlockingQueue_Shrink(t *testing.T) {
+	t.Parallel()
+	q := NewPriorityBlockingQueue[int](0, ComparatorRealNumber[int])
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	for i := 0; i < 1000; i++ {
+		require.NoError(t, q.Enqueue(ctx, i))
+	}
+	grown := cap(q.pq.data)
+	for i := 0; i < 990; i++ {
+		_, err := q.Dequeue(ctx)
+		require.NoError(t, err)
+	}
+	// 无界队列出队之后会缩容
+	assert.Less(t, cap(q.pq.data), grown)
+	assert.Equal(t, 10, q.Len())
+	assert.Equal(t, 0, q.Cap())
+}
+
+func TestPriorityBlockingQueue_TryEnqueueAndTryDequeue(t *testing.T) {
+	t.Parallel()
+	q := NewPriorityBlockingQueue[int](2, ComparatorRealNumber[int])
+	_, ok := q.TryDequeue()
+	assert.False(t, ok)
+	assert.True(t, q.TryEnqueue(2))
+	assert.True(t, q.TryEnqueue(1))
+	assert.True(t, q.IsFull())
+	assert.False(t, q.TryEnqueue(3))
+	assert.Equal(t, errs.ErrOutOfCapacity, q.Offer(3, 0))
+	assert.Equal(t, context.DeadlineExceeded, q.Offer(3, time.Millisecond*10))
+
+	val, ok := q.TryDequeue()
+	assert.True(t, ok)
+	assert.Equal(t, 1, val)
+	val, err := q.Poll(0)
+	require.NoError(t, err)
+	assert.Equal(t, 2, val)
+	_, err = q.Poll(0)
+	assert.Equal(t, errs.ErrEmptyQueue, err)
+	_, err = q.Poll(time.Millisecond * 10)
+	assert.Equal(t, context.DeadlineExceeded, err)
+}
+
+func TestPriorityBlockingQueue_DequeueWithMeta(t *testing.T) {
+	t.Parallel()
+	q := NewPriorityBlockingQueue[int](0, ComparatorRealNumber[int], WithSojournTracking[int]())
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	assert.Equal(t, time.Duration(0), q.OldestAge())
+	require.NoError(t, q.Enqueue(ctx, 2))
+	time.Sleep(time.Millisecond * 20)
+	require.NoError(t, q.Enqueue(ctx, 1))
+	// 堆顶是后入队的元素
+	assert.True(t, q.OldestAge() < time.Millisecond*20)
+
+	val, meta, err := q.DequeueWithMeta(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, val)
+	assert.False(t, meta.EnqueuedAt.IsZero())
+	val, meta, err = q.DequeueWithMeta(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 2, val)
+	assert.True(t, meta.Sojourn >= time.Millisecond*20)
+}
+
+func TestPriorityBlockingQueue_Close(t *testing.T) {
+	t.Parallel()
+	// 关闭之后，入队失败，剩余的元素依旧可以取出来
+	t.Run("drain after close", func(t *testing.T) {
+		t.Parallel()
+		q := NewPriorityBlockingQueue[int](3, ComparatorRealNumber[int])
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		require.NoError(t, q.Enqueue(ctx, 234))
+		require.NoError(t, q.Enqueue(ctx, 123))
+		require.NoError(t, q.Close())
+		// 重复关闭
+		require.NoError(t, q.Close())
+
+		assert.Equal(t, errs.ErrQueueClosed, q.Enqueue(ctx, 345))
+		assert.False(t, q.TryEnqueue(345))
+		val, err := q.Dequeue(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, 123, val)
+		val, err = q.Dequeue(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, 234, val)
+		_, err = q.Dequeue(ctx)
+		assert.Equal(t, errs.ErrQueueClosed, err)
+		_, err = q.Poll(0)
+		assert.Equal(t, errs.ErrQueueClosed, err)
+	})
+
+	t.Run("wake up blocked producer", func(t *testing.T) {
+		t.Parallel()
+		q := NewPriorityBlockingQueue[int](1, ComparatorRealNumber[int])
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+		defer cancel()
+		require.NoError(t, q.Enqueue(ctx, 123))
+		go func() {
+			time.Sleep(time.Millisecond * 100)
+			_ = q.Close()
+		}()
+		assert.Equal(t, errs.ErrQueueClosed, q.Enqueue(ctx, 234))
+		assert.Nil(t, ctx.Err())
+		assert.Equal(t, 1, q.Len())
+	})
+
+	t.Run("wake up blocked consumer", func(t *testing.T) {
+		t.Parallel()
+		q := NewPriorityBlockingQueue[int](1, ComparatorRealNumber[int])
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+		defer cancel()
+		go func() {
+			time.Sleep(time.Millisecond * 100)
+			_ = q.Close()
+		}()
+		_, err := q.Dequeue(ctx)
+		assert.Equal(t, errs.ErrQueueClosed, err)
+		assert.Nil(t, ctx.Err())
+	})
+}
+
+func TestPriorityBlockingQueue(t *testing.T) {
+	t.Parallel()
+	// 并发测试，只是测试有没有死锁之类的问题
+	q := NewPriorityBlockingQueue[int](100, ComparatorRealNumber[int])
+	var wg sync.WaitGroup
+	wg.Add(1000)
+	for i := 0; i < 1000; i++ {
+		go func() {
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+			defer cancel()
+			assert.NoError(t, q.Enqueue(ctx, rand.Int()))
+		}()
+		go func() {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+			defer cancel()
+			_, err := q.Dequeue(ctx)
+			assert.NoError(t, err)
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, 0, q.Len())
+}
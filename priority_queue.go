@@ -1,6 +1,9 @@
 package concurrent_queue
 
-import "concurrent_queue/errs"
+import (
+	"concurrent_queue/errs"
+	"time"
+)
 
 // RealNumber 实数
 // 绝大多数情况下，你都应该用这个来表达数字的含义
@@ -37,20 +40,36 @@ type PriorityQueue[T any] struct {
 	capacity int
 	// 队列中的元素，为便于计算父子节点的index，0位置留空，根节点从1开始
 	data []T
+
+	stats *queueStats
+
+	// 开启了 WithSojournTracking 的时候记录每个元素的入队时间，和 data 一一对应，否则为 nil
+	enqueuedAt []time.Time
+
+	// setIndex 元素在堆中的位置发生变化的时候被调用，元素离开堆的时候 i 为 -1
+	// 用于实现 remove 和 fix，为 nil 的时候不需要维护
+	setIndex func(t T, i int)
 }
 
 // NewPriorityQueue 创建优先队列 capacity <= 0 时，为无界队列
-func NewPriorityQueue[T any](capacity int, compare Comparator[T]) *PriorityQueue[T] {
+// 支持的 Option：WithObserver, WithSojournTracking
+func NewPriorityQueue[T any](capacity int, compare Comparator[T], opts ...Option[T]) *PriorityQueue[T] {
+	options := newQueueOptions(opts)
 	sliceCap := capacity + 1
 	if capacity < 1 {
 		capacity = 0
 		sliceCap = 64
 	}
-	return &PriorityQueue[T]{
+	res := &PriorityQueue[T]{
 		capacity: capacity,
 		compare:  compare,
 		data:     make([]T, 1, sliceCap),
+		stats:    newQueueStats(capacity, options.observer),
 	}
+	if options.sojourn {
+		res.enqueuedAt = make([]time.Time, 1, sliceCap)
+	}
+	return res
 }
 
 func (p *PriorityQueue[T]) IsBoundless() bool {
@@ -60,6 +79,9 @@ func (p *PriorityQueue[T]) IsBoundless() bool {
 func (p *PriorityQueue[T]) shrinkIfNecessary() {
 	if p.IsBoundless() {
 		p.data = Shrink[T](p.data)
+		if p.enqueuedAt != nil {
+			p.enqueuedAt = Shrink[time.Time](p.enqueuedAt)
+		}
 	}
 }
 
@@ -87,24 +109,53 @@ func (p *PriorityQueue[T]) Peek() (T, error) {
 	return p.data[1], nil
 }
 
+// OldestAge 返回堆顶元素在队列中已经停留的时间
+// 注意堆顶元素并不一定是最早入队的元素
+// 队列为空或者没有开启 WithSojournTracking 的时候返回 0
+func (p *PriorityQueue[T]) OldestAge() time.Duration {
+	if p.IsEmpty() || p.enqueuedAt == nil {
+		return 0
+	}
+	return newMeta(p.enqueuedAt[1]).Sojourn
+}
+
 func (p *PriorityQueue[T]) Enqueue(t T) error {
+	if err := p.enqueue(t); err != nil {
+		p.stats.full()
+		return err
+	}
+	p.stats.enqueue(1)
+	return nil
+}
+
+// enqueue 只负责维护堆，不记录统计信息
+func (p *PriorityQueue[T]) enqueue(t T) error {
 	if p.IsFull() {
 		return errs.ErrOutOfCapacity
 	}
 
 	p.data = append(p.data, t)
-	node, parent := len(p.data)-1, (len(p.data)-1)/2
+	if p.enqueuedAt != nil {
+		p.enqueuedAt = append(p.enqueuedAt, time.Now())
+	}
+	p.moved(len(p.data) - 1)
+	p.up(len(p.data) - 1)
+	return nil
+}
+
+// up 上浮节点，返回节点最终的位置
+func (p *PriorityQueue[T]) up(node int) int {
+	parent := node / 2
 	for parent > 0 && p.compare(p.data[node], p.data[parent]) < 0 {
-		p.data[parent], p.data[node] = p.data[node], p.data[parent]
+		p.swap(parent, node)
 		node = parent
 		parent = parent / 2
 	}
-
-	return nil
+	return node
 }
 
-// heapify 下沉节点
-func (p *PriorityQueue[T]) heapify(data []T, n, i int) {
+// heapify 下沉节点，返回节点最终的位置
+func (p *PriorityQueue[T]) heapify(data []T, n, i int) int {
 	minPos := i
 	for {
 		if left := i * 2; left <= n && p.compare(data[left], data[minPos]) < 0 {
@@ -114,24 +165,99 @@ func (p *PriorityQueue[T]) heapify(data []T, n, i int) {
 			minPos = right
 		}
 		if minPos == i {
-			break
+			return i
 		}
-		data[i], data[minPos] = data[minPos], data[i]
+		p.swap(i, minPos)
 		i = minPos
 	}
 }
 
+// swap 交换两个元素，入队时间也要跟着交换
+func (p *PriorityQueue[T]) swap(i, j int) {
+	p.data[i], p.data[j] = p.data[j], p.data[i]
+	if p.enqueuedAt != nil {
+		p.enqueuedAt[i], p.enqueuedAt[j] = p.enqueuedAt[j], p.enqueuedAt[i]
+	}
+	p.moved(i)
+	p.moved(j)
+}
+
+// moved 通知 setIndex 位置 i 上的元素的新位置
+func (p *PriorityQueue[T]) moved(i int) {
+	if p.setIndex != nil {
+		p.setIndex(p.data[i], i)
+	}
+}
+
 func (p *PriorityQueue[T]) Dequeue() (T, error) {
+	t, _, err := p.DequeueWithMeta()
+	return t, err
+}
+
+// DequeueWithMeta 和 Dequeue 一样，但是会额外返回元素的 Meta
+// 没有开启 WithSojournTracking 的时候 Meta 为零值
+// PriorityQueue 本身不会阻塞，所以不需要 ctx
+func (p *PriorityQueue[T]) DequeueWithMeta() (T, Meta, error) {
+	var meta Meta
+	if p.enqueuedAt != nil && !p.IsEmpty() {
+		meta = newMeta(p.enqueuedAt[1])
+	}
+	t, err := p.dequeue()
+	if err != nil {
+		p.stats.empty()
+		return t, Meta{}, err
+	}
+	p.stats.dequeue(1, 0)
+	return t, meta, nil
+}
+
+// dequeue 只负责维护堆，不记录统计信息
+func (p *PriorityQueue[T]) dequeue() (T, error) {
 	if p.IsEmpty() {
 		var t T
 		return t, errs.ErrEmptyQueue
 	}
-	pop := p.data[1]
-	p.data[1] = p.data[len(p.data)-1]
-	p.data = p.data[:len(p.data)-1]
+	return p.remove(1), nil
+}
+
+// remove 删除位置 i 上的元素，调用者需要保证 1 <= i <= Len()
+// 时间复杂度 O(log n)
+func (p *PriorityQueue[T]) remove(i int) T {
+	pop := p.data[i]
+	last := len(p.data) - 1
+	p.data[i] = p.data[last]
+	var zero T
+	// 避免内存泄露
+	p.data[last] = zero
+	p.data = p.data[:last]
+	if p.enqueuedAt != nil {
+		p.enqueuedAt[i] = p.enqueuedAt[last]
+		p.enqueuedAt = p.enqueuedAt[:last]
+	}
+	if p.setIndex != nil {
+		p.setIndex(pop, -1)
+	}
+	if i < last {
+		p.moved(i)
+		p.fix(i)
+	}
 	p.shrinkIfNecessary()
-	p.heapify(p.data, len(p.data)-1, 1)
-	return pop, nil
+	return pop
+}
+
+// fix 位置 i 上的元素的优先级发生了变化之后，重新调整它在堆中的位置，返回它的新位置
+// 时间复杂度 O(log n)
+func (p *PriorityQueue[T]) fix(i int) int {
+	if j := p.heapify(p.data, len(p.data)-1, i); j != i {
+		return j
+	}
+	return p.up(i)
+}
+
+// Stats 返回统计信息的快照，不需要加锁
+// PriorityQueue 本身不会阻塞，所以阻塞相关的数据总是为 0
+func (p *PriorityQueue[T]) Stats() Stats {
+	return p.stats.snapshot()
 }
 
 func Shrink[T any](src []T) []T {
@@ -4,6 +4,7 @@ import (
 	"concurrent_queue/errs"
 	"github.com/stretchr/testify/require"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -403,6 +404,82 @@ func TestPriorityQueue_Peek(t *testing.T) {
 	}
 }
 
+func TestPriorityQueue_DequeueWithMeta(t *testing.T) {
+	q := NewPriorityQueue[int](0, compare(), WithSojournTracking[int]())
+	assert.Equal(t, time.Duration(0), q.OldestAge())
+	for _, el := range []int{5, 4, 3} {
+		require.NoError(t, q.Enqueue(el))
+		time.Sleep(time.Millisecond * 10)
+	}
+	require.NoError(t, q.Enqueue(6))
+	// 堆顶是 3，入队时间跟着元素走
+	age := q.OldestAge()
+	assert.True(t, age >= time.Millisecond*10 && age < time.Millisecond*25)
+
+	var prev Meta
+	for _, want := range []int{3, 4, 5} {
+		val, meta, err := q.DequeueWithMeta()
+		require.NoError(t, err)
+		assert.Equal(t, want, val)
+		if !prev.EnqueuedAt.IsZero() {
+			assert.True(t, meta.EnqueuedAt.Before(prev.EnqueuedAt))
+		}
+		prev = meta
+	}
+	val, meta, err := q.DequeueWithMeta()
+	require.NoError(t, err)
+	assert.Equal(t, 6, val)
+	assert.True(t, meta.EnqueuedAt.After(prev.EnqueuedAt))
+	_, _, err = q.DequeueWithMeta()
+	assert.Equal(t, errs.ErrEmptyQueue, err)
+}
+
+func TestPriorityQueue_RemoveAndFix(t *testing.T) {
+	type elem struct {
+		val   int
+		index int
+	}
+	pq := NewPriorityQueue[*elem](0, func(src, dst *elem) int {
+		return ComparatorRealNumber(src.val, dst.val)
+	})
+	pq.setIndex = func(e *elem, i int) {
+		e.index = i
+	}
+	elems := make([]*elem, 0, 10)
+	for _, val := range []int{5, 3, 8, 1, 9, 2, 7, 4, 6, 0} {
+		e := &elem{val: val}
+		elems = append(elems, e)
+		require.NoError(t, pq.Enqueue(e))
+	}
+	check := func() {
+		for i := 1; i <= pq.Len(); i++ {
+			assert.Equal(t, i, pq.data[i].index)
+		}
+	}
+	check()
+	// 删除中间的元素
+	removed := pq.remove(elems[2].index)
+	assert.Equal(t, 8, removed.val)
+	assert.Equal(t, -1, removed.index)
+	check()
+	// 调大和调小
+	elems[3].val = 100
+	pq.fix(elems[3].index)
+	elems[4].val = -1
+	assert.Equal(t, 1, pq.fix(elems[4].index))
+	check()
+
+	var vals []int
+	for !pq.IsEmpty() {
+		e, err := pq.Dequeue()
+		require.NoError(t, err)
+		assert.Equal(t, -1, e.index)
+		vals = append(vals, e.val)
+		check()
+	}
+	assert.Equal(t, []int{-1, 0, 2, 3, 4, 5, 6, 7, 100}, vals)
+}
+
 func TestNewPriorityQueue(t *testing.T) {
 	data := []int{6, 5, 4, 3, 2, 1}
 	testCases := []struct {
@@ -0,0 +1,359 @@
+package concurrent_queue
+
+import (
+	"concurrent_queue/errs"
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ReceiptHandle 回执，每一次 Receive 都会生成一个新的回执
+// 元素被重新投递之后，旧的回执就失效了
+type ReceiptHandle uint64
+
+// Message Receive 返回的消息
+type Message[T any] struct {
+	Val     T
+	Receipt ReceiptHandle
+	// ReceiveCount 包括这一次在内，元素一共被 Receive 了多少次
+	ReceiveCount int
+}
+
+// DeadLetter 死信队列中的元素
+type DeadLetter[T any] struct {
+	Val T
+	// ReceiveCount 元素一共被 Receive 了多少次
+	ReceiveCount int
+	// Reasons 每一次处理失败的原因，包括 Nack 传入的原因以及 errs.ErrVisibilityTimeout
+	Reasons []error
+}
+
+// ReliableQueue 至少投递一次的可靠队列
+// 通过 Receive 取走的元素在 visibility timeout 内对别的消费者不可见，
+// 消费者处理完毕之后需要调用 Ack 确认；调用 Nack 或者超时没有确认的元素会被重新放回队列。
+// 这样消费者在处理的过程中崩溃了，元素也不会丢失
+type ReliableQueue[T any] struct {
+	ready *LinkedBlockingQueue[*envelope[T]]
+
+	mutex sync.Mutex
+	// 被取走但是还没有确认的元素
+	inflight map[ReceiptHandle]inflightEntry[T]
+	// 用于实现 visibility timeout，到期之后还没有确认的元素会被重新投递
+	timeouts *DelayQueue[visibility]
+
+	visibilityTimeout time.Duration
+	nextReceipt       uint64
+
+	// 接收次数达到 maxReceives 之后依旧失败的元素会被放入 deadLetter，maxReceives <= 0 或者 deadLetter 为 nil 时不启用
+	maxReceives int
+	deadLetter  BlockingQueue[DeadLetter[T]]
+
+	// 下面三个字段受 mutex 保护
+	closed bool
+	// pending 已经或者正在放入 ready，但是还没有被 Receive 登记到 inflight 的元素个数
+	pending int
+	// busy 正在 Ack、Nack 或者重新投递的元素个数
+	busy int
+
+	// closing 在 Close 的时候被取消，用于唤醒阻塞的入队者
+	closing     context.Context
+	stopClosing context.CancelFunc
+	// 关闭并且剩余的元素都被确认之后会被取消，用于通知后台 goroutine 退出
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+type envelope[T any] struct {
+	val          T
+	receiveCount int
+	reasons      []error
+}
+
+// inflightEntry 被取走的元素，以及它在 timeouts 中的句柄
+// 确认或者 Nack 之后要把句柄对应的超时取消掉，不然 timeouts 会一直堆积到超时为止
+type inflightEntry[T any] struct {
+	env     *envelope[T]
+	timeout DelayHandle[visibility]
+}
+
+// visibility 某个回执的可见性超时时间
+type visibility struct {
+	receipt  ReceiptHandle
+	deadline time.Time
+}
+
+func (v visibility) Delay() time.Duration {
+	return time.Until(v.deadline)
+}
+
+func (v visibility) Deadline() time.Time {
+	return v.deadline
+}
+
+// NewReliableQueue 创建可靠队列
+// capacity <= 0 时，为无界队列。容量只限制等待被取走的元素，重新投递的元素不受容量限制
+// 支持的 Option：WithDeadLetterQueue
+func NewReliableQueue[T any](capacity int, visibilityTimeout time.Duration, opts ...Option[T]) *ReliableQueue[T] {
+	options := newQueueOptions(opts)
+	ctx, cancel := context.WithCancel(context.Background())
+	q := &ReliableQueue[T]{
+		ready:             NewLinkedBlockingQueue[*envelope[T]](capacity),
+		inflight:          make(map[ReceiptHandle]inflightEntry[T], 16),
+		timeouts:          NewDelayQueue[visibility](0),
+		visibilityTimeout: visibilityTimeout,
+		maxReceives:       options.maxReceives,
+		deadLetter:        options.deadLetter,
+		ctx:               ctx,
+		cancel:            cancel,
+	}
+	q.closing, q.stopClosing = context.WithCancel(context.Background())
+	go q.redeliverLoop(ctx)
+	return q
+}
+
+// Enqueue 入队，语义和 LinkedBlockingQueue.Enqueue 一样
+func (q *ReliableQueue[T]) Enqueue(ctx context.Context, t T) error {
+	return q.put(ctx, &envelope[T]{val: t})
+}
+
+// put 把元素放入 ready，关闭之后返回 errs.ErrQueueClosed
+// 队列满了的时候会阻塞，Close 会唤醒阻塞的入队者
+func (q *ReliableQueue[T]) put(ctx context.Context, env *envelope[T]) error {
+	q.mutex.Lock()
+	if q.closed {
+		q.mutex.Unlock()
+		return errs.ErrQueueClosed
+	}
+	q.pending++
+	q.mutex.Unlock()
+	err := q.enqueue(ctx, env)
+	if err != nil {
+		q.mutex.Lock()
+		q.pending--
+		q.mutex.Unlock()
+		q.stopIfDrained()
+	}
+	return err
+}
+
+func (q *ReliableQueue[T]) enqueue(ctx context.Context, env *envelope[T]) error {
+	if q.ready.TryEnqueue(env) {
+		return nil
+	}
+	enqueueCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		select {
+		case <-q.closing.Done():
+			cancel()
+		case <-enqueueCtx.Done():
+		}
+	}()
+	err := q.ready.Enqueue(enqueueCtx, env)
+	if err != nil && ctx.Err() == nil && q.closing.Err() != nil {
+		return errs.ErrQueueClosed
+	}
+	return err
+}
+
+// requeue 把处理失败的元素放回 ready，不受容量限制
+func (q *ReliableQueue[T]) requeue(env *envelope[T]) {
+	q.mutex.Lock()
+	q.pending++
+	q.mutex.Unlock()
+	q.ready.requeue(env)
+}
+
+// Receive 取走一个元素，元素在 visibility timeout 之内对别的消费者不可见
+// 超时或者队列关闭的情况下，返回值和 LinkedBlockingQueue.Dequeue 一样
+func (q *ReliableQueue[T]) Receive(ctx context.Context) (Message[T], error) {
+	env, err := q.ready.Dequeue(ctx)
+	if err != nil {
+		return Message[T]{}, err
+	}
+	receipt := ReceiptHandle(atomic.AddUint64(&q.nextReceipt, 1))
+	q.mutex.Lock()
+	q.pending--
+	env.receiveCount++
+	// 无界的延时队列，不会阻塞。在锁范围内放进去，确保 Ack 的时候一定能拿到句柄
+	h, _ := q.timeouts.EnqueueWithHandle(context.Background(), visibility{
+		receipt:  receipt,
+		deadline: time.Now().Add(q.visibilityTimeout),
+	})
+	q.inflight[receipt] = inflightEntry[T]{env: env, timeout: h}
+	q.mutex.Unlock()
+	return Message[T]{
+		Val:          env.val,
+		Receipt:      receipt,
+		ReceiveCount: env.receiveCount,
+	}, nil
+}
+
+// Ack 确认元素已经处理完毕，元素会被彻底删除
+// 回执已经失效的时候返回 errs.ErrInvalidReceipt，这时候元素可能已经被重新投递给别的消费者了
+func (q *ReliableQueue[T]) Ack(receipt ReceiptHandle) error {
+	_, err := q.take(receipt)
+	if err != nil {
+		return err
+	}
+	q.done()
+	return nil
+}
+
+// Nack 放弃处理，元素会被立刻放回队列，reasons 是处理失败的原因
+// 如果元素的接收次数已经达到了 WithDeadLetterQueue 设置的上限，那么会被放入死信队列。
+// 回执已经失效的时候返回 errs.ErrInvalidReceipt
+func (q *ReliableQueue[T]) Nack(receipt ReceiptHandle, reasons ...error) error {
+	env, err := q.take(receipt)
+	if err != nil {
+		return err
+	}
+	q.fail(env, reasons...)
+	q.done()
+	return nil
+}
+
+// fail 处理失败，要么放回队列，要么放入死信队列
+func (q *ReliableQueue[T]) fail(env *envelope[T], reasons ...error) {
+	env.reasons = append(env.reasons, reasons...)
+	if q.deadLetter == nil || q.maxReceives <= 0 || env.receiveCount < q.maxReceives {
+		q.requeue(env)
+		return
+	}
+	// 死信队列放不进去，那么只能放回来，不能丢了。下一次失败的时候会再试一次
+	if !q.tryDeadLetter(DeadLetter[T]{
+		Val:          env.val,
+		ReceiveCount: env.receiveCount,
+		Reasons:      env.reasons,
+	}) {
+		q.requeue(env)
+	}
+}
+
+// tryDeadLetter 不阻塞地把元素放入死信队列
+// fail 会在 Nack 以及 redeliverLoop 中被调用，有界的死信队列满了也不能卡住它们。
+// 死信队列没有实现 TryEnqueue 的时候，用一个已经取消了的 ctx 调用 Enqueue
+func (q *ReliableQueue[T]) tryDeadLetter(dl DeadLetter[T]) bool {
+	if tq, ok := q.deadLetter.(interface {
+		TryEnqueue(DeadLetter[T]) bool
+	}); ok {
+		return tq.TryEnqueue(dl)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	return q.deadLetter.Enqueue(ctx, dl) == nil
+}
+
+// Redrive 把死信队列中最多 max 个元素放回队列，max <= 0 的时候表示全部，返回放回去的个数
+// 放回去的元素的接收次数和失败原因会被清空。
+// 如果死信队列实现了 TryDequeue，那么死信队列为空的时候就会返回；否则会一直等到 ctx 超时
+func (q *ReliableQueue[T]) Redrive(ctx context.Context, max int) (int, error) {
+	if q.deadLetter == nil {
+		return 0, nil
+	}
+	tq, canTry := q.deadLetter.(interface {
+		TryDequeue() (DeadLetter[T], bool)
+	})
+	cnt := 0
+	for max <= 0 || cnt < max {
+		var dl DeadLetter[T]
+		if canTry {
+			var ok bool
+			if dl, ok = tq.TryDequeue(); !ok {
+				return cnt, nil
+			}
+		} else {
+			var err error
+			if dl, err = q.deadLetter.Dequeue(ctx); err != nil {
+				return cnt, err
+			}
+		}
+		if err := q.put(ctx, &envelope[T]{val: dl.Val}); err != nil {
+			// 尽量还回去，死信队列这时候可能已经被 fail 填满了，那么只能放回队列，不能丢了也不能阻塞
+			if !q.tryDeadLetter(dl) {
+				q.requeue(&envelope[T]{val: dl.Val})
+			}
+			return cnt, err
+		}
+		cnt++
+	}
+	return cnt, nil
+}
+
+// take 从 inflight 中移除回执对应的元素，同时取消它的可见性超时
+// 成功的时候，调用者处理完这个元素之后要调用 done
+func (q *ReliableQueue[T]) take(receipt ReceiptHandle) (*envelope[T], error) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	e, ok := q.inflight[receipt]
+	if !ok {
+		return nil, errs.ErrInvalidReceipt
+	}
+	delete(q.inflight, receipt)
+	q.busy++
+	// 由 redeliverLoop 调用的时候已经出队了，取消不了也没关系
+	q.timeouts.Cancel(e.timeout)
+	return e.env, nil
+}
+
+// redeliverLoop 把超时没有确认的元素放回队列
+func (q *ReliableQueue[T]) redeliverLoop(ctx context.Context) {
+	for {
+		v, err := q.timeouts.Dequeue(ctx)
+		if err != nil {
+			return
+		}
+		// 已经被确认或者 Nack 过的回执会在这里被忽略
+		env, err := q.take(v.receipt)
+		if err != nil {
+			continue
+		}
+		q.fail(env, errs.ErrVisibilityTimeout)
+		q.done()
+	}
+}
+
+// done 和 take 配对
+func (q *ReliableQueue[T]) done() {
+	q.mutex.Lock()
+	q.busy--
+	q.mutex.Unlock()
+	q.stopIfDrained()
+}
+
+// stopIfDrained 关闭之后，剩余的元素都被取走并且确认了，才停止重新投递
+// 同时关闭 ready，唤醒阻塞在 Receive 上的消费者
+func (q *ReliableQueue[T]) stopIfDrained() {
+	q.mutex.Lock()
+	drained := q.closed && q.pending == 0 && q.busy == 0 && len(q.inflight) == 0
+	q.mutex.Unlock()
+	if drained {
+		q.cancel()
+		_ = q.ready.Close()
+	}
+}
+
+// Len 等待被取走的元素个数
+func (q *ReliableQueue[T]) Len() int {
+	return q.ready.Len()
+}
+
+// InFlight 被取走但是还没有确认的元素个数
+func (q *ReliableQueue[T]) InFlight() int {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	return len(q.inflight)
+}
+
+// Close 关闭队列
+// 关闭之后入队会返回 errs.ErrQueueClosed。关闭之后依旧保证至少投递一次：
+// Nack 或者超时没有确认的元素依旧会被重新投递，所以 Receive 要等到剩余的元素都被取走并且确认了，才会返回 errs.ErrQueueClosed
+func (q *ReliableQueue[T]) Close() error {
+	q.mutex.Lock()
+	q.closed = true
+	q.mutex.Unlock()
+	q.stopClosing()
+	q.stopIfDrained()
+	return nil
+}
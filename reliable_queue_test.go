@@ -0,0 +1,279 @@
+package concurrent_queue
+
+import (
+	"concurrent_queue/errs"
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestReliableQueue_AckAndNack(t *testing.T) {
+	t.Parallel()
+	q := NewReliableQueue[int](10, time.Minute)
+	defer func() {
+		_ = q.Close()
+	}()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	require.NoError(t, q.Enqueue(ctx, 1))
+	require.NoError(t, q.Enqueue(ctx, 2))
+
+	msg, err := q.Receive(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, msg.Val)
+	assert.Equal(t, 1, msg.ReceiveCount)
+	assert.Equal(t, 1, q.InFlight())
+	assert.Equal(t, 1, q.timeouts.Len())
+	require.NoError(t, q.Ack(msg.Receipt))
+	// 确认之后，可见性超时也会被取消
+	assert.Equal(t, 0, q.timeouts.Len())
+	// 重复确认
+	assert.Equal(t, errs.ErrInvalidReceipt, q.Ack(msg.Receipt))
+	assert.Equal(t, errs.ErrInvalidReceipt, q.Nack(msg.Receipt))
+
+	msg, err = q.Receive(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 2, msg.Val)
+	assert.Equal(t, 0, q.Len())
+	require.NoError(t, q.Nack(msg.Receipt))
+	assert.Equal(t, 1, q.Len())
+	assert.Equal(t, 0, q.InFlight())
+	assert.Equal(t, 0, q.timeouts.Len())
+
+	// 重新投递之后，回执会变，接收次数会增加
+	msg2, err := q.Receive(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 2, msg2.Val)
+	assert.Equal(t, 2, msg2.ReceiveCount)
+	assert.NotEqual(t, msg.Receipt, msg2.Receipt)
+	assert.Equal(t, errs.ErrInvalidReceipt, q.Ack(msg.Receipt))
+	require.NoError(t, q.Ack(msg2.Receipt))
+	assert.Equal(t, 0, q.timeouts.Len())
+
+	timeoutCtx, timeoutCancel := context.WithTimeout(context.Background(), time.Millisecond*50)
+	defer timeoutCancel()
+	_, err = q.Receive(timeoutCtx)
+	assert.Equal(t, context.DeadlineExceeded, err)
+}
+
+func TestReliableQueue_VisibilityTimeout(t *testing.T) {
+	t.Parallel()
+	q := NewReliableQueue[int](0, time.Millisecond*50)
+	defer func() {
+		_ = q.Close()
+	}()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	require.NoError(t, q.Enqueue(ctx, 1))
+
+	msg, err := q.Receive(ctx)
+	require.NoError(t, err)
+	// 没有确认，超时之后会被重新投递
+	start := time.Now()
+	msg2, err := q.Receive(ctx)
+	require.NoError(t, err)
+	assert.True(t, time.Since(start) >= time.Millisecond*40)
+	assert.Equal(t, 1, msg2.Val)
+	assert.Equal(t, 2, msg2.ReceiveCount)
+	// 超时之后，旧的回执就失效了
+	assert.Equal(t, errs.ErrInvalidReceipt, q.Ack(msg.Receipt))
+	require.NoError(t, q.Ack(msg2.Receipt))
+
+	// 确认之后就不会再投递了
+	timeoutCtx, timeoutCancel := context.WithTimeout(context.Background(), time.Millisecond*100)
+	defer timeoutCancel()
+	_, err = q.Receive(timeoutCtx)
+	assert.Equal(t, context.DeadlineExceeded, err)
+}
+
+func TestReliableQueue_Close(t *testing.T) {
+	t.Parallel()
+	q := NewReliableQueue[int](0, time.Millisecond*50)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	require.NoError(t, q.Enqueue(ctx, 1))
+	require.NoError(t, q.Close())
+	assert.Equal(t, errs.ErrQueueClosed, q.Enqueue(ctx, 2))
+
+	// 关闭之后，超时没有确认的元素依旧会被重新投递
+	msg, err := q.Receive(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, msg.Val)
+	msg, err = q.Receive(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, msg.Val)
+	assert.Equal(t, 2, msg.ReceiveCount)
+	assert.Nil(t, q.ctx.Err())
+	require.NoError(t, q.Ack(msg.Receipt))
+	// 剩余的元素都确认了，后台 goroutine 才会退出
+	assert.NotNil(t, q.ctx.Err())
+	_, err = q.Receive(ctx)
+	assert.Equal(t, errs.ErrQueueClosed, err)
+
+	// 关闭会唤醒阻塞的入队者
+	q = NewReliableQueue[int](1, time.Minute)
+	require.NoError(t, q.Enqueue(ctx, 1))
+	go func() {
+		time.Sleep(time.Millisecond * 50)
+		_ = q.Close()
+	}()
+	assert.Equal(t, errs.ErrQueueClosed, q.Enqueue(ctx, 2))
+	assert.Nil(t, ctx.Err())
+	msg, err = q.Receive(ctx)
+	require.NoError(t, err)
+	require.NoError(t, q.Ack(msg.Receipt))
+	_, err = q.Receive(ctx)
+	assert.Equal(t, errs.ErrQueueClosed, err)
+}
+
+func TestReliableQueue_DeadLetter(t *testing.T) {
+	t.Parallel()
+	dlq := NewLinkedBlockingQueue[DeadLetter[int]](0)
+	q := NewReliableQueue[int](0, time.Millisecond*50, WithDeadLetterQueue[int](dlq, 3))
+	defer func() {
+		_ = q.Close()
+	}()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	require.NoError(t, q.Enqueue(ctx, 1))
+
+	errA, errB := errors.New("a"), errors.New("b")
+	msg, err := q.Receive(ctx)
+	require.NoError(t, err)
+	require.NoError(t, q.Nack(msg.Receipt, errA))
+	// 第二次超时没有确认
+	_, err = q.Receive(ctx)
+	require.NoError(t, err)
+	msg, err = q.Receive(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 3, msg.ReceiveCount)
+	assert.Equal(t, 0, dlq.Len())
+	require.NoError(t, q.Nack(msg.Receipt, errB))
+
+	// 达到上限之后进入死信队列，不会再投递
+	assert.Equal(t, 0, q.Len())
+	assert.Equal(t, 0, q.InFlight())
+	dl, err := dlq.Dequeue(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, DeadLetter[int]{
+		Val:          1,
+		ReceiveCount: 3,
+		Reasons:      []error{errA, errs.ErrVisibilityTimeout, errB},
+	}, dl)
+}
+
+func TestReliableQueue_DeadLetterFull(t *testing.T) {
+	t.Parallel()
+	dlq := NewArrayBlockingQueue[DeadLetter[int]](1)
+	q := NewReliableQueue[int](0, time.Minute, WithDeadLetterQueue[int](dlq, 1))
+	defer func() {
+		_ = q.Close()
+	}()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	require.NoError(t, q.Enqueue(ctx, 1))
+	require.NoError(t, q.Enqueue(ctx, 2))
+	for i := 0; i < 2; i++ {
+		msg, err := q.Receive(ctx)
+		require.NoError(t, err)
+		// 死信队列满了也不会阻塞 Nack
+		require.NoError(t, q.Nack(msg.Receipt))
+	}
+	assert.Equal(t, 1, dlq.Len())
+	// 放不进死信队列的元素会被放回来，不会丢
+	assert.Equal(t, 1, q.Len())
+
+	_, err := dlq.Dequeue(ctx)
+	require.NoError(t, err)
+	msg, err := q.Receive(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 2, msg.Val)
+	require.NoError(t, q.Nack(msg.Receipt))
+	assert.Equal(t, 1, dlq.Len())
+	assert.Equal(t, 0, q.Len())
+}
+
+// blockingOnly 隐藏掉 TryEnqueue 和 TryDequeue
+type blockingOnly[T any] struct {
+	BlockingQueue[T]
+}
+
+func TestReliableQueue_RedriveFailed(t *testing.T) {
+	t.Parallel()
+	dlq := NewLinkedBlockingQueue[DeadLetter[int]](0)
+	q := NewReliableQueue[int](1, time.Minute,
+		WithDeadLetterQueue[int](blockingOnly[DeadLetter[int]]{BlockingQueue: dlq}, 1))
+	defer func() {
+		_ = q.Close()
+	}()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	require.NoError(t, dlq.Enqueue(ctx, DeadLetter[int]{Val: 1, ReceiveCount: 1}))
+	require.NoError(t, q.Enqueue(ctx, 2))
+
+	// 队列满了放不回去，死信队列也放不回去，那么只能放回队列，不会丢也不会阻塞
+	redriveCtx, redriveCancel := context.WithTimeout(ctx, time.Millisecond*50)
+	defer redriveCancel()
+	cnt, err := q.Redrive(redriveCtx, 0)
+	assert.Equal(t, context.DeadlineExceeded, err)
+	assert.Equal(t, 0, cnt)
+	assert.Equal(t, 0, dlq.Len())
+	assert.Equal(t, 2, q.Len())
+	for _, want := range []int{2, 1} {
+		msg, err := q.Receive(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, want, msg.Val)
+		assert.Equal(t, 1, msg.ReceiveCount)
+		require.NoError(t, q.Ack(msg.Receipt))
+	}
+}
+
+func TestReliableQueue_Redrive(t *testing.T) {
+	t.Parallel()
+	dlq := NewLinkedBlockingQueue[DeadLetter[int]](0)
+	q := NewReliableQueue[int](0, time.Minute, WithDeadLetterQueue[int](dlq, 1))
+	defer func() {
+		_ = q.Close()
+	}()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	for i := 0; i < 3; i++ {
+		require.NoError(t, q.Enqueue(ctx, i))
+		msg, err := q.Receive(ctx)
+		require.NoError(t, err)
+		require.NoError(t, q.Nack(msg.Receipt))
+	}
+	assert.Equal(t, 3, dlq.Len())
+
+	cnt, err := q.Redrive(ctx, 2)
+	require.NoError(t, err)
+	assert.Equal(t, 2, cnt)
+	assert.Equal(t, 1, dlq.Len())
+	assert.Equal(t, 2, q.Len())
+	// max <= 0 表示全部
+	cnt, err = q.Redrive(ctx, 0)
+	require.NoError(t, err)
+	assert.Equal(t, 1, cnt)
+	assert.Equal(t, 0, dlq.Len())
+
+	// 放回去之后接收次数会被重置
+	for i := 0; i < 3; i++ {
+		msg, err := q.Receive(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, i, msg.Val)
+		assert.Equal(t, 1, msg.ReceiveCount)
+		require.NoError(t, q.Ack(msg.Receipt))
+	}
+
+	// 没有死信队列
+	q2 := NewReliableQueue[int](0, time.Minute)
+	defer func() {
+		_ = q2.Close()
+	}()
+	cnt, err = q2.Redrive(ctx, 0)
+	require.NoError(t, err)
+	assert.Equal(t, 0, cnt)
+}
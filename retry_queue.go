@@ -0,0 +1,115 @@
+package concurrent_queue
+
+import (
+	"concurrent_queue/errs"
+	"context"
+	"time"
+)
+
+// RetryPolicy 重试策略
+type RetryPolicy struct {
+	// Backoff 退避策略，为 nil 的时候不等待，立刻重试
+	Backoff Backoff
+	// MaxDelay 每一次重试最多等待多长时间，<= 0 的时候不限制
+	MaxDelay time.Duration
+	// MaxAttempts 最多重试多少次，<= 0 的时候不限制
+	MaxAttempts int
+}
+
+// delay 第 attempt 次重试之前需要等待的时间
+func (p RetryPolicy) delay(attempt int) time.Duration {
+	if p.Backoff == nil {
+		return 0
+	}
+	res := p.Backoff.Next(attempt)
+	if p.MaxDelay > 0 && res > p.MaxDelay {
+		res = p.MaxDelay
+	}
+	return res
+}
+
+// RetryQueue 重试队列
+// 元素可以是任意类型，不需要实现 Delayable。
+// 通过 Retry 放入的元素会按照 RetryPolicy 等待一段时间之后才能被 Dequeue 取走
+type RetryQueue[T any] struct {
+	delay  *DelayQueue[retryItem[T]]
+	policy RetryPolicy
+	clock  Clock
+}
+
+type retryItem[T any] struct {
+	val      T
+	attempt  int
+	deadline time.Time
+}
+
+func (r retryItem[T]) Delay() time.Duration {
+	return time.Until(r.deadline)
+}
+
+func (r retryItem[T]) Deadline() time.Time {
+	return r.deadline
+}
+
+// NewRetryQueue 创建重试队列
+// capacity <= 0 时，为无界队列
+// 支持的 Option：WithObserver, WithClock
+func NewRetryQueue[T any](capacity int, policy RetryPolicy, opts ...Option[T]) *RetryQueue[T] {
+	options := newQueueOptions(opts)
+	return &RetryQueue[T]{
+		delay: NewDelayQueue[retryItem[T]](capacity,
+			WithObserver[retryItem[T]](options.observer),
+			WithClock[retryItem[T]](options.clock)),
+		policy: policy,
+		clock:  options.clock,
+	}
+}
+
+// Retry 安排第 attempt 次重试，attempt 从 1 开始
+// 超过了 RetryPolicy.MaxAttempts 的时候返回 errs.ErrRetryExhausted，元素不会入队；
+// 队列满了的时候会阻塞，语义和 DelayQueue.Enqueue 一样
+func (q *RetryQueue[T]) Retry(ctx context.Context, t T, attempt int) error {
+	if attempt < 1 {
+		attempt = 1
+	}
+	if q.policy.MaxAttempts > 0 && attempt > q.policy.MaxAttempts {
+		return errs.ErrRetryExhausted
+	}
+	return q.delay.Enqueue(ctx, retryItem[T]{
+		val:      t,
+		attempt:  attempt,
+		deadline: q.clock.Now().Add(q.policy.delay(attempt)),
+	})
+}
+
+// Dequeue 阻塞直到有元素的等待时间到了，语义和 DelayQueue.Dequeue 一样
+func (q *RetryQueue[T]) Dequeue(ctx context.Context) (T, error) {
+	val, _, err := q.DequeueWithAttempt(ctx)
+	return val, err
+}
+
+// DequeueWithAttempt 和 Dequeue 一样，同时返回这是第几次重试
+// 再次失败的时候，调用者可以用 attempt + 1 调用 Retry
+func (q *RetryQueue[T]) DequeueWithAttempt(ctx context.Context) (T, int, error) {
+	item, err := q.delay.Dequeue(ctx)
+	if err != nil {
+		var t T
+		return t, 0, err
+	}
+	return item.val, item.attempt, nil
+}
+
+// Exhausted 第 attempt 次重试失败之后，是否已经不能再重试了
+func (q *RetryQueue[T]) Exhausted(attempt int) bool {
+	return q.policy.MaxAttempts > 0 && attempt >= q.policy.MaxAttempts
+}
+
+// Stats 返回统计信息的快照
+func (q *RetryQueue[T]) Stats() Stats {
+	return q.delay.Stats()
+}
+
+// Close 关闭队列，语义和 DelayQueue.Close 一样
+func (q *RetryQueue[T]) Close() error {
+	return q.delay.Close()
+}
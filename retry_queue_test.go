@@ -0,0 +1,67 @@
+package concurrent_queue
+
+import (
+	"concurrent_queue/errs"
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestRetryQueue(t *testing.T) {
+	t.Parallel()
+	q := NewRetryQueue[string](0, RetryPolicy{
+		Backoff:     ExponentialBackoff(time.Millisecond*20, 2),
+		MaxDelay:    time.Millisecond * 50,
+		MaxAttempts: 3,
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	start := time.Now()
+	require.NoError(t, q.Retry(ctx, "a", 3))
+	require.NoError(t, q.Retry(ctx, "b", 1))
+	// 等待时间短的先出来
+	val, attempt, err := q.DequeueWithAttempt(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, "b", val)
+	assert.Equal(t, 1, attempt)
+	assert.False(t, q.Exhausted(attempt))
+	assert.True(t, time.Since(start) >= time.Millisecond*15)
+
+	// 第三次本来要等待 80ms，但是被 MaxDelay 限制在了 50ms
+	val, attempt, err = q.DequeueWithAttempt(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, "a", val)
+	assert.Equal(t, 3, attempt)
+	elapsed := time.Since(start)
+	assert.True(t, elapsed >= time.Millisecond*45)
+	assert.True(t, elapsed < time.Millisecond*75)
+	assert.True(t, q.Exhausted(attempt))
+
+	// 重试次数用完了
+	assert.Equal(t, errs.ErrRetryExhausted, q.Retry(ctx, "a", 4))
+	assert.Equal(t, 0, q.Stats().Len)
+
+	require.NoError(t, q.Close())
+	assert.Equal(t, errs.ErrQueueClosed, q.Retry(ctx, "c", 1))
+	_, err = q.Dequeue(ctx)
+	assert.Equal(t, errs.ErrQueueClosed, err)
+}
+
+func TestRetryQueue_NoBackoff(t *testing.T) {
+	t.Parallel()
+	q := NewRetryQueue[int](1, RetryPolicy{})
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*100)
+	defer cancel()
+	// 没有限制重试次数，也不需要等待
+	require.NoError(t, q.Retry(ctx, 1, 100))
+	assert.False(t, q.Exhausted(100))
+	// 队列满了
+	assert.Equal(t, context.DeadlineExceeded, q.Retry(ctx, 2, 1))
+
+	val, err := q.Dequeue(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 1, val)
+}
@@ -0,0 +1,182 @@
+package concurrent_queue
+
+import (
+	"concurrent_queue/errs"
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ScheduledExecutor 延时任务执行器，类似于 Java 的 ScheduledThreadPoolExecutor
+// 任务放在 DelayQueue 里面，到期之后由固定数量的 worker 取出来执行
+//
+//	e := NewScheduledExecutor[string](4, 0)
+//	f, _ := e.Schedule(ctx, time.Second, func(ctx context.Context) (string, error) {
+//		return "hello", nil
+//	})
+//	val, err := f.Get(ctx)
+//	_ = e.Shutdown(ctx)
+type ScheduledExecutor[R any] struct {
+	queue *DelayQueue[*scheduledTask[R]]
+	clock Clock
+
+	// quit 用于让 worker 停止出队
+	quit     context.Context
+	stopQuit context.CancelFunc
+	// taskCtx 所有任务的 ctx 的 parent，Shutdown 超时的时候会被取消
+	taskCtx   context.Context
+	stopTasks context.CancelFunc
+	wg        sync.WaitGroup
+
+	mutex sync.Mutex
+	// tasks 还没有开始执行的任务
+	tasks    map[*scheduledTask[R]]struct{}
+	shutdown bool
+}
+
+type scheduledTask[R any] struct {
+	fn     func(ctx context.Context) (R, error)
+	future *Future[R]
+	at     time.Time
+}
+
+func (t *scheduledTask[R]) Delay() time.Duration {
+	return time.Until(t.at)
+}
+
+func (t *scheduledTask[R]) Deadline() time.Time {
+	return t.at
+}
+
+// NewScheduledExecutor 创建延时任务执行器，并且启动 workers 个 worker
+// workers <= 0 的时候只启动一个 worker；capacity <= 0 时，等待执行的任务个数不限制
+// 支持的 Option：WithObserver, WithClock
+func NewScheduledExecutor[R any](workers int, capacity int, opts ...Option[R]) *ScheduledExecutor[R] {
+	if workers <= 0 {
+		workers = 1
+	}
+	options := newQueueOptions(opts)
+	e := &ScheduledExecutor[R]{
+		queue: NewDelayQueue[*scheduledTask[R]](capacity,
+			WithObserver[*scheduledTask[R]](options.observer),
+			WithClock[*scheduledTask[R]](options.clock)),
+		clock: options.clock,
+		tasks: make(map[*scheduledTask[R]]struct{}, 8),
+	}
+	e.quit, e.stopQuit = context.WithCancel(context.Background())
+	e.taskCtx, e.stopTasks = context.WithCancel(context.Background())
+	e.wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go e.work()
+	}
+	return e
+}
+
+// Schedule 在 delay 之后执行 fn，delay <= 0 的时候尽快执行
+// ctx 只用于控制入队，队列满了的时候会阻塞直到 ctx 超时；fn 拿到的 ctx 在任务被取消或者 Shutdown 超时的时候会被取消。
+// fn panic 的时候 Future.Get 返回的 error 包装了 errs.ErrTaskPanic。
+// 执行器已经关闭的时候返回 errs.ErrExecutorShutdown
+func (e *ScheduledExecutor[R]) Schedule(ctx context.Context, delay time.Duration,
+	fn func(ctx context.Context) (R, error)) (*Future[R], error) {
+	task := &scheduledTask[R]{
+		fn:     fn,
+		future: newFuture[R](),
+		at:     e.clock.Now().Add(delay),
+	}
+	e.mutex.Lock()
+	if e.shutdown {
+		e.mutex.Unlock()
+		return nil, errs.ErrExecutorShutdown
+	}
+	e.tasks[task] = struct{}{}
+	e.mutex.Unlock()
+
+	h, err := e.queue.EnqueueWithHandle(ctx, task)
+	if err != nil {
+		e.forget(task)
+		if err == errs.ErrQueueClosed {
+			err = errs.ErrExecutorShutdown
+		}
+		return nil, err
+	}
+	task.future.mutex.Lock()
+	task.future.onCancel = func() {
+		e.queue.Cancel(h)
+		e.forget(task)
+	}
+	task.future.mutex.Unlock()
+	return task.future, nil
+}
+
+func (e *ScheduledExecutor[R]) forget(task *scheduledTask[R]) {
+	e.mutex.Lock()
+	delete(e.tasks, task)
+	e.mutex.Unlock()
+}
+
+func (e *ScheduledExecutor[R]) work() {
+	defer e.wg.Done()
+	for {
+		task, err := e.queue.Dequeue(e.quit)
+		if err != nil {
+			return
+		}
+		e.forget(task)
+		e.run(task)
+	}
+}
+
+func (e *ScheduledExecutor[R]) run(task *scheduledTask[R]) {
+	ctx, cancel := context.WithCancel(e.taskCtx)
+	defer cancel()
+	// 在出队之后被取消了
+	if !task.future.start(cancel) {
+		return
+	}
+	val, err := call(ctx, task.fn)
+	task.future.complete(val, err)
+}
+
+// call 执行 fn，并且把 panic 转化为 error
+func call[R any](ctx context.Context, fn func(ctx context.Context) (R, error)) (val R, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			var zero R
+			val, err = zero, fmt.Errorf("%w: %v", errs.ErrTaskPanic, r)
+		}
+	}()
+	return fn(ctx)
+}
+
+// Shutdown 关闭执行器，之后 Schedule 返回 errs.ErrExecutorShutdown
+// 还没有开始执行的任务不会再执行，它们的 Future.Get 返回 errs.ErrExecutorShutdown；
+// 正在执行的任务会继续执行，Shutdown 会等待它们执行完毕。
+// ctx 超时的时候，正在执行的任务拿到的 ctx 会被取消，Shutdown 不再等待并返回 ctx 的错误
+func (e *ScheduledExecutor[R]) Shutdown(ctx context.Context) error {
+	e.mutex.Lock()
+	pending := e.tasks
+	e.tasks = map[*scheduledTask[R]]struct{}{}
+	e.shutdown = true
+	e.mutex.Unlock()
+
+	_ = e.queue.Close()
+	for task := range pending {
+		var zero R
+		task.future.complete(zero, errs.ErrExecutorShutdown)
+	}
+	e.stopQuit()
+
+	done := make(chan struct{})
+	go func() {
+		e.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		e.stopTasks()
+		return ctx.Err()
+	}
+}
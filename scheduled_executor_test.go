@@ -0,0 +1,141 @@
+package concurrent_queue
+
+import (
+	"concurrent_queue/errs"
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestScheduledExecutor_Schedule(t *testing.T) {
+	t.Parallel()
+	start := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock := NewManualClock(start)
+	e := NewScheduledExecutor[int](2, 0, WithClock[int](clock))
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	var order []int
+	results := make(chan int, 3)
+	for _, delay := range []int{3, 1, 2} {
+		delay := delay
+		_, err := e.Schedule(ctx, time.Second*time.Duration(delay), func(ctx context.Context) (int, error) {
+			results <- delay
+			return delay, nil
+		})
+		require.NoError(t, err)
+	}
+	for i := 1; i <= 3; i++ {
+		// 两个 worker 都在等待堆顶到期
+		clock.BlockUntil(1)
+		clock.Advance(time.Second)
+		order = append(order, <-results)
+	}
+	assert.Equal(t, []int{1, 2, 3}, order)
+
+	f, err := e.Schedule(ctx, 0, func(ctx context.Context) (int, error) {
+		return 0, errors.New("mock error")
+	})
+	require.NoError(t, err)
+	_, err = f.Get(ctx)
+	assert.Equal(t, errors.New("mock error"), err)
+
+	f, err = e.Schedule(ctx, 0, func(ctx context.Context) (int, error) {
+		panic("mock panic")
+	})
+	require.NoError(t, err)
+	_, err = f.Get(ctx)
+	assert.True(t, errors.Is(err, errs.ErrTaskPanic))
+
+	// panic 之后 worker 依旧可以执行任务
+	f, err = e.Schedule(ctx, 0, func(ctx context.Context) (int, error) {
+		return 4, nil
+	})
+	require.NoError(t, err)
+	val, err := f.Get(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 4, val)
+	require.NoError(t, e.Shutdown(ctx))
+}
+
+func TestScheduledExecutor_Cancel(t *testing.T) {
+	t.Parallel()
+	e := NewScheduledExecutor[int](1, 0)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	var executed int32
+	f, err := e.Schedule(ctx, time.Millisecond*50, func(ctx context.Context) (int, error) {
+		atomic.AddInt32(&executed, 1)
+		return 1, nil
+	})
+	require.NoError(t, err)
+	assert.True(t, f.Cancel())
+	// 被取消的任务会从延时队列中删除
+	assert.Equal(t, 0, e.queue.Stats().Len)
+	_, err = f.Get(ctx)
+	assert.Equal(t, errs.ErrTaskCancelled, err)
+
+	// 取消正在执行的任务
+	started := make(chan struct{})
+	stopped := make(chan error, 1)
+	f, err = e.Schedule(ctx, 0, func(ctx context.Context) (int, error) {
+		close(started)
+		<-ctx.Done()
+		stopped <- ctx.Err()
+		return 2, nil
+	})
+	require.NoError(t, err)
+	<-started
+	assert.True(t, f.Cancel())
+	assert.Equal(t, context.Canceled, <-stopped)
+	_, err = f.Get(ctx)
+	assert.Equal(t, errs.ErrTaskCancelled, err)
+	assert.False(t, f.Cancel())
+
+	require.NoError(t, e.Shutdown(ctx))
+	assert.Equal(t, int32(0), atomic.LoadInt32(&executed))
+}
+
+func TestScheduledExecutor_Shutdown(t *testing.T) {
+	t.Parallel()
+	e := NewScheduledExecutor[int](1, 0)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	running, err := e.Schedule(ctx, 0, func(ctx context.Context) (int, error) {
+		close(started)
+		<-release
+		return 1, nil
+	})
+	require.NoError(t, err)
+	pending, err := e.Schedule(ctx, time.Hour, func(ctx context.Context) (int, error) {
+		return 2, nil
+	})
+	require.NoError(t, err)
+	<-started
+
+	// 正在执行的任务没有结束，Shutdown 会一直等待
+	shutdownCtx, shutdownCancel := context.WithTimeout(ctx, time.Millisecond*50)
+	defer shutdownCancel()
+	assert.Equal(t, context.DeadlineExceeded, e.Shutdown(shutdownCtx))
+
+	_, err = pending.Get(ctx)
+	assert.Equal(t, errs.ErrExecutorShutdown, err)
+	_, err = e.Schedule(ctx, 0, func(ctx context.Context) (int, error) {
+		return 3, nil
+	})
+	assert.Equal(t, errs.ErrExecutorShutdown, err)
+
+	close(release)
+	require.NoError(t, e.Shutdown(ctx))
+	val, err := running.Get(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, val)
+}
@@ -0,0 +1,351 @@
+package concurrent_queue
+
+import (
+	"concurrent_queue/errs"
+	"context"
+	"sync"
+	"time"
+)
+
+// Schedule 决定定时任务什么时候执行
+type Schedule interface {
+	// Next 返回 t 之后下一次执行的时间，返回零值说明不会再执行了
+	Next(t time.Time) time.Time
+}
+
+// Every 每隔 interval 执行一次，interval <= 0 的时候按照 1 秒来处理
+func Every(interval time.Duration) Schedule {
+	if interval <= 0 {
+		interval = time.Second
+	}
+	return everySchedule{interval: interval}
+}
+
+type everySchedule struct {
+	interval time.Duration
+}
+
+func (e everySchedule) Next(t time.Time) time.Time {
+	return t.Add(e.interval)
+}
+
+// ScheduleMode 计算下一次执行时间的方式
+type ScheduleMode int
+
+const (
+	// FixedRate 从上一次计划执行的时间开始计算下一次执行的时间，不会因为消费者的处理时间而漂移，这是默认的方式
+	FixedRate ScheduleMode = iota
+	// FixedDelay 从上一次执行完毕，也就是调用 Tick.Done 的时间开始计算下一次执行的时间，
+	// 在调用 Tick.Done 之前不会有下一次执行
+	FixedDelay
+)
+
+// MisfirePolicy 消费者跟不上，错过了执行时间的处理策略
+// 只对 FixedRate 的任务生效，FixedDelay 的任务总是从执行完毕的时间开始计算，不会错过
+type MisfirePolicy int
+
+const (
+	// MisfireSkip 迟到的这一次依旧会被取出来，但是之后所有已经错过的执行都会被跳过，这是默认的策略
+	MisfireSkip MisfirePolicy = iota
+	// MisfireCatchUp 依次补上所有错过的执行，它们会被立刻取出来
+	MisfireCatchUp
+)
+
+// ScheduleOption 定时任务的可选配置
+type ScheduleOption func(opts *scheduleOptions)
+
+type scheduleOptions struct {
+	mode    ScheduleMode
+	misfire MisfirePolicy
+}
+
+// WithScheduleMode 设置计算下一次执行时间的方式，默认是 FixedRate
+func WithScheduleMode(mode ScheduleMode) ScheduleOption {
+	return func(opts *scheduleOptions) {
+		opts.mode = mode
+	}
+}
+
+// WithMisfirePolicy 设置错过执行时间的处理策略，默认是 MisfireSkip
+func WithMisfirePolicy(policy MisfirePolicy) ScheduleOption {
+	return func(opts *scheduleOptions) {
+		opts.misfire = policy
+	}
+}
+
+// EntryID 定时任务的 ID
+type EntryID uint64
+
+// Tick 定时任务的一次执行
+type Tick[T any] struct {
+	ID  EntryID
+	Val T
+	// Scheduled 计划执行的时间，和实际取出来的时间之间的差距就是延迟
+	Scheduled time.Time
+
+	scheduler *Scheduler[T]
+	seq       uint64
+}
+
+// Done 通知调度器这一次执行已经完毕
+// 对于 FixedDelay 的任务，在调用 Done 之后才会计算下一次执行的时间；对于别的任务，什么也不会做。
+// 重复调用不会有任何效果
+func (t Tick[T]) Done() {
+	if t.scheduler != nil {
+		t.scheduler.done(t.ID, t.seq)
+	}
+}
+
+// Scheduler 定时任务调度器
+// 内部使用 DelayQueue 保存每个任务下一次执行的时间，到期之后由消费者通过 Next 取走。
+// 调度器本身不执行任何任务，通常会有一个或者多个 goroutine 循环调用 Next 来处理
+//
+//	s := NewScheduler[string]()
+//	cron, _ := ParseCron("CRON_TZ=Asia/Shanghai 0 9 * * MON-FRI")
+//	_, _ = s.Schedule("report", cron)
+//	_, _ = s.Schedule("heartbeat", Every(time.Second*10), WithScheduleMode(FixedDelay))
+//	for {
+//		tick, err := s.Next(ctx)
+//		if err != nil {
+//			return
+//		}
+//		handle(tick.Val)
+//		tick.Done()
+//	}
+type Scheduler[T any] struct {
+	queue *DelayQueue[*firing[T]]
+	clock Clock
+
+	mutex   sync.Mutex
+	entries map[EntryID]*scheduleEntry[T]
+	nextID  EntryID
+	closed  bool
+}
+
+type scheduleEntry[T any] struct {
+	id       EntryID
+	val      T
+	schedule Schedule
+	options  scheduleOptions
+	paused   bool
+	// pending 在延时队列中等待执行的那一次，没有的时候为 nil
+	pending *firing[T]
+	handle  DelayHandle[*firing[T]]
+	// seq 每一次执行的序号，running 是正在执行的 FixedDelay 任务的序号，没有的时候为 0
+	seq     uint64
+	running uint64
+}
+
+// firing 某个任务的一次执行
+type firing[T any] struct {
+	entry *scheduleEntry[T]
+	at    time.Time
+}
+
+func (f *firing[T]) Delay() time.Duration {
+	return time.Until(f.at)
+}
+
+func (f *firing[T]) Deadline() time.Time {
+	return f.at
+}
+
+// NewScheduler 创建调度器
+// 支持的 Option：WithObserver, WithClock
+func NewScheduler[T any](opts ...Option[T]) *Scheduler[T] {
+	options := newQueueOptions(opts)
+	return &Scheduler[T]{
+		queue: NewDelayQueue[*firing[T]](0,
+			WithObserver[*firing[T]](options.observer),
+			WithClock[*firing[T]](options.clock)),
+		clock:   options.clock,
+		entries: make(map[EntryID]*scheduleEntry[T], 8),
+	}
+}
+
+// Schedule 添加定时任务，第一次执行的时间是 schedule.Next(现在)
+// 调度器已经关闭的时候返回 errs.ErrQueueClosed
+func (s *Scheduler[T]) Schedule(val T, schedule Schedule, opts ...ScheduleOption) (EntryID, error) {
+	entry := &scheduleEntry[T]{
+		val:      val,
+		schedule: schedule,
+	}
+	for _, opt := range opts {
+		opt(&entry.options)
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.closed {
+		return 0, errs.ErrQueueClosed
+	}
+	s.nextID++
+	entry.id = s.nextID
+	s.entries[entry.id] = entry
+	s.arm(entry, schedule.Next(s.clock.Now()))
+	return entry.id, nil
+}
+
+// Next 阻塞直到有定时任务到期
+// 调度器关闭之后返回 errs.ErrQueueClosed，超时的时候返回 ctx 的错误
+func (s *Scheduler[T]) Next(ctx context.Context) (Tick[T], error) {
+	for {
+		f, err := s.queue.Dequeue(ctx)
+		if err != nil {
+			return Tick[T]{}, err
+		}
+		if tick, ok := s.fire(f); ok {
+			return tick, nil
+		}
+	}
+}
+
+// fire 返回 false 说明这一次执行已经被暂停或者取消了
+func (s *Scheduler[T]) fire(f *firing[T]) (Tick[T], bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	entry := f.entry
+	// 在出队之后，加锁之前被暂停或者取消了
+	if entry.pending != f {
+		return Tick[T]{}, false
+	}
+	entry.pending = nil
+	entry.seq++
+	tick := Tick[T]{
+		ID:        entry.id,
+		Val:       entry.val,
+		Scheduled: f.at,
+		scheduler: s,
+		seq:       entry.seq,
+	}
+	if entry.options.mode == FixedDelay {
+		entry.running = entry.seq
+		return tick, true
+	}
+	next := entry.schedule.Next(f.at)
+	if now := s.clock.Now(); entry.options.misfire == MisfireSkip && !next.IsZero() && !next.After(now) {
+		next = nextAfter(entry.schedule, next, now)
+	}
+	s.arm(entry, next)
+	return tick, true
+}
+
+// nextAfter 从 next 开始，跳过所有不晚于 now 的执行时间
+// 不直接使用 schedule.Next(now) 是为了保持原本的节奏，例如每秒执行一次的任务总是在整秒执行
+func nextAfter(schedule Schedule, next, now time.Time) time.Time {
+	if e, ok := schedule.(everySchedule); ok {
+		return next.Add((now.Sub(next)/e.interval + 1) * e.interval)
+	}
+	for !next.IsZero() && !next.After(now) {
+		next = schedule.Next(next)
+	}
+	return next
+}
+
+// done FixedDelay 的任务执行完毕之后，从现在开始计算下一次执行的时间
+func (s *Scheduler[T]) done(id EntryID, seq uint64) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	entry, ok := s.entries[id]
+	if !ok || entry.running != seq || seq == 0 {
+		return
+	}
+	entry.running = 0
+	if !entry.paused {
+		s.arm(entry, entry.schedule.Next(s.clock.Now()))
+	}
+}
+
+// arm 把任务下一次执行放入延时队列，next 为零值的时候任务结束，必须持有锁
+func (s *Scheduler[T]) arm(entry *scheduleEntry[T], next time.Time) {
+	if next.IsZero() {
+		delete(s.entries, entry.id)
+		return
+	}
+	f := &firing[T]{entry: entry, at: next}
+	// 无界的延时队列，不会阻塞，只会在关闭之后失败
+	h, err := s.queue.EnqueueWithHandle(context.Background(), f)
+	if err != nil {
+		return
+	}
+	entry.pending = f
+	entry.handle = h
+}
+
+// Pause 暂停定时任务，暂停期间不会执行
+// 任务不存在的时候返回 errs.ErrScheduleNotFound
+func (s *Scheduler[T]) Pause(id EntryID) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	entry, ok := s.entries[id]
+	if !ok {
+		return errs.ErrScheduleNotFound
+	}
+	entry.paused = true
+	s.disarm(entry)
+	return nil
+}
+
+// Resume 恢复被暂停的定时任务，下一次执行从现在开始计算，暂停期间错过的执行不会补上
+// 任务不存在的时候返回 errs.ErrScheduleNotFound
+func (s *Scheduler[T]) Resume(id EntryID) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	entry, ok := s.entries[id]
+	if !ok {
+		return errs.ErrScheduleNotFound
+	}
+	if !entry.paused {
+		return nil
+	}
+	entry.paused = false
+	// FixedDelay 的任务还在执行，等到 Done 的时候再计算
+	if entry.running == 0 {
+		s.arm(entry, entry.schedule.Next(s.clock.Now()))
+	}
+	return nil
+}
+
+// Cancel 取消定时任务，任务不存在的时候返回 false
+func (s *Scheduler[T]) Cancel(id EntryID) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	entry, ok := s.entries[id]
+	if !ok {
+		return false
+	}
+	delete(s.entries, id)
+	s.disarm(entry)
+	return true
+}
+
+// disarm 把任务从延时队列中删除，必须持有锁
+func (s *Scheduler[T]) disarm(entry *scheduleEntry[T]) {
+	if entry.pending != nil {
+		s.queue.Cancel(entry.handle)
+		entry.pending = nil
+	}
+}
+
+// Len 定时任务的个数，包括被暂停的
+func (s *Scheduler[T]) Len() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return len(s.entries)
+}
+
+// Close 关闭调度器，所有的定时任务都会被取消
+// 关闭之后 Schedule 返回 errs.ErrQueueClosed，阻塞在 Next 上的 goroutine 会被唤醒并返回 errs.ErrQueueClosed
+func (s *Scheduler[T]) Close() error {
+	s.mutex.Lock()
+	if s.closed {
+		s.mutex.Unlock()
+		return nil
+	}
+	s.closed = true
+	for id, entry := range s.entries {
+		s.disarm(entry)
+		delete(s.entries, id)
+	}
+	s.mutex.Unlock()
+	return s.queue.Close()
+}
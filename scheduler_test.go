@@ -0,0 +1,236 @@
+package concurrent_queue
+
+import (
+	"concurrent_queue/errs"
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestScheduler_FixedRate(t *testing.T) {
+	t.Parallel()
+	start := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock := NewManualClock(start)
+	s := NewScheduler[string](WithClock[string](clock))
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+	id, err := s.Schedule("a", Every(time.Second))
+	require.NoError(t, err)
+
+	for i := 1; i <= 3; i++ {
+		clock.Set(start.Add(time.Second * time.Duration(i)))
+		tick, err := s.Next(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, id, tick.ID)
+		assert.Equal(t, "a", tick.Val)
+		// 不会漂移
+		assert.Equal(t, start.Add(time.Second*time.Duration(i)), tick.Scheduled)
+		// 处理耗时不影响下一次执行的时间
+		clock.Advance(time.Millisecond * 300)
+		tick.Done()
+	}
+	assert.Equal(t, 1, s.Len())
+	require.NoError(t, s.Close())
+	_, err = s.Next(ctx)
+	assert.Equal(t, errs.ErrQueueClosed, err)
+	_, err = s.Schedule("b", Every(time.Second))
+	assert.Equal(t, errs.ErrQueueClosed, err)
+}
+
+func TestScheduler_FixedDelay(t *testing.T) {
+	t.Parallel()
+	start := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock := NewManualClock(start)
+	s := NewScheduler[string](WithClock[string](clock))
+	defer func() {
+		_ = s.Close()
+	}()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+	_, err := s.Schedule("a", Every(time.Second), WithScheduleMode(FixedDelay))
+	require.NoError(t, err)
+
+	clock.Set(start.Add(time.Second))
+	tick, err := s.Next(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, start.Add(time.Second), tick.Scheduled)
+	// 在 Done 之前不会有下一次执行
+	clock.Advance(time.Second * 5)
+	assertNoTick(t, s)
+
+	tick.Done()
+	tick.Done()
+	clock.Advance(time.Millisecond * 999)
+	assertNoTick(t, s)
+	clock.Advance(time.Millisecond)
+	tick, err = s.Next(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, start.Add(time.Second*7), tick.Scheduled)
+	tick.Done()
+	assert.Equal(t, 1, s.Len())
+}
+
+func TestScheduler_Misfire(t *testing.T) {
+	t.Parallel()
+	testCases := []struct {
+		name   string
+		policy MisfirePolicy
+		// 落后了 3.5 秒之后，依次取出来的计划执行时间
+		want []time.Duration
+	}{
+		{
+			// 错过的 2 秒和 3 秒被跳过了
+			name:   "skip",
+			policy: MisfireSkip,
+			want:   []time.Duration{time.Second},
+		},
+		{
+			name:   "catch up",
+			policy: MisfireCatchUp,
+			want:   []time.Duration{time.Second, time.Second * 2, time.Second * 3},
+		},
+	}
+	for _, tt := range testCases {
+		tc := tt
+		t.Run(tc.name, func(t *testing.T) {
+			start := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+			clock := NewManualClock(start)
+			s := NewScheduler[int](WithClock[int](clock))
+			defer func() {
+				_ = s.Close()
+			}()
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+			defer cancel()
+			_, err := s.Schedule(1, Every(time.Second), WithMisfirePolicy(tc.policy))
+			require.NoError(t, err)
+
+			clock.Set(start.Add(time.Millisecond * 3500))
+			for _, want := range tc.want {
+				tick, err := s.Next(ctx)
+				require.NoError(t, err)
+				assert.Equal(t, start.Add(want), tick.Scheduled)
+			}
+			assertNoTick(t, s)
+			// 下一次是 4 秒
+			clock.Set(start.Add(time.Second * 4))
+			tick, err := s.Next(ctx)
+			require.NoError(t, err)
+			assert.Equal(t, start.Add(time.Second*4), tick.Scheduled)
+		})
+	}
+}
+
+func TestScheduler_PauseAndCancel(t *testing.T) {
+	t.Parallel()
+	start := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock := NewManualClock(start)
+	s := NewScheduler[string](WithClock[string](clock))
+	defer func() {
+		_ = s.Close()
+	}()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+	a, err := s.Schedule("a", Every(time.Second))
+	require.NoError(t, err)
+	b, err := s.Schedule("b", Every(time.Second*2))
+	require.NoError(t, err)
+
+	require.NoError(t, s.Pause(a))
+	clock.Set(start.Add(time.Second * 2))
+	tick, err := s.Next(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, "b", tick.Val)
+	assertNoTick(t, s)
+
+	// 恢复之后从现在开始计算
+	require.NoError(t, s.Resume(a))
+	require.NoError(t, s.Resume(a))
+	clock.Set(start.Add(time.Second * 3))
+	tick, err = s.Next(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, "a", tick.Val)
+	assert.Equal(t, start.Add(time.Second*3), tick.Scheduled)
+
+	assert.True(t, s.Cancel(b))
+	assert.False(t, s.Cancel(b))
+	assert.Equal(t, errs.ErrScheduleNotFound, s.Pause(b))
+	assert.Equal(t, errs.ErrScheduleNotFound, s.Resume(b))
+	clock.Set(start.Add(time.Second * 4))
+	tick, err = s.Next(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, "a", tick.Val)
+	assertNoTick(t, s)
+	assert.Equal(t, 1, s.Len())
+}
+
+func TestScheduler_Cron(t *testing.T) {
+	t.Parallel()
+	// 2023-01-01 是周日
+	start := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock := NewManualClock(start)
+	s := NewScheduler[string](WithClock[string](clock))
+	defer func() {
+		_ = s.Close()
+	}()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+	cron, err := ParseCron("CRON_TZ=Asia/Shanghai 0 9 * * MON-FRI")
+	require.NoError(t, err)
+	_, err = s.Schedule("report", cron)
+	require.NoError(t, err)
+
+	// 周一上海时间 9 点，也就是 UTC 的 1 点
+	clock.Set(time.Date(2023, 1, 2, 1, 0, 0, 0, time.UTC))
+	tick, err := s.Next(ctx)
+	require.NoError(t, err)
+	assert.True(t, time.Date(2023, 1, 2, 1, 0, 0, 0, time.UTC).Equal(tick.Scheduled))
+	// 一直到周五才来取，周二那一次会被取出来，周三到周五的会被跳过，下一次是下周一
+	clock.Set(time.Date(2023, 1, 6, 2, 0, 0, 0, time.UTC))
+	tick, err = s.Next(ctx)
+	require.NoError(t, err)
+	assert.True(t, time.Date(2023, 1, 3, 1, 0, 0, 0, time.UTC).Equal(tick.Scheduled))
+	assertNoTick(t, s)
+	clock.Set(time.Date(2023, 1, 9, 0, 59, 0, 0, time.UTC))
+	assertNoTick(t, s)
+	clock.Set(time.Date(2023, 1, 9, 1, 0, 0, 0, time.UTC))
+	tick, err = s.Next(ctx)
+	require.NoError(t, err)
+	assert.True(t, time.Date(2023, 1, 9, 1, 0, 0, 0, time.UTC).Equal(tick.Scheduled))
+}
+
+func TestScheduler_Finished(t *testing.T) {
+	t.Parallel()
+	start := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock := NewManualClock(start)
+	s := NewScheduler[int](WithClock[int](clock))
+	defer func() {
+		_ = s.Close()
+	}()
+	// 只执行一次
+	_, err := s.Schedule(1, scheduleFunc(func(t time.Time) time.Time {
+		if t.Before(start.Add(time.Second)) {
+			return start.Add(time.Second)
+		}
+		return time.Time{}
+	}))
+	require.NoError(t, err)
+	clock.Set(start.Add(time.Second))
+	_, err = s.Next(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 0, s.Len())
+}
+
+type scheduleFunc func(t time.Time) time.Time
+
+func (f scheduleFunc) Next(t time.Time) time.Time {
+	return f(t)
+}
+
+func assertNoTick[T any](t *testing.T, s *Scheduler[T]) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*10)
+	defer cancel()
+	tick, err := s.Next(ctx)
+	assert.Equal(t, context.DeadlineExceeded, err, "不应该有任务到期，但是取出了 %v", tick)
+}
@@ -0,0 +1,195 @@
+package concurrent_queue
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"time"
+)
+
+// Stats 队列统计信息的快照
+// 各个字段是分别读取的，所以在并发的情况下，字段之间不保证严格一致
+type Stats struct {
+	// Enqueued 累计入队的元素个数
+	Enqueued uint64
+	// Dequeued 累计出队的元素个数
+	Dequeued uint64
+	// Dropped 因为 overflow 策略被丢弃的元素个数
+	Dropped uint64
+	// Expired 因为过期被丢弃的元素个数
+	Expired uint64
+	// Len 当前元素个数
+	Len int
+	// Cap 容量，无界队列为 0
+	Cap int
+	// HighWaterMark 元素个数的历史最大值
+	HighWaterMark int
+	// Budget 按照开销限制容量的队列的总预算，其余队列为 0
+	Budget int64
+	// Used 队列中元素的总开销，和 Budget 的单位一致，其余队列为 0
+	Used int64
+	// BlockedProducers 当前阻塞在入队上的 goroutine 数量
+	BlockedProducers int
+	// BlockedConsumers 当前阻塞在出队上的 goroutine 数量
+	BlockedConsumers int
+	// Timeouts 阻塞的过程中 ctx 超时或者被取消的次数
+	Timeouts uint64
+	// WaitTime 入队和出队累计阻塞的时间
+	WaitTime time.Duration
+}
+
+// queueStats 用原子操作维护的计数器，读取的时候不需要加队列的锁
+// 同时负责把事件通知给 Observer
+type queueStats struct {
+	enqueued         uint64
+	dequeued         uint64
+	dropped          uint64
+	expired          uint64
+	timeouts         uint64
+	waitNanos        int64
+	length           int64
+	capacity         int64
+	highWaterMark    int64
+	blockedProducers int64
+	blockedConsumers int64
+
+	observer Observer
+}
+
+func newQueueStats(capacity int, observer Observer) *queueStats {
+	s := &queueStats{observer: observer}
+	s.setCap(capacity)
+	return s
+}
+
+// enqueue 记录 n 个元素入队
+func (s *queueStats) enqueue(n int) {
+	atomic.AddUint64(&s.enqueued, uint64(n))
+	length := atomic.AddInt64(&s.length, int64(n))
+	for {
+		hwm := atomic.LoadInt64(&s.highWaterMark)
+		if length <= hwm || atomic.CompareAndSwapInt64(&s.highWaterMark, hwm, length) {
+			break
+		}
+	}
+	for i := 0; i < n; i++ {
+		s.observer.OnEnqueue()
+	}
+}
+
+// dequeue 记录 n 个元素出队，waited 是出队者为此阻塞的时间
+func (s *queueStats) dequeue(n int, waited time.Duration) {
+	atomic.AddUint64(&s.dequeued, uint64(n))
+	atomic.AddInt64(&s.length, -int64(n))
+	for i := 0; i < n; i++ {
+		s.observer.OnDequeue(waited)
+	}
+}
+
+// full 入队的时候发现队列已满
+func (s *queueStats) full() {
+	s.observer.OnFull()
+}
+
+// empty 出队的时候发现没有可以取的元素
+func (s *queueStats) empty() {
+	s.observer.OnEmpty()
+}
+
+// drop 记录 n 个元素在入队之前就被丢弃了
+func (s *queueStats) drop(n int) {
+	atomic.AddUint64(&s.dropped, uint64(n))
+}
+
+// evict 记录 n 个已经在队列中的元素被丢弃了
+func (s *queueStats) evict(n int) {
+	atomic.AddUint64(&s.dropped, uint64(n))
+	atomic.AddInt64(&s.length, -int64(n))
+}
+
+// expire 记录 n 个已经在队列中的元素因为过期被丢弃了
+func (s *queueStats) expire(n int) {
+	atomic.AddUint64(&s.expired, uint64(n))
+	atomic.AddInt64(&s.length, -int64(n))
+}
+
+// remove 记录 n 个已经在队列中的元素被调用者主动删除了
+func (s *queueStats) remove(n int) {
+	atomic.AddInt64(&s.length, -int64(n))
+}
+
+// setCap 无界队列传入非正数
+func (s *queueStats) setCap(capacity int) {
+	if capacity < 0 {
+		capacity = 0
+	}
+	atomic.StoreInt64(&s.capacity, int64(capacity))
+}
+
+func (s *queueStats) producerWaiter() waiter {
+	return waiter{stats: s, blocked: &s.blockedProducers, producer: true}
+}
+
+func (s *queueStats) consumerWaiter() waiter {
+	return waiter{stats: s, blocked: &s.blockedConsumers}
+}
+
+func (s *queueStats) snapshot() Stats {
+	return Stats{
+		Enqueued:         atomic.LoadUint64(&s.enqueued),
+		Dequeued:         atomic.LoadUint64(&s.dequeued),
+		Dropped:          atomic.LoadUint64(&s.dropped),
+		Expired:          atomic.LoadUint64(&s.expired),
+		Len:              int(atomic.LoadInt64(&s.length)),
+		Cap:              int(atomic.LoadInt64(&s.capacity)),
+		HighWaterMark:    int(atomic.LoadInt64(&s.highWaterMark)),
+		BlockedProducers: int(atomic.LoadInt64(&s.blockedProducers)),
+		BlockedConsumers: int(atomic.LoadInt64(&s.blockedConsumers)),
+		Timeouts:         atomic.LoadUint64(&s.timeouts),
+		WaitTime:         time.Duration(atomic.LoadInt64(&s.waitNanos)),
+	}
+}
+
+// waiter 记录一次阻塞
+// 在真正阻塞之前调用 wait，在返回之前调用 done，没有调用过 wait 的话 done 什么也不会做。
+// 这样快路径上不会有任何额外开销
+type waiter struct {
+	stats    *queueStats
+	blocked  *int64
+	producer bool
+	start    time.Time
+	// waited 在 done 之后记录这一次阻塞的时间
+	waited time.Duration
+}
+
+// wait 可以重复调用，只有第一次会生效
+// 阻塞意味着入队的时候队列已满，或者出队的时候没有元素
+func (w *waiter) wait() {
+	if !w.start.IsZero() {
+		return
+	}
+	if w.producer {
+		w.stats.full()
+	} else {
+		w.stats.empty()
+	}
+	w.stats.observer.OnBlock()
+	w.start = time.Now()
+	atomic.AddInt64(w.blocked, 1)
+}
+
+// done 传入指针是为了方便 defer
+func (w *waiter) done(err *error) {
+	if w.start.IsZero() {
+		return
+	}
+	atomic.AddInt64(w.blocked, -1)
+	waited := time.Since(w.start)
+	w.waited += waited
+	atomic.AddInt64(&w.stats.waitNanos, int64(waited))
+	if *err != nil && (errors.Is(*err, context.DeadlineExceeded) || errors.Is(*err, context.Canceled)) {
+		atomic.AddUint64(&w.stats.timeouts, 1)
+		w.stats.observer.OnTimeout()
+	}
+	w.start = time.Time{}
+}
@@ -0,0 +1,63 @@
+package concurrent_queue
+
+import (
+	"concurrent_queue/errs"
+	"context"
+	"sync"
+)
+
+// taskCounter 记录未完成的任务数量，用于实现 TaskDone 和 Join
+// 每一个入队的元素都算作一个未完成的任务，直到消费者调用 TaskDone
+type taskCounter struct {
+	mutex      sync.Mutex
+	unfinished int
+	// 所有任务都完成的时候会被关闭，有新任务的时候会重新创建
+	done chan struct{}
+}
+
+func newTaskCounter() *taskCounter {
+	done := make(chan struct{})
+	close(done)
+	return &taskCounter{
+		done: done,
+	}
+}
+
+// add 增加 n 个未完成的任务
+// 必须在元素对消费者可见之前调用，否则消费者的 TaskDone 有可能先于 add 执行
+func (c *taskCounter) add(n int) {
+	if n <= 0 {
+		return
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.unfinished == 0 {
+		c.done = make(chan struct{})
+	}
+	c.unfinished += n
+}
+
+func (c *taskCounter) taskDone() error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.unfinished == 0 {
+		return errs.ErrTooManyTaskDone
+	}
+	c.unfinished--
+	if c.unfinished == 0 {
+		close(c.done)
+	}
+	return nil
+}
+
+func (c *taskCounter) join(ctx context.Context) error {
+	c.mutex.Lock()
+	done := c.done
+	c.mutex.Unlock()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-done:
+		return nil
+	}
+}
@@ -0,0 +1,391 @@
+package concurrent_queue
+
+import (
+	"concurrent_queue/errs"
+	"context"
+	"math"
+	"sync"
+	"time"
+)
+
+const (
+	defaultWheelTick = time.Millisecond
+	defaultWheelSize = 64
+)
+
+// TimingWheelDelayQueue 基于分层时间轮的延时队列
+// 和 DelayQueue 的语义一样，但是入队和取消都是 O(1) 的，适合大量短时间的超时，例如连接的空闲超时、请求的超时。
+// 代价是精度：元素会在它到期之后的第一个 tick 被取出，所以最多会晚一个 tick，
+// 同一个 tick 内到期的元素之间不保证顺序。
+//
+// 第一层时间轮有 wheelSize 个槽，每个槽是 tick，超出第一层范围的元素会放到上一层时间轮，
+// 上一层的 tick 是下一层的 tick * wheelSize，上一层时间轮是按需创建的。
+// 所有非空的槽按照到期时间放在一个小顶堆里面，堆的大小最多是 层数 * wheelSize，和元素的个数无关
+type TimingWheelDelayQueue[T Delayable] struct {
+	mutex         *sync.Mutex
+	DequeueSignal *cond
+	EnqueueSignal *cond
+	closed        bool
+
+	capacity int
+	// count 队列中的元素个数，包括还没有到期的和已经到期但是还没有被取走的
+	count int
+	wheel *timingWheel[T]
+	// buckets 非空的槽，按照到期时间排序
+	buckets *PriorityQueue[*wheelBucket[T]]
+	// ready 已经到期的元素
+	ready *wheelBucket[T]
+
+	stats *queueStats
+	clock Clock
+}
+
+// WheelHandle 入队之后返回的句柄，用于取消元素
+type WheelHandle[T Delayable] struct {
+	entry *wheelEntry[T]
+}
+
+// NewTimingWheelDelayQueue 创建基于分层时间轮的延时队列
+// capacity <= 0 时，为无界队列；tick <= 0 时使用 1ms；wheelSize <= 0 时使用 64
+// 元素实现了 Deadlined 的时候以 Deadline 为准，否则以入队时候的 Delay 为准
+// 支持的 Option：WithObserver, WithClock
+func NewTimingWheelDelayQueue[T Delayable](capacity int, tick time.Duration,
+	wheelSize int, opts ...Option[T]) *TimingWheelDelayQueue[T] {
+	options := newQueueOptions(opts)
+	if capacity < 0 {
+		capacity = 0
+	}
+	if tick <= 0 {
+		tick = defaultWheelTick
+	}
+	if wheelSize <= 0 {
+		wheelSize = defaultWheelSize
+	}
+	m := &sync.Mutex{}
+	return &TimingWheelDelayQueue[T]{
+		mutex:         m,
+		DequeueSignal: newCond(m),
+		EnqueueSignal: newCond(m),
+		capacity:      capacity,
+		wheel:         newTimingWheel[T](int64(tick), int64(wheelSize), options.clock.Now().UnixNano()),
+		buckets: NewPriorityQueue[*wheelBucket[T]](0, func(src, dst *wheelBucket[T]) int {
+			return ComparatorRealNumber(src.expiration, dst.expiration)
+		}),
+		ready: newWheelBucket[T](),
+		stats: newQueueStats(capacity, options.observer),
+		clock: options.clock,
+	}
+}
+
+func (q *TimingWheelDelayQueue[T]) Enqueue(ctx context.Context, data T) error {
+	_, err := q.EnqueueWithHandle(ctx, data)
+	return err
+}
+
+// EnqueueWithHandle 和 Enqueue 一样，但是会返回一个句柄，之后可以通过 Cancel 取消元素
+func (q *TimingWheelDelayQueue[T]) EnqueueWithHandle(ctx context.Context, data T) (_ WheelHandle[T], err error) {
+	entry := &wheelEntry[T]{val: data}
+	var deadline time.Time
+	if d, ok := any(data).(Deadlined); ok {
+		deadline = d.Deadline()
+	} else {
+		deadline = q.clock.Now().Add(data.Delay())
+	}
+	entry.expiration = q.roundUp(deadline.UnixNano())
+
+	w := q.stats.producerWaiter()
+	defer w.done(&err)
+	for {
+		select {
+		case <-ctx.Done():
+			return WheelHandle[T]{}, ctx.Err()
+		default:
+		}
+		q.mutex.Lock()
+		if q.closed {
+			q.mutex.Unlock()
+			return WheelHandle[T]{}, errs.ErrQueueClosed
+		}
+		if q.capacity == 0 || q.count < q.capacity {
+			q.advance(q.clock.Now().UnixNano())
+			q.add(entry)
+			q.count++
+			q.stats.enqueue(1)
+			q.EnqueueSignal.broadcast()
+			return WheelHandle[T]{entry: entry}, nil
+		}
+		w.wait()
+		signalCh := q.DequeueSignal.signalCh()
+		select {
+		case <-ctx.Done():
+			return WheelHandle[T]{}, ctx.Err()
+		case <-signalCh:
+		}
+	}
+}
+
+func (q *TimingWheelDelayQueue[T]) Dequeue(ctx context.Context) (T, error) {
+	w := q.stats.consumerWaiter()
+	entry, err := q.waitReady(ctx, &w)
+	if err != nil {
+		var t T
+		return t, err
+	}
+	q.count--
+	q.stats.dequeue(1, w.waited)
+	q.DequeueSignal.broadcast()
+	return entry.val, nil
+}
+
+// waitReady 阻塞直到有元素到期，并且把它从 ready 中取出来
+// 返回 nil 的时候依旧持有锁，由调用者负责解锁；返回 error 的时候已经释放了锁
+func (q *TimingWheelDelayQueue[T]) waitReady(ctx context.Context, w *waiter) (_ *wheelEntry[T], err error) {
+	defer w.done(&err)
+	var timer Timer
+	defer func() {
+		if timer != nil {
+			timer.Stop()
+		}
+	}()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		default:
+		}
+		q.mutex.Lock()
+		now := q.clock.Now().UnixNano()
+		q.advance(now)
+		if entry := q.ready.popFront(); entry != nil {
+			return entry, nil
+		}
+		// 队列关闭了，并且元素已经被取完
+		if q.closed && q.count == 0 {
+			q.mutex.Unlock()
+			return nil, errs.ErrQueueClosed
+		}
+		w.wait()
+		// 没有非空的槽的时候 timerCh 为 nil，只能等待入队的信号
+		var timerCh <-chan time.Time
+		if next, err := q.buckets.Peek(); err == nil {
+			delay := time.Duration(next.expiration - now)
+			if timer == nil {
+				timer = q.clock.NewTimer(delay)
+			} else {
+				timer.Reset(delay)
+			}
+			timerCh = timer.C()
+		}
+		signalCh := q.EnqueueSignal.signalCh()
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-timerCh:
+		case <-signalCh:
+		}
+	}
+}
+
+// Cancel 把句柄对应的元素从队列中删除，时间复杂度 O(1)
+// 元素已经出队或者已经被取消的时候返回 false
+func (q *TimingWheelDelayQueue[T]) Cancel(h WheelHandle[T]) bool {
+	q.mutex.Lock()
+	e := h.entry
+	if e == nil || e.bucket == nil || e.queue != q {
+		q.mutex.Unlock()
+		return false
+	}
+	e.bucket.remove(e)
+	q.count--
+	q.stats.remove(1)
+	// 腾出了位置，唤醒阻塞的入队者
+	q.DequeueSignal.broadcast()
+	return true
+}
+
+// Len 队列中的元素个数，包括还没有到期的元素
+func (q *TimingWheelDelayQueue[T]) Len() int {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	return q.count
+}
+
+// Stats 返回统计信息的快照，不需要加锁
+// 等待元素到期也计算在阻塞的出队者里面
+func (q *TimingWheelDelayQueue[T]) Stats() Stats {
+	return q.stats.snapshot()
+}
+
+// Close 关闭队列，语义和 DelayQueue.Close 一样
+func (q *TimingWheelDelayQueue[T]) Close() error {
+	q.mutex.Lock()
+	if q.closed {
+		q.mutex.Unlock()
+		return nil
+	}
+	q.closed = true
+	q.DequeueSignal.broadcast()
+	q.mutex.Lock()
+	q.EnqueueSignal.broadcast()
+	return nil
+}
+
+// roundUp 向上取整到 tick，保证元素不会在到期之前被取出
+func (q *TimingWheelDelayQueue[T]) roundUp(expiration int64) int64 {
+	if expiration < 0 {
+		return 0
+	}
+	tick := q.wheel.tick
+	if rem := expiration % tick; rem != 0 {
+		if expiration > math.MaxInt64-tick {
+			return expiration - rem
+		}
+		expiration += tick - rem
+	}
+	return expiration
+}
+
+// add 把元素放入时间轮，已经到期的直接放入 ready，必须持有锁
+func (q *TimingWheelDelayQueue[T]) add(e *wheelEntry[T]) {
+	e.queue = q
+	if !q.wheel.add(e, q.buckets) {
+		q.ready.pushBack(e)
+	}
+}
+
+// advance 把所有在 now 之前到期的槽里面的元素放回时间轮，
+// 这些元素要么落到更低一层的时间轮，要么已经到期了被放入 ready，必须持有锁
+func (q *TimingWheelDelayQueue[T]) advance(now int64) {
+	for {
+		b, err := q.buckets.Peek()
+		if err != nil {
+			// 没有任何元素，直接把时间轮拨到现在
+			q.wheel.advance(now)
+			return
+		}
+		if b.expiration > now {
+			return
+		}
+		_, _ = q.buckets.dequeue()
+		q.wheel.advance(b.expiration)
+		b.flush(q.add)
+	}
+}
+
+// timingWheel 一层时间轮，时间都是 UnixNano
+type timingWheel[T Delayable] struct {
+	tick      int64
+	wheelSize int64
+	// interval 这一层时间轮能够表示的范围
+	interval int64
+	// currentTime 当前时间，是 tick 的整数倍
+	currentTime int64
+	slots       []*wheelBucket[T]
+	// overflow 上一层时间轮，按需创建
+	overflow *timingWheel[T]
+}
+
+func newTimingWheel[T Delayable](tick, wheelSize, startTime int64) *timingWheel[T] {
+	interval := int64(math.MaxInt64)
+	if tick <= math.MaxInt64/wheelSize {
+		interval = tick * wheelSize
+	}
+	slots := make([]*wheelBucket[T], wheelSize)
+	for i := range slots {
+		slots[i] = newWheelBucket[T]()
+	}
+	return &timingWheel[T]{
+		tick:        tick,
+		wheelSize:   wheelSize,
+		interval:    interval,
+		currentTime: startTime - startTime%tick,
+		slots:       slots,
+	}
+}
+
+// add 返回 false 说明元素已经到期了
+func (w *timingWheel[T]) add(e *wheelEntry[T], buckets *PriorityQueue[*wheelBucket[T]]) bool {
+	switch {
+	case e.expiration < w.currentTime+w.tick:
+		return false
+	// 用减法避免溢出
+	case e.expiration-w.currentTime < w.interval:
+		virtualID := e.expiration / w.tick
+		b := w.slots[virtualID%w.wheelSize]
+		b.pushBack(e)
+		// 槽之前是空的，或者刚刚被清空过，需要重新放入堆
+		if expiration := virtualID * w.tick; b.expiration != expiration {
+			b.expiration = expiration
+			_ = buckets.enqueue(b)
+		}
+		return true
+	default:
+		if w.overflow == nil {
+			w.overflow = newTimingWheel[T](w.interval, w.wheelSize, w.currentTime)
+		}
+		return w.overflow.add(e, buckets)
+	}
+}
+
+func (w *timingWheel[T]) advance(now int64) {
+	if now >= w.currentTime+w.tick {
+		w.currentTime = now - now%w.tick
+		if w.overflow != nil {
+			w.overflow.advance(w.currentTime)
+		}
+	}
+}
+
+type wheelEntry[T Delayable] struct {
+	val        T
+	expiration int64
+	// bucket 元素所在的槽，不在队列中的时候为 nil
+	bucket     *wheelBucket[T]
+	queue      *TimingWheelDelayQueue[T]
+	prev, next *wheelEntry[T]
+}
+
+// wheelBucket 时间轮的槽，是一个双向循环链表，这样删除是 O(1) 的
+type wheelBucket[T Delayable] struct {
+	root wheelEntry[T]
+	// expiration 槽的到期时间，不在堆中的时候为 -1
+	expiration int64
+}
+
+func newWheelBucket[T Delayable]() *wheelBucket[T] {
+	b := &wheelBucket[T]{expiration: -1}
+	b.root.prev = &b.root
+	b.root.next = &b.root
+	return b
+}
+
+func (b *wheelBucket[T]) pushBack(e *wheelEntry[T]) {
+	e.bucket = b
+	e.prev = b.root.prev
+	e.next = &b.root
+	e.prev.next = e
+	b.root.prev = e
+}
+
+func (b *wheelBucket[T]) remove(e *wheelEntry[T]) {
+	e.prev.next = e.next
+	e.next.prev = e.prev
+	e.prev, e.next, e.bucket = nil, nil, nil
+}
+
+func (b *wheelBucket[T]) popFront() *wheelEntry[T] {
+	e := b.root.next
+	if e == &b.root {
+		return nil
+	}
+	b.remove(e)
+	return e
+}
+
+// flush 取出所有的元素交给 fn，并且重置到期时间
+func (b *wheelBucket[T]) flush(fn func(e *wheelEntry[T])) {
+	b.expiration = -1
+	for e := b.popFront(); e != nil; e = b.popFront() {
+		fn(e)
+	}
+}
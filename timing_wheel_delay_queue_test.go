@@ -0,0 +1,219 @@
+package concurrent_queue
+
+import (
+	"concurrent_queue/errs"
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestTimingWheelDelayQueue(t *testing.T) {
+	t.Parallel()
+	now := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock := NewManualClock(now)
+	// 第一层 10ms，第二层 100ms，第三层 1s
+	q := NewTimingWheelDelayQueue[deadlineElem](0, time.Millisecond, 10, WithClock[deadlineElem](clock))
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+	delays := []time.Duration{
+		time.Millisecond * 500,
+		time.Millisecond * 3,
+		time.Millisecond*3 + time.Microsecond,
+		time.Millisecond * 50,
+		time.Second * 2,
+		0,
+	}
+	for i, d := range delays {
+		require.NoError(t, q.Enqueue(ctx, deadlineElem{deadline: now.Add(d), val: i}))
+	}
+	assert.Equal(t, 6, q.Len())
+
+	// 已经到期的元素立刻就能取出来
+	val, err := q.Dequeue(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 5, val.val)
+
+	res := make(chan int, len(delays))
+	go func() {
+		for {
+			val, err := q.Dequeue(ctx)
+			if err != nil {
+				return
+			}
+			res <- val.val
+		}
+	}()
+	testCases := []struct {
+		// 推进到 now + at
+		at   time.Duration
+		want []int
+	}{
+		{at: time.Millisecond * 2},
+		{at: time.Millisecond * 3, want: []int{1}},
+		// 向上取整到 tick，晚一个 tick
+		{at: time.Millisecond * 4, want: []int{2}},
+		{at: time.Millisecond * 49},
+		{at: time.Millisecond * 50, want: []int{3}},
+		{at: time.Millisecond * 499},
+		{at: time.Millisecond * 500, want: []int{0}},
+		{at: time.Millisecond * 1999},
+		{at: time.Second * 2, want: []int{4}},
+	}
+	for _, tc := range testCases {
+		// 等待出队者开始等待
+		clock.BlockUntil(1)
+		clock.Set(now.Add(tc.at))
+		for _, want := range tc.want {
+			assert.Equal(t, want, <-res, tc.at)
+		}
+		select {
+		case v := <-res:
+			t.Fatalf("%v 的时候不应该有元素到期，但是取出了 %d", tc.at, v)
+		case <-time.After(time.Millisecond * 10):
+		}
+	}
+	assert.Equal(t, 0, q.Len())
+
+	require.NoError(t, q.Close())
+	assert.Equal(t, errs.ErrQueueClosed, q.Enqueue(ctx, deadlineElem{deadline: now}))
+	_, err = q.Dequeue(ctx)
+	assert.Equal(t, errs.ErrQueueClosed, err)
+}
+
+func TestTimingWheelDelayQueue_Cancel(t *testing.T) {
+	t.Parallel()
+	q := NewTimingWheelDelayQueue[delayElem](2, time.Millisecond, 8)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	now := time.Now()
+	h1, err := q.EnqueueWithHandle(ctx, delayElem{deadline: now.Add(time.Millisecond * 20), val: 1})
+	require.NoError(t, err)
+	h2, err := q.EnqueueWithHandle(ctx, delayElem{deadline: now.Add(time.Hour), val: 2})
+	require.NoError(t, err)
+
+	// 队列满了，取消之后入队者会被唤醒
+	go func() {
+		time.Sleep(time.Millisecond * 10)
+		assert.True(t, q.Cancel(h2))
+	}()
+	_, err = q.EnqueueWithHandle(ctx, delayElem{deadline: now.Add(time.Millisecond * 50), val: 3})
+	require.NoError(t, err)
+	assert.False(t, q.Cancel(h2))
+
+	assert.True(t, q.Cancel(h1))
+	assert.False(t, q.Cancel(h1))
+	assert.False(t, q.Cancel(WheelHandle[delayElem]{}))
+	val, err := q.Dequeue(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 3, val.val)
+	assert.True(t, time.Since(now) >= time.Millisecond*50)
+
+	// 已经到期但是还没有被取走的元素也可以取消
+	h4, err := q.EnqueueWithHandle(ctx, delayElem{deadline: now, val: 4})
+	require.NoError(t, err)
+	assert.True(t, q.Cancel(h4))
+	timeoutCtx, timeoutCancel := context.WithTimeout(context.Background(), time.Millisecond*20)
+	defer timeoutCancel()
+	_, err = q.Dequeue(timeoutCtx)
+	assert.Equal(t, context.DeadlineExceeded, err)
+	stats := q.Stats()
+	assert.Equal(t, 0, stats.Len)
+	assert.Equal(t, uint64(4), stats.Enqueued)
+	assert.Equal(t, uint64(1), stats.Dequeued)
+}
+
+func TestTimingWheelDelayQueue_Concurrent(t *testing.T) {
+	t.Parallel()
+	q := NewTimingWheelDelayQueue[delayElem](0, time.Millisecond, 4)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+	const n = 1000
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < n/4; j++ {
+				d := time.Duration((i*n/4+j)%100) * time.Millisecond
+				_ = q.Enqueue(ctx, delayElem{deadline: time.Now().Add(d), val: i})
+			}
+		}(i)
+	}
+	var mutex sync.Mutex
+	cnt := 0
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < n/4; j++ {
+				val, err := q.Dequeue(ctx)
+				if !assert.NoError(t, err) {
+					return
+				}
+				// 不会提前出队
+				assert.True(t, val.Delay() <= 0)
+				mutex.Lock()
+				cnt++
+				mutex.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, n, cnt)
+	assert.Equal(t, 0, q.Len())
+}
+
+// 大量短时间的超时，入队之后大部分会被取消，这是时间轮擅长的场景
+func BenchmarkTimingWheelDelayQueue_EnqueueCancel(b *testing.B) {
+	q := NewTimingWheelDelayQueue[delayElem](0, time.Millisecond, 64)
+	benchmarkDelayEnqueueCancel(b, q.EnqueueWithHandle, q.Cancel)
+}
+
+func BenchmarkDelayQueue_EnqueueCancel(b *testing.B) {
+	q := NewDelayQueue[delayElem](0)
+	benchmarkDelayEnqueueCancel(b, q.EnqueueWithHandle, q.Cancel)
+}
+
+func benchmarkDelayEnqueueCancel[H any](b *testing.B,
+	enqueue func(ctx context.Context, t delayElem) (H, error), cancel func(h H) bool) {
+	ctx := context.Background()
+	now := time.Now()
+	for i := 0; i < 100000; i++ {
+		_, _ = enqueue(ctx, delayElem{deadline: now.Add(time.Hour + time.Duration(i)*time.Millisecond)})
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		h, _ := enqueue(ctx, delayElem{deadline: now.Add(time.Duration(i%10000) * time.Millisecond)})
+		cancel(h)
+	}
+}
+
+// 元素已经到期，衡量入队和出队本身的开销
+func BenchmarkTimingWheelDelayQueue_EnqueueDequeue(b *testing.B) {
+	q := NewTimingWheelDelayQueue[delayElem](0, time.Millisecond, 64)
+	benchmarkDelayEnqueueDequeue(b, q)
+}
+
+func BenchmarkDelayQueue_EnqueueDequeue(b *testing.B) {
+	q := NewDelayQueue[delayElem](0)
+	benchmarkDelayEnqueueDequeue(b, q)
+}
+
+func benchmarkDelayEnqueueDequeue(b *testing.B, q BlockingQueue[delayElem]) {
+	ctx := context.Background()
+	now := time.Now()
+	// 预先放入大量还没有到期的元素，堆的深度会影响 DelayQueue 的性能
+	for i := 0; i < 100000; i++ {
+		_ = q.Enqueue(ctx, delayElem{deadline: now.Add(time.Hour + time.Duration(i)*time.Millisecond)})
+	}
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			_ = q.Enqueue(ctx, delayElem{deadline: now})
+			_, _ = q.Dequeue(ctx)
+		}
+	})
+}
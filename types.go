@@ -33,7 +33,60 @@ type BlockingQueue[T any] interface {
 	Dequeue(ctx context.Context) (T, error)
 }
 
+// Closer 可以被关闭的队列
+// 关闭之后，入队会返回 errs.ErrQueueClosed，阻塞在入队上的 goroutine 会被立刻唤醒；
+// 出队依旧可以取走队列中剩余的元素，直到队列为空之后才返回 errs.ErrQueueClosed。
+// 这样调用者就可以区分"队列关闭"和"ctx 超时"两种情况，并且不会丢失剩余的元素
+type Closer interface {
+	// Close 关闭队列，重复调用不会有任何效果
+	Close() error
+}
+
+// ClosableBlockingQueue 可以被关闭的阻塞队列
+type ClosableBlockingQueue[T any] interface {
+	BlockingQueue[T]
+	Closer
+}
+
+// SizedBlockingQueue 可以获得元素个数的阻塞队列
+type SizedBlockingQueue[T any] interface {
+	BlockingQueue[T]
+	Len() int
+}
+
+// Meta 元素在队列中的元信息，需要在创建队列的时候通过 WithSojournTracking 开启
+type Meta struct {
+	// EnqueuedAt 入队时间
+	EnqueuedAt time.Time
+	// Sojourn 元素在队列中停留的时间，也就是从入队到出队的时间
+	Sojourn time.Duration
+}
+
+func newMeta(enqueuedAt time.Time) Meta {
+	if enqueuedAt.IsZero() {
+		return Meta{}
+	}
+	return Meta{
+		EnqueuedAt: enqueuedAt,
+		Sojourn:    time.Since(enqueuedAt),
+	}
+}
+
+// Delayable 延时队列中的元素
 type Delayable interface {
+	// Delay 距离到期还有多长时间，小于等于 0 说明已经到期了
 	Delay() time.Duration
-	// Deadline() time.Time
+}
+
+// Deadlined 有绝对到期时间的元素
+// 如果 DelayQueue 中的元素实现了这个接口，那么以 Deadline 为准，不会再调用 Delay
+type Deadlined interface {
+	Deadline() time.Time
+}
+
+// Expirable 会过期的元素
+// 如果元素实现了这个接口，那么 ArrayBlockingQueue 和 LinkedBlockingQueue 在出队的时候会丢弃已经过期的元素
+type Expirable interface {
+	// Expiry 距离过期还有多长时间，小于等于 0 说明已经过期了
+	Expiry() time.Duration
 }
@@ -0,0 +1,223 @@
+package concurrent_queue
+
+import (
+	"concurrent_queue/errs"
+	"context"
+	"github.com/ecodeclub/ekit/list"
+	"golang.org/x/sync/semaphore"
+	"math"
+	"sync"
+)
+
+// WeightedBlockingQueue 按照元素的开销而不是个数来限制容量的并发阻塞队列
+// 比如说用元素的字节数作为开销，那么 budget 就是队列最多占用的内存
+// 入队的时候会一直阻塞，直到剩余的预算足够放下该元素。
+// 预算的分配是 FIFO 的，所以一个开销很大的元素会挡住后面的入队者，但是不会被饿死
+type WeightedBlockingQueue[T any] struct {
+	mutex      *sync.RWMutex
+	linkedlist *list.LinkedList[weightedItem[T]]
+
+	// 总预算
+	budget int64
+	// 已经使用的预算，受 mutex 保护
+	used int64
+	cost func(T) int64
+
+	// 令牌的数量就是剩余的预算
+	enqueueCap *semaphore.Weighted
+	// 令牌的数量就是队列中元素的个数
+	dequeueCap *semaphore.Weighted
+
+	// 队列是否已经关闭，受 mutex 保护
+	closed bool
+	// 关闭的时候会 close 掉，用于唤醒阻塞在信号量上的 goroutine
+	closeCh chan struct{}
+
+	// 元素的个数没有上限，所以 Cap 为 0，预算通过 Stats 的 Budget 和 Used 单独统计
+	stats *queueStats
+}
+
+// weightedItem 记录入队时候计算的开销，确保出队的时候归还的预算和入队时候一致
+type weightedItem[T any] struct {
+	val  T
+	cost int64
+}
+
+// NewWeightedBlockingQueue 创建一个按照开销限制容量的阻塞队列
+// budget 是总预算，必须为正数；cost 用于计算单个元素的开销，返回负数的时候按照 0 处理
+func NewWeightedBlockingQueue[T any](budget int64, cost func(T) int64) *WeightedBlockingQueue[T] {
+	semaForDequeue := semaphore.NewWeighted(math.MaxInt64)
+	// 相当于将信号量置空
+	_ = semaForDequeue.Acquire(context.TODO(), math.MaxInt64)
+	return &WeightedBlockingQueue[T]{
+		mutex:      &sync.RWMutex{},
+		linkedlist: list.NewLinkedList[weightedItem[T]](),
+		budget:     budget,
+		cost:       cost,
+		enqueueCap: semaphore.NewWeighted(budget),
+		dequeueCap: semaForDequeue,
+		closeCh:    make(chan struct{}),
+		stats:      newQueueStats(0, NopObserver{}),
+	}
+}
+
+// Enqueue 入队，会一直阻塞直到剩余的预算足够放下该元素
+// 单个元素的开销超过总预算的时候，返回 errs.ErrOutOfCapacity
+func (q *WeightedBlockingQueue[T]) Enqueue(ctx context.Context, t T) error {
+	cost, err := q.costOf(t)
+	if err != nil {
+		return err
+	}
+	w := q.stats.producerWaiter()
+	if err = acquireUntilClosed(ctx, q.enqueueCap, cost, q.closeCh, &w); err != nil {
+		return err
+	}
+
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	// 拿到锁，先判断是否超时，防止在抢锁时已经超时
+	if ctx.Err() != nil {
+		q.enqueueCap.Release(cost)
+		return ctx.Err()
+	}
+	return q.enqueue(t, cost)
+}
+
+// TryEnqueue 尝试入队，不会阻塞
+// 剩余预算不足或者队列已经关闭的时候返回 false
+func (q *WeightedBlockingQueue[T]) TryEnqueue(t T) bool {
+	cost, err := q.costOf(t)
+	if err != nil {
+		return false
+	}
+	if !q.enqueueCap.TryAcquire(cost) {
+		return false
+	}
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	return q.enqueue(t, cost) == nil
+}
+
+// enqueue 调用者必须持有 cost 个 enqueueCap 的令牌以及锁
+func (q *WeightedBlockingQueue[T]) enqueue(t T, cost int64) error {
+	if q.closed {
+		q.enqueueCap.Release(cost)
+		return errs.ErrQueueClosed
+	}
+	if err := q.linkedlist.Append(weightedItem[T]{val: t, cost: cost}); err != nil {
+		q.enqueueCap.Release(cost)
+		return err
+	}
+	q.used += cost
+	q.stats.enqueue(1)
+	q.dequeueCap.Release(1)
+	return nil
+}
+
+func (q *WeightedBlockingQueue[T]) costOf(t T) (int64, error) {
+	cost := q.cost(t)
+	if cost < 0 {
+		cost = 0
+	}
+	// 永远都放不下
+	if cost > q.budget {
+		return 0, errs.ErrOutOfCapacity
+	}
+	return cost, nil
+}
+
+// Dequeue 出队，并且归还该元素占用的预算
+func (q *WeightedBlockingQueue[T]) Dequeue(ctx context.Context) (T, error) {
+	var t T
+	w := q.stats.consumerWaiter()
+	if err := acquireUntilClosed(ctx, q.dequeueCap, 1, q.closeCh, &w); err != nil {
+		return t, err
+	}
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	if ctx.Err() != nil {
+		q.dequeueCap.Release(1)
+		return t, ctx.Err()
+	}
+	return q.dequeue()
+}
+
+// TryDequeue 尝试出队，不会阻塞
+// 队列为空的时候返回 false
+func (q *WeightedBlockingQueue[T]) TryDequeue() (T, bool) {
+	if !q.dequeueCap.TryAcquire(1) {
+		var t T
+		return t, false
+	}
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	t, err := q.dequeue()
+	return t, err == nil
+}
+
+// dequeue 调用者必须持有 dequeueCap 的令牌以及锁
+func (q *WeightedBlockingQueue[T]) dequeue() (T, error) {
+	item, err := q.linkedlist.Delete(0)
+	if err != nil {
+		var t T
+		return t, err
+	}
+	q.used -= item.cost
+	q.stats.dequeue(1, 0)
+	// 归还预算，入队的goroutine可以拿到并入队
+	q.enqueueCap.Release(item.cost)
+	return item.val, nil
+}
+
+// Close 关闭队列
+// 关闭之后入队会返回 errs.ErrQueueClosed，出队在取完剩余的元素之后返回 errs.ErrQueueClosed
+func (q *WeightedBlockingQueue[T]) Close() error {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	if q.closed {
+		return nil
+	}
+	q.closed = true
+	close(q.closeCh)
+	return nil
+}
+
+// Len 队列中元素的个数
+func (q *WeightedBlockingQueue[T]) Len() int {
+	q.mutex.RLock()
+	defer q.mutex.RUnlock()
+	return q.linkedlist.Len()
+}
+
+// Used 队列中元素的总开销
+func (q *WeightedBlockingQueue[T]) Used() int64 {
+	q.mutex.RLock()
+	defer q.mutex.RUnlock()
+	return q.used
+}
+
+// Stats 返回统计信息的快照
+// Len、Cap 和 HighWaterMark 都是按照元素个数统计的，Cap 为 0；
+// 开销相关的数据在 Budget 和 Used 里面
+func (q *WeightedBlockingQueue[T]) Stats() Stats {
+	res := q.stats.snapshot()
+	res.Budget = q.budget
+	res.Used = q.Used()
+	return res
+}
+
+// Budget 总预算
+func (q *WeightedBlockingQueue[T]) Budget() int64 {
+	return q.budget
+}
+
+func (q *WeightedBlockingQueue[T]) AsSlice() []T {
+	q.mutex.RLock()
+	defer q.mutex.RUnlock()
+	res := make([]T, 0, q.linkedlist.Len())
+	_ = q.linkedlist.Range(func(index int, t weightedItem[T]) error {
+		res = append(res, t.val)
+		return nil
+	})
+	return res
+}
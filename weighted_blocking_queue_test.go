@@ -0,0 +1,178 @@
+package concurrent_queue
+
+import (
+	"concurrent_queue/errs"
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestWeightedBlockingQueue_Enqueue(t *testing.T) {
+	testCases := []struct {
+		name      string
+		q         func() *WeightedBlockingQueue[string]
+		val       string
+		timeout   time.Duration
+		wantErr   error
+		wantSlice []string
+		wantUsed  int64
+	}{
+		{
+			name: "empty and enqueued",
+			q: func() *WeightedBlockingQueue[string] {
+				return newWeightedBlockingQueue(10)
+			},
+			val:       "abc",
+			timeout:   time.Second,
+			wantSlice: []string{"abc"},
+			wantUsed:  3,
+		},
+		{
+			name: "invalid context",
+			q: func() *WeightedBlockingQueue[string] {
+				return newWeightedBlockingQueue(10)
+			},
+			val:       "abc",
+			timeout:   -time.Second,
+			wantSlice: []string{},
+			wantErr:   context.DeadlineExceeded,
+		},
+		{
+			// 单个元素就超过了总预算
+			name: "larger than budget",
+			q: func() *WeightedBlockingQueue[string] {
+				return newWeightedBlockingQueue(2)
+			},
+			val:       "abc",
+			timeout:   time.Second,
+			wantSlice: []string{},
+			wantErr:   errs.ErrOutOfCapacity,
+		},
+		{
+			// 剩余预算不够，阻塞到超时
+			name: "not enough budget",
+			q: func() *WeightedBlockingQueue[string] {
+				q := newWeightedBlockingQueue(5)
+				require.NoError(t, q.Enqueue(context.Background(), "abc"))
+				return q
+			},
+			val:       "abc",
+			timeout:   time.Millisecond * 100,
+			wantSlice: []string{"abc"},
+			wantUsed:  3,
+			wantErr:   context.DeadlineExceeded,
+		},
+		{
+			// 剩余预算刚好够
+			name: "exactly enough budget",
+			q: func() *WeightedBlockingQueue[string] {
+				q := newWeightedBlockingQueue(5)
+				require.NoError(t, q.Enqueue(context.Background(), "abc"))
+				return q
+			},
+			val:       "de",
+			timeout:   time.Second,
+			wantSlice: []string{"abc", "de"},
+			wantUsed:  5,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), tc.timeout)
+			defer cancel()
+			q := tc.q()
+			err := q.Enqueue(ctx, tc.val)
+			assert.Equal(t, tc.wantErr, err)
+			assert.Equal(t, tc.wantSlice, q.AsSlice())
+			assert.Equal(t, tc.wantUsed, q.Used())
+			assert.Equal(t, len(tc.wantSlice), q.Len())
+		})
+	}
+
+	// 入队阻塞，而后出队归还了预算，于是入队成功
+	t.Run("enqueue blocking and dequeue", func(t *testing.T) {
+		q := newWeightedBlockingQueue(5)
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		require.NoError(t, q.Enqueue(ctx, "abcd"))
+		go func() {
+			time.Sleep(time.Millisecond * 100)
+			val, err := q.Dequeue(ctx)
+			require.NoError(t, err)
+			require.Equal(t, "abcd", val)
+		}()
+		require.NoError(t, q.Enqueue(ctx, "efg"))
+		assert.Equal(t, int64(3), q.Used())
+	})
+}
+
+func TestWeightedBlockingQueue_Dequeue(t *testing.T) {
+	q := newWeightedBlockingQueue(5)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*100)
+	defer cancel()
+	_, err := q.Dequeue(ctx)
+	assert.Equal(t, context.DeadlineExceeded, err)
+	_, ok := q.TryDequeue()
+	assert.False(t, ok)
+
+	assert.True(t, q.TryEnqueue("ab"))
+	assert.True(t, q.TryEnqueue("cde"))
+	assert.False(t, q.TryEnqueue("f"))
+	val, ok := q.TryDequeue()
+	assert.True(t, ok)
+	assert.Equal(t, "ab", val)
+	assert.Equal(t, int64(3), q.Used())
+
+	require.NoError(t, q.Close())
+	assert.False(t, q.TryEnqueue("f"))
+	assert.Equal(t, errs.ErrQueueClosed, q.Enqueue(context.Background(), "f"))
+	val, err = q.Dequeue(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "cde", val)
+	_, err = q.Dequeue(context.Background())
+	assert.Equal(t, errs.ErrQueueClosed, err)
+	assert.Equal(t, int64(0), q.Used())
+}
+
+func TestWeightedBlockingQueue(t *testing.T) {
+	// 并发测试，只是测试有没有死锁之类的问题
+	q := newWeightedBlockingQueue(100)
+	var wg sync.WaitGroup
+	wg.Add(200)
+	for i := 0; i < 100; i++ {
+		go func() {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+			err := q.Enqueue(ctx, "abcdefghij")
+			require.NoError(t, err)
+		}()
+		go func() {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+			_, err := q.Dequeue(ctx)
+			require.NoError(t, err)
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, int64(0), q.Used())
+	stats := q.Stats()
+	assert.Equal(t, uint64(100), stats.Enqueued)
+	assert.Equal(t, uint64(100), stats.Dequeued)
+	assert.Equal(t, 0, stats.Len)
+	assert.Equal(t, 0, stats.Cap)
+	assert.Equal(t, int64(100), stats.Budget)
+	assert.Equal(t, int64(0), stats.Used)
+	assert.Equal(t, 0, stats.BlockedProducers+stats.BlockedConsumers)
+}
+
+func newWeightedBlockingQueue(budget int64) *WeightedBlockingQueue[string] {
+	return NewWeightedBlockingQueue[string](budget, func(s string) int64 {
+		return int64(len(s))
+	})
+}